@@ -0,0 +1,79 @@
+// Command usage-export writes the hourly usage of customers over a date
+// range as CSV or JSON Lines, for invoicing. Hours that are not settled yet
+// are left out, so running it again for the same range writes the same file.
+//
+//	go run ./cmd/usage-export -from 2025-01-01 -to 2025-02-01 -format csv -o january.csv
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/metering"
+)
+
+func main() {
+	fromFlag := flag.String("from", "", "start of the range, a date such as 2025-01-01 (UTC) or an RFC 3339 time")
+	toFlag := flag.String("to", "", "end of the range, excluded")
+	formatFlag := flag.String("format", "csv", "csv or jsonl")
+	out := flag.String("o", "", "file to write, standard output when empty")
+	flag.Parse()
+
+	from, err := metering.ParseTime(*fromFlag)
+	if err != nil {
+		log.Fatalf("-from: %v", err)
+	}
+	to, err := metering.ParseTime(*toFlag)
+	if err != nil {
+		log.Fatalf("-to: %v", err)
+	}
+	format, err := metering.ParseFormat(*formatFlag)
+	if err != nil {
+		log.Fatalf("-format: %v", err)
+	}
+
+	if err := run(from, to, format, *out); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func run(from, to time.Time, format metering.Format, out string) error {
+	db := database.New()
+	defer db.Close()
+
+	ctx := context.Background()
+	if out == "" {
+		_, _, err := metering.Export(ctx, db.Metering(), from, to, time.Now(), format, os.Stdout)
+		return err
+	}
+
+	// Write next to the target and rename, so a failed run never leaves a
+	// partial export behind
+	tmp, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating export: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	from, to, err = metering.Export(ctx, db.Metering(), from, to, time.Now(), format, tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "exported usage from %s to %s into %s\n",
+		from.Format(time.RFC3339), to.Format(time.RFC3339), out)
+	return nil
+}
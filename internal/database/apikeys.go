@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"api-rate-limiting/internal/pkg/apikey"
+)
+
+// APIKeys stores the API keys of customers, hashed. It implements
+// apikey.Store.
+type APIKeys struct {
+	db *sql.DB
+}
+
+// EnsureSchema creates the api_keys table when it does not exist yet. The
+// plaintext keys customers held before are moved into it hashed, as legacy
+// keys granting every scope. It must run after Plans.EnsureSchema.
+func (k *APIKeys) EnsureSchema(ctx context.Context) error {
+	for _, stmt := range []string{`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id         text        PRIMARY KEY,
+			customer   text        NOT NULL REFERENCES customers (id),
+			hash       bytea       NOT NULL UNIQUE,
+			scopes     text        NOT NULL DEFAULT '',
+			expires_at timestamptz,
+			revoked_at timestamptz,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX IF NOT EXISTS api_keys_customer ON api_keys (customer)`, `
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'customers' AND column_name = 'api_key'
+			) THEN
+				INSERT INTO api_keys (id, customer, hash, scopes)
+				SELECT 'legacy_' || id, id, sha256(convert_to(api_key, 'UTF8')), '*' FROM customers
+				ON CONFLICT DO NOTHING;
+				ALTER TABLE customers DROP COLUMN api_key;
+			END IF;
+		END
+		$$`,
+	} {
+		if _, err := k.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error creating api_keys: %v", err)
+		}
+	}
+	return nil
+}
+
+// queryRower is a *sql.DB or *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const apiKeyColumns = `id, customer, hash, scopes, expires_at, revoked_at, created_at`
+
+// scanAPIKey reads a row of apiKeyColumns.
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (apikey.Key, error) {
+	var key apikey.Key
+	var scopes string
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Customer, &key.Hash, &scopes, &expiresAt, &revokedAt, &key.CreatedAt); err != nil {
+		return apikey.Key{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.ExpiresAt = expiresAt.Time
+	key.RevokedAt = revokedAt.Time
+	return key, nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Create stores a new key and returns it as stored.
+func (k *APIKeys) Create(ctx context.Context, key apikey.Key) (apikey.Key, error) {
+	key, err := createAPIKey(ctx, k.db, key)
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error creating API key: %v", err)
+	}
+	return key, nil
+}
+
+func createAPIKey(ctx context.Context, q queryRower, key apikey.Key) (apikey.Key, error) {
+	return scanAPIKey(q.QueryRowContext(ctx, `
+		INSERT INTO api_keys (id, customer, hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+apiKeyColumns,
+		key.ID, key.Customer, key.Hash, strings.Join(key.Scopes, " "), nullTime(key.ExpiresAt),
+	))
+}
+
+// Key returns the key with the given ID, or ErrNotFound.
+func (k *APIKeys) Key(ctx context.Context, id string) (apikey.Key, error) {
+	key, err := scanAPIKey(k.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return apikey.Key{}, ErrNotFound
+	}
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error loading API key: %v", err)
+	}
+	return key, nil
+}
+
+// Keys returns every key of customer, newest first.
+func (k *APIKeys) Keys(ctx context.Context, customer string) ([]apikey.Key, error) {
+	rows, err := k.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys WHERE customer = $1 ORDER BY created_at DESC, id`,
+		customer,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing API keys: %v", err)
+	}
+	defer rows.Close()
+
+	list := []apikey.Key{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error listing API keys: %v", err)
+		}
+		list = append(list, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing API keys: %v", err)
+	}
+	return list, nil
+}
+
+// Revoke revokes the key with the given ID from at on, unless it was
+// revoked earlier. It returns the key, or ErrNotFound.
+func (k *APIKeys) Revoke(ctx context.Context, id string, at time.Time) (apikey.Key, error) {
+	key, err := revokeAPIKey(ctx, k.db, id, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apikey.Key{}, ErrNotFound
+	}
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error revoking API key: %v", err)
+	}
+	return key, nil
+}
+
+func revokeAPIKey(ctx context.Context, q queryRower, id string, at time.Time) (apikey.Key, error) {
+	return scanAPIKey(q.QueryRowContext(ctx, `
+		UPDATE api_keys SET revoked_at = LEAST(COALESCE(revoked_at, $2::timestamptz), $2::timestamptz)
+		WHERE id = $1
+		RETURNING `+apiKeyColumns,
+		id, at,
+	))
+}
+
+// Rotate replaces the key with the given ID by next in one transaction,
+// revoking the old key from revokeAt on so clients can switch over. It
+// returns next as stored, or ErrNotFound.
+func (k *APIKeys) Rotate(ctx context.Context, id string, next apikey.Key, revokeAt time.Time) (apikey.Key, error) {
+	tx, err := k.db.BeginTx(ctx, nil)
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error rotating API key: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := revokeAPIKey(ctx, tx, id, revokeAt); errors.Is(err, sql.ErrNoRows) {
+		return apikey.Key{}, ErrNotFound
+	} else if err != nil {
+		return apikey.Key{}, fmt.Errorf("error rotating API key: %v", err)
+	}
+	next, err = createAPIKey(ctx, tx, next)
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error rotating API key: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return apikey.Key{}, fmt.Errorf("error rotating API key: %v", err)
+	}
+	return next, nil
+}
+
+// Lookup implements apikey.Store.
+func (k *APIKeys) Lookup(ctx context.Context, id string, hash []byte) (apikey.Key, error) {
+	var row *sql.Row
+	if id != "" {
+		row = k.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id)
+	} else {
+		row = k.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE hash = $1`, hash)
+	}
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apikey.Key{}, apikey.ErrUnknownKey
+	}
+	if err != nil {
+		return apikey.Key{}, fmt.Errorf("error looking up API key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Counters is a ratelimit.Backend counting hits in a Postgres table, so
+// every replica connected to the database shares the same limits. Windows
+// are aligned on the database clock rather than on the replicas'.
+type Counters struct {
+	db *sql.DB
+}
+
+// EnsureSchema creates the counters table when it does not exist yet.
+func (c *Counters) EnsureSchema(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS ratelimit_counters (
+			key        text        NOT NULL,
+			window_end timestamptz NOT NULL,
+			count      integer     NOT NULL,
+			last_grant integer     NOT NULL,
+			PRIMARY KEY (key, window_end)
+		)`)
+	if err != nil {
+		return fmt.Errorf("error creating ratelimit_counters: %v", err)
+	}
+	return nil
+}
+
+// Acquire implements ratelimit.Backend in a single upsert, so concurrent
+// replicas never take more than limit tokens in a window between them.
+func (c *Counters) Acquire(ctx context.Context, key string, n, limit int, window time.Duration) (ratelimit.Grant, error) {
+	var granted, count int
+	var reset time.Time
+	err := c.db.QueryRowContext(ctx, `
+		INSERT INTO ratelimit_counters AS c (key, window_end, count, last_grant)
+		VALUES (
+			$1,
+			to_timestamp((floor(extract(epoch FROM now()) / $4::float8) + 1) * $4::float8),
+			GREATEST(LEAST($2::integer, $3::integer), 0),
+			GREATEST(LEAST($2::integer, $3::integer), 0)
+		)
+		ON CONFLICT (key, window_end) DO UPDATE SET
+			count      = c.count + LEAST($2::integer, GREATEST($3::integer - c.count, 0)),
+			last_grant = LEAST($2::integer, GREATEST($3::integer - c.count, 0))
+		RETURNING last_grant, count, window_end`,
+		key, n, limit, window.Seconds(),
+	).Scan(&granted, &count, &reset)
+	if err != nil {
+		return ratelimit.Grant{}, fmt.Errorf("error acquiring tokens: %v", err)
+	}
+
+	return ratelimit.Grant{Granted: granted, Remaining: max(limit-count, 0), Reset: reset}, nil
+}
+
+// Release implements ratelimit.Backend.
+func (c *Counters) Release(ctx context.Context, key string, n int, reset time.Time) error {
+	_, err := c.db.ExecContext(ctx, `
+		UPDATE ratelimit_counters SET count = GREATEST(count - $2::integer, 0)
+		WHERE key = $1 AND window_end = $3`,
+		key, n, reset,
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing tokens: %v", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes the counters of the windows that ended, returning
+// how many were removed.
+func (c *Counters) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM ratelimit_counters WHERE window_end <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired counters: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// RunJanitor deletes the expired counters every interval until ctx is done.
+func (c *Counters) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
@@ -22,6 +22,21 @@ type Service interface {
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
 	Close() error
+
+	// Counters returns the rate limit counters shared by every replica.
+	Counters() *Counters
+
+	// Plans returns the subscription plans and the customers on them.
+	Plans() *Plans
+
+	// Usage returns the consumption of customers' quotas.
+	Usage() *Usage
+
+	// Metering returns the hourly usage rollups billing is based on.
+	Metering() *Metering
+
+	// APIKeys returns the API keys customers authenticate with.
+	APIKeys() *APIKeys
 }
 
 type service struct {
@@ -67,7 +82,7 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf("db down: %v", err) // Log the error and terminate the program
+		log.Printf("db down: %v", err) // Log the error, the dashboard keeps polling health
 		return stats
 	}
 
@@ -105,6 +120,31 @@ func (s *service) Health() map[string]string {
 	return stats
 }
 
+// Counters returns the rate limit counters stored in this database.
+func (s *service) Counters() *Counters {
+	return &Counters{db: s.db}
+}
+
+// Plans returns the plans and customers stored in this database.
+func (s *service) Plans() *Plans {
+	return &Plans{db: s.db}
+}
+
+// Usage returns the quota usage stored in this database.
+func (s *service) Usage() *Usage {
+	return &Usage{db: s.db}
+}
+
+// Metering returns the usage rollups stored in this database.
+func (s *service) Metering() *Metering {
+	return &Metering{db: s.db}
+}
+
+// APIKeys returns the API keys stored in this database.
+func (s *service) APIKeys() *APIKeys {
+	return &APIKeys{db: s.db}
+}
+
 // Close closes the database connection.
 // It logs a message indicating the disconnection from the specific database.
 // If the connection is successfully closed, it returns nil.
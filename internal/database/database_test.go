@@ -1,11 +1,18 @@
 package database
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"log"
 	"testing"
 	"time"
 
+	"api-rate-limiting/internal/pkg/apikey"
+	"api-rate-limiting/internal/pkg/metering"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/ratelimit"
+
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -98,3 +105,179 @@ func TestClose(t *testing.T) {
 		t.Fatalf("expected Close() to return nil")
 	}
 }
+
+func TestCountersShareLimit(t *testing.T) {
+	ctx := context.Background()
+	counters := New().Counters()
+	if err := counters.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+
+	key := t.Name()
+	first, err := counters.Acquire(ctx, key, 6, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	second, err := counters.Acquire(ctx, key, 6, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if first.Granted != 6 || second.Granted != 4 || second.Remaining != 0 {
+		t.Fatalf("got grants %d and %d with %d remaining, want 6 and 4 with 0",
+			first.Granted, second.Granted, second.Remaining)
+	}
+	if !first.Reset.Equal(second.Reset) {
+		t.Errorf("grants reset at %v and %v, want the same window", first.Reset, second.Reset)
+	}
+
+	if err := counters.Release(ctx, key, 3, second.Reset); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	third, err := counters.Acquire(ctx, key, 5, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if third.Granted != 3 {
+		t.Errorf("got %d tokens after releasing 3, want 3", third.Granted)
+	}
+}
+
+func TestPlans(t *testing.T) {
+	ctx := context.Background()
+	store := New().Plans()
+	if err := store.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+
+	pro := plans.Plan{Name: "pro", Limits: map[string]plans.Limit{
+		"/fixed": {Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 100, Window: time.Minute},
+	}}
+	if err := store.SavePlan(ctx, pro); err != nil {
+		t.Fatalf("SavePlan: %v", err)
+	}
+	if _, err := store.SaveCustomer(ctx, Customer{ID: "acme", Plan: "pro"}); err != nil {
+		t.Fatalf("SaveCustomer: %v", err)
+	}
+
+	sub, err := store.Subscription(ctx, "acme")
+	if err != nil {
+		t.Fatalf("Subscription: %v", err)
+	}
+	if sub.Customer != "acme" || sub.Plan.Limits["/fixed"] != pro.Limits["/fixed"] {
+		t.Errorf("got %+v want acme on pro", sub)
+	}
+	if _, err := store.Subscription(ctx, "bogus"); !errors.Is(err, plans.ErrUnknownCustomer) {
+		t.Errorf("got %v want ErrUnknownCustomer", err)
+	}
+	if _, err := store.SaveCustomer(ctx, Customer{ID: "acme", Plan: "missing"}); err == nil {
+		t.Errorf("customer was assigned to a missing plan")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	store := New().Plans()
+	keys := New().APIKeys()
+	if err := store.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+	if err := keys.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+	if err := store.SavePlan(ctx, plans.Plan{Name: "keys"}); err != nil {
+		t.Fatalf("SavePlan: %v", err)
+	}
+	if _, err := store.SaveCustomer(ctx, Customer{ID: "keys-customer", Plan: "keys"}); err != nil {
+		t.Fatalf("SaveCustomer: %v", err)
+	}
+
+	first, secret := apikey.Generate("keys-customer", []string{"usage:read"}, time.Time{})
+	if _, err := keys.Create(ctx, first); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	got, err := keys.Lookup(ctx, first.ID, nil)
+	if err != nil || !bytes.Equal(got.Hash, apikey.Hash(secret)) || !got.HasScope("usage:read") {
+		t.Fatalf("got %+v, %v; want the created key", got, err)
+	}
+	if _, err := keys.Lookup(ctx, "", apikey.Hash(secret)); err != nil {
+		t.Errorf("lookup by hash: %v", err)
+	}
+
+	next, _ := apikey.Generate("keys-customer", first.Scopes, time.Time{})
+	revokeAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	if _, err := keys.Rotate(ctx, first.ID, next, revokeAt); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	list, err := keys.Keys(ctx, "keys-customer")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("got %d keys, %v; want 2", len(list), err)
+	}
+	if old, _ := keys.Key(ctx, first.ID); !old.RevokedAt.Equal(revokeAt) {
+		t.Errorf("got old key revoked at %v, want %v", old.RevokedAt, revokeAt)
+	}
+
+	if _, err := keys.Revoke(ctx, "missing", time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v want ErrNotFound", err)
+	}
+	if _, err := keys.Lookup(ctx, "missing", nil); !errors.Is(err, apikey.ErrUnknownKey) {
+		t.Errorf("got %v want ErrUnknownKey", err)
+	}
+}
+
+func TestUsageStopsAtLimit(t *testing.T) {
+	ctx := context.Background()
+	usage := New().Usage()
+	if err := usage.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		used, ok, err := usage.Consume(ctx, "acme", "/instagram/download", start, 1, 2)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+		if ok != want || used != min(i+1, 2) {
+			t.Errorf("request %d: got ok %v, used %d; want %v, %d", i, ok, used, want, min(i+1, 2))
+		}
+	}
+
+	list, err := usage.Usage(ctx, "acme", start)
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if len(list) != 1 || list[0].Used != 2 || !list[0].PeriodStart.Equal(start) {
+		t.Errorf("got %+v want 2 used in March", list)
+	}
+}
+
+func TestMeteringAppliesBatchOnce(t *testing.T) {
+	ctx := context.Background()
+	store := New().Metering()
+	if err := store.EnsureSchema(ctx); err != nil {
+		t.Fatalf("EnsureSchema: %v", err)
+	}
+
+	hour := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	rows := []metering.Row{{Customer: "acme", Route: "/fixed", Hour: hour, Requests: 5}}
+	for i := 0; i < 2; i++ {
+		if err := store.Apply(ctx, "batch-1", rows); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	if err := store.Apply(ctx, "batch-2", rows); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	var got []metering.Row
+	err := store.Rows(ctx, hour, hour.Add(time.Hour), func(r metering.Row) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Rows: %v", err)
+	}
+	if len(got) != 1 || got[0].Requests != 10 {
+		t.Errorf("got %+v want 10 requests from two batches", got)
+	}
+}
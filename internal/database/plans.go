@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"api-rate-limiting/internal/pkg/plans"
+)
+
+// ErrNotFound is returned when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Customer is a client of the API and the plan they are on.
+type Customer struct {
+	ID        string    `json:"id"`
+	Plan      string    `json:"plan"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Plans stores subscription plans and the customers assigned to them. It
+// implements plans.Source.
+type Plans struct {
+	db *sql.DB
+}
+
+// EnsureSchema creates the plans and customers tables when they do not
+// exist yet.
+func (p *Plans) EnsureSchema(ctx context.Context) error {
+	for _, stmt := range []string{`
+		CREATE TABLE IF NOT EXISTS plans (
+			name       text        PRIMARY KEY,
+			limits     jsonb       NOT NULL DEFAULT '{}',
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE plans ADD COLUMN IF NOT EXISTS quotas jsonb NOT NULL DEFAULT '{}'`, `
+		CREATE TABLE IF NOT EXISTS customers (
+			id         text        PRIMARY KEY,
+			plan       text        NOT NULL REFERENCES plans (name),
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	} {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error creating plans and customers: %v", err)
+		}
+	}
+	return nil
+}
+
+// SavePlan creates the plan, or replaces the limits and quotas of the plan
+// with the same name.
+func (p *Plans) SavePlan(ctx context.Context, plan plans.Plan) error {
+	limits, err := json.Marshal(plan.Limits)
+	if err != nil {
+		return fmt.Errorf("error encoding limits: %v", err)
+	}
+	quotas, err := json.Marshal(plan.Quotas)
+	if err != nil {
+		return fmt.Errorf("error encoding quotas: %v", err)
+	}
+
+	_, err = p.db.ExecContext(ctx, `
+		INSERT INTO plans (name, limits, quotas) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET limits = EXCLUDED.limits, quotas = EXCLUDED.quotas, updated_at = now()`,
+		plan.Name, limits, quotas,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving plan: %v", err)
+	}
+	return nil
+}
+
+// Plan implements plans.Source, returning the named plan.
+func (p *Plans) Plan(ctx context.Context, name string) (plans.Plan, error) {
+	var limits, quotas []byte
+	err := p.db.QueryRowContext(ctx, `SELECT limits, quotas FROM plans WHERE name = $1`, name).Scan(&limits, &quotas)
+	if errors.Is(err, sql.ErrNoRows) {
+		return plans.Plan{}, plans.ErrUnknownPlan
+	}
+	if err != nil {
+		return plans.Plan{}, fmt.Errorf("error loading plan: %v", err)
+	}
+	return decodePlan(name, limits, quotas)
+}
+
+// ListPlans returns every plan sorted by name.
+func (p *Plans) ListPlans(ctx context.Context) ([]plans.Plan, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT name, limits, quotas FROM plans ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing plans: %v", err)
+	}
+	defer rows.Close()
+
+	list := []plans.Plan{}
+	for rows.Next() {
+		var name string
+		var limits, quotas []byte
+		if err := rows.Scan(&name, &limits, &quotas); err != nil {
+			return nil, fmt.Errorf("error listing plans: %v", err)
+		}
+		plan, err := decodePlan(name, limits, quotas)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing plans: %v", err)
+	}
+	return list, nil
+}
+
+func decodePlan(name string, limits, quotas []byte) (plans.Plan, error) {
+	plan := plans.Plan{Name: name}
+	if err := json.Unmarshal(limits, &plan.Limits); err != nil {
+		return plans.Plan{}, fmt.Errorf("error decoding limits of plan %s: %v", name, err)
+	}
+	if err := json.Unmarshal(quotas, &plan.Quotas); err != nil {
+		return plans.Plan{}, fmt.Errorf("error decoding quotas of plan %s: %v", name, err)
+	}
+	return plan, nil
+}
+
+// SaveCustomer creates the customer, or moves the customer with the same ID
+// to c.Plan. It returns the stored customer.
+func (p *Plans) SaveCustomer(ctx context.Context, c Customer) (Customer, error) {
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO customers (id, plan) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET plan = EXCLUDED.plan
+		RETURNING created_at`,
+		c.ID, c.Plan,
+	).Scan(&c.CreatedAt)
+	if err != nil {
+		return Customer{}, fmt.Errorf("error saving customer: %v", err)
+	}
+	return c, nil
+}
+
+// Customer returns the customer with the given ID, or ErrNotFound.
+func (p *Plans) Customer(ctx context.Context, id string) (Customer, error) {
+	c := Customer{ID: id}
+	err := p.db.QueryRowContext(ctx, `SELECT plan, created_at FROM customers WHERE id = $1`, id).
+		Scan(&c.Plan, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Customer{}, ErrNotFound
+	}
+	if err != nil {
+		return Customer{}, fmt.Errorf("error loading customer: %v", err)
+	}
+	return c, nil
+}
+
+// Subscription implements plans.Source.
+func (p *Plans) Subscription(ctx context.Context, customer string) (plans.Subscription, error) {
+	var name string
+	var limits, quotas []byte
+	err := p.db.QueryRowContext(ctx, `
+		SELECT p.name, p.limits, p.quotas
+		FROM customers c JOIN plans p ON p.name = c.plan
+		WHERE c.id = $1`,
+		customer,
+	).Scan(&name, &limits, &quotas)
+	if errors.Is(err, sql.ErrNoRows) {
+		return plans.Subscription{}, plans.ErrUnknownCustomer
+	}
+	if err != nil {
+		return plans.Subscription{}, fmt.Errorf("error loading subscription: %v", err)
+	}
+
+	plan, err := decodePlan(name, limits, quotas)
+	if err != nil {
+		return plans.Subscription{}, err
+	}
+	return plans.Subscription{Customer: customer, Plan: plan}, nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"api-rate-limiting/internal/pkg/quota"
+)
+
+// Usage stores the consumption of customers' quotas per period. It
+// implements quota.Store.
+type Usage struct {
+	db *sql.DB
+}
+
+// EnsureSchema creates the quota_usage table when it does not exist yet.
+func (u *Usage) EnsureSchema(ctx context.Context) error {
+	_, err := u.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS quota_usage (
+			customer     text        NOT NULL,
+			quota        text        NOT NULL,
+			period_start timestamptz NOT NULL,
+			used         integer     NOT NULL,
+			PRIMARY KEY (customer, quota, period_start)
+		)`)
+	if err != nil {
+		return fmt.Errorf("error creating quota_usage: %v", err)
+	}
+	return nil
+}
+
+// Consume implements quota.Store in a single upsert, so concurrent replicas
+// never count more than limit between them.
+func (u *Usage) Consume(ctx context.Context, customer, quota string, start time.Time, n, limit int) (int, bool, error) {
+	var used int
+	err := u.db.QueryRowContext(ctx, `
+		INSERT INTO quota_usage AS u (customer, quota, period_start, used)
+		SELECT $1::text, $2::text, $3::timestamptz, $4::integer WHERE $4::integer <= $5::integer
+		ON CONFLICT (customer, quota, period_start) DO UPDATE SET used = u.used + EXCLUDED.used
+		WHERE u.used + EXCLUDED.used <= $5::integer
+		RETURNING used`,
+		customer, quota, start, n, limit,
+	).Scan(&used)
+	if err == nil {
+		return used, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, fmt.Errorf("error consuming quota: %v", err)
+	}
+
+	// Over the limit, nothing was written
+	err = u.db.QueryRowContext(ctx, `
+		SELECT used FROM quota_usage WHERE customer = $1 AND quota = $2 AND period_start = $3`,
+		customer, quota, start,
+	).Scan(&used)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, fmt.Errorf("error loading quota usage: %v", err)
+	}
+	return used, false, nil
+}
+
+// Usage implements quota.Store.
+func (u *Usage) Usage(ctx context.Context, customer string, since time.Time) ([]quota.Usage, error) {
+	rows, err := u.db.QueryContext(ctx, `
+		SELECT quota, period_start, used FROM quota_usage
+		WHERE customer = $1 AND period_start >= $2
+		ORDER BY period_start DESC, quota`,
+		customer, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing quota usage: %v", err)
+	}
+	defer rows.Close()
+
+	list := []quota.Usage{}
+	for rows.Next() {
+		var usage quota.Usage
+		if err := rows.Scan(&usage.Quota, &usage.PeriodStart, &usage.Used); err != nil {
+			return nil, fmt.Errorf("error listing quota usage: %v", err)
+		}
+		list = append(list, usage)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing quota usage: %v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,257 @@
+// Package apikey authenticates callers by API key. Keys look like
+// rl_<prefix>_<secret>: the prefix identifies the key for lookups and in
+// listings, while only a hash of the whole key is stored.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+var (
+	// ErrUnknownKey is returned for keys that were never issued.
+	ErrUnknownKey = errors.New("invalid API key")
+	// ErrRevoked is returned for keys that were revoked.
+	ErrRevoked = errors.New("API key revoked")
+	// ErrExpired is returned for keys past their expiry.
+	ErrExpired = errors.New("API key expired")
+)
+
+// AllScopes grants every scope.
+const AllScopes = "*"
+
+// Key is an issued API key, without its secret.
+type Key struct {
+	// ID is the prefix of the key.
+	ID       string   `json:"id"`
+	Customer string   `json:"customer"`
+	Scopes   []string `json:"scopes"`
+	// ExpiresAt is zero for keys that never expire.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// RevokedAt is zero for keys that were not revoked.
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Hash is the SHA-256 hash of the whole key.
+	Hash []byte `json:"-"`
+}
+
+// HasScope reports whether the key grants scope.
+func (k Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, AllScopes)
+}
+
+// Check returns why the key cannot be used at now, nil when it can.
+func (k Key) Check(now time.Time) error {
+	switch {
+	case !k.RevokedAt.IsZero() && !now.Before(k.RevokedAt):
+		return ErrRevoked
+	case !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt):
+		return ErrExpired
+	}
+	return nil
+}
+
+// Generate returns a new key for customer and its secret, the only time
+// the secret is known.
+func Generate(customer string, scopes []string, expiresAt time.Time) (Key, string) {
+	id := make([]byte, 6)
+	rand.Read(id)
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	k := Key{
+		ID:        hex.EncodeToString(id),
+		Customer:  customer,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	full := "rl_" + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = Hash(full)
+	return k, full
+}
+
+// Parse returns the ID of a key, false when it is not shaped like one,
+// e.g. for keys issued before prefixes.
+func Parse(secret string) (string, bool) {
+	parts := strings.SplitN(secret, "_", 3)
+	if len(parts) != 3 || parts[0] != "rl" || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// Hash returns the hash of a key as stored.
+func Hash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// Store looks up issued keys.
+type Store interface {
+	// Lookup returns the key with the given ID, or the key with the given
+	// hash when id is empty. It returns ErrUnknownKey when there is none.
+	Lookup(ctx context.Context, id string, hash []byte) (Key, error)
+}
+
+// Config configures an Authenticator. Zero values get the defaults noted below.
+type Config struct {
+	// TTL is how long a looked up key is reused (default 30s). It bounds
+	// how long other replicas accept a revoked key.
+	TTL time.Duration
+	// MaxKeys bounds the number of cached keys (default 10000).
+	MaxKeys int
+	// MaxMisses bounds the number of cached unknown keys (default 1000), so
+	// guessed keys cannot evict the known ones.
+	MaxMisses int
+	// Clock is the time source (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// Authenticator checks API keys against a Store, caching the lookups.
+type Authenticator struct {
+	store Store
+	cfg   Config
+
+	// The caches are keyed by the hex encoded hash of the presented key
+	mu     sync.Mutex
+	keys   *ratelimit.Cache[Key]
+	misses *ratelimit.Cache[struct{}]
+}
+
+// NewAuthenticator returns an Authenticator looking up keys in store.
+func NewAuthenticator(store Store, cfg Config) *Authenticator {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 30 * time.Second
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 10000
+	}
+	if cfg.MaxMisses <= 0 {
+		cfg.MaxMisses = 1000
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+	return &Authenticator{
+		store:  store,
+		cfg:    cfg,
+		keys:   ratelimit.NewCache[Key]("apikey_keys", cfg.MaxKeys),
+		misses: ratelimit.NewCache[struct{}]("apikey_misses", cfg.MaxMisses),
+	}
+}
+
+// Authenticate returns the key presented as secret, or why it cannot be
+// used. Expiry is checked on every call, revocation when the cached lookup
+// expires or the key is invalidated.
+func (a *Authenticator) Authenticate(ctx context.Context, secret string) (Key, error) {
+	now := a.cfg.Clock.Now()
+	hash := Hash(secret)
+	cacheKey := hex.EncodeToString(hash)
+
+	a.mu.Lock()
+	a.keys.Sweep(now)
+	a.misses.Sweep(now)
+	key, found := a.keys.Get(cacheKey, now)
+	_, missed := a.misses.Get(cacheKey, now)
+	a.mu.Unlock()
+	if missed {
+		return Key{}, ErrUnknownKey
+	}
+	if !found {
+		id, _ := Parse(secret)
+		var err error
+		key, err = a.store.Lookup(ctx, id, hash)
+		if err == nil && subtle.ConstantTimeCompare(key.Hash, hash) != 1 {
+			// Right prefix, wrong secret
+			err = ErrUnknownKey
+		}
+		if errors.Is(err, ErrUnknownKey) {
+			a.mu.Lock()
+			a.misses.Set(cacheKey, struct{}{}, now, now.Add(a.cfg.TTL))
+			a.mu.Unlock()
+			return Key{}, err
+		}
+		if err != nil {
+			// Not an answer about the key, ask again next time
+			return Key{}, err
+		}
+
+		a.mu.Lock()
+		a.keys.Set(cacheKey, key, now, now.Add(a.cfg.TTL))
+		a.mu.Unlock()
+	}
+
+	if err := key.Check(now); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// Invalidate forgets the cached lookups of the key with the given ID, e.g.
+// once it was revoked.
+func (a *Authenticator) Invalidate(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var hashes []string
+	a.keys.Each(func(hash string, key Key) {
+		if key.ID == id {
+			hashes = append(hashes, hash)
+		}
+	})
+	for _, hash := range hashes {
+		a.keys.Delete(hash)
+	}
+}
+
+// MemoryStore is a Store keeping keys in this process, for tests.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+// NewMemoryStore returns a MemoryStore holding keys.
+func NewMemoryStore(keys ...Key) *MemoryStore {
+	s := &MemoryStore{keys: make(map[string]Key)}
+	for _, k := range keys {
+		s.Save(k)
+	}
+	return s
+}
+
+// Save adds k, or replaces the key with the same ID.
+func (s *MemoryStore) Save(k Key) {
+	s.mu.Lock()
+	s.keys[k.ID] = k
+	s.mu.Unlock()
+}
+
+// Lookup implements Store.
+func (s *MemoryStore) Lookup(_ context.Context, id string, hash []byte) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		if k, ok := s.keys[id]; ok {
+			return k, nil
+		}
+		return Key{}, ErrUnknownKey
+	}
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare(k.Hash, hash) == 1 {
+			return k, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
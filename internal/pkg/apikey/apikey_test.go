@@ -0,0 +1,145 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// countingStore counts the lookups of the MemoryStore it wraps.
+type countingStore struct {
+	*MemoryStore
+	err     error
+	lookups int
+}
+
+func (s *countingStore) Lookup(ctx context.Context, id string, hash []byte) (Key, error) {
+	s.lookups++
+	if s.err != nil {
+		return Key{}, s.err
+	}
+	return s.MemoryStore.Lookup(ctx, id, hash)
+}
+
+func TestGenerate(t *testing.T) {
+	key, secret := Generate("acme", []string{"usage:read"}, time.Time{})
+
+	id, ok := Parse(secret)
+	if !ok || id != key.ID {
+		t.Errorf("got ID %q, %v from %q; want %q", id, ok, secret, key.ID)
+	}
+	if string(key.Hash) != string(Hash(secret)) {
+		t.Errorf("key does not hold the hash of its secret")
+	}
+	if other, _ := Generate("acme", nil, time.Time{}); other.ID == key.ID {
+		t.Errorf("two keys got the same ID %q", key.ID)
+	}
+	if _, ok := Parse("legacy-key"); ok {
+		t.Errorf("parsed a key without prefix")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	key, secret := Generate("acme", []string{AllScopes}, time.Time{})
+	// Keys issued before prefixes are looked up by hash
+	legacy := Key{ID: "legacy_acme", Customer: "acme", Scopes: []string{AllScopes}, Hash: Hash("legacy-key")}
+	store := &countingStore{MemoryStore: NewMemoryStore(key, legacy)}
+	auth := NewAuthenticator(store, Config{TTL: time.Minute, Clock: clock})
+	ctx := context.Background()
+
+	wrongSecret := "rl_" + key.ID + "_guessed"
+	for i := 0; i < 3; i++ {
+		if got, err := auth.Authenticate(ctx, secret); err != nil || got.Customer != "acme" {
+			t.Fatalf("got %+v, %v; want acme", got, err)
+		}
+		if got, err := auth.Authenticate(ctx, "legacy-key"); err != nil || got.ID != legacy.ID {
+			t.Fatalf("got %+v, %v; want the legacy key", got, err)
+		}
+		if _, err := auth.Authenticate(ctx, wrongSecret); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("right prefix with the wrong secret: got %v want ErrUnknownKey", err)
+		}
+	}
+	if store.lookups != 3 {
+		t.Errorf("got %d lookups want 3", store.lookups)
+	}
+
+	key.RevokedAt = clock.Now()
+	store.Save(key)
+	if _, err := auth.Authenticate(ctx, secret); err != nil {
+		t.Errorf("got %v before the cached key expired, want it accepted", err)
+	}
+	auth.Invalidate(key.ID)
+	if _, err := auth.Authenticate(ctx, secret); !errors.Is(err, ErrRevoked) {
+		t.Errorf("got %v after Invalidate, want ErrRevoked", err)
+	}
+}
+
+func TestAuthenticateDoesNotCacheErrors(t *testing.T) {
+	key, secret := Generate("acme", nil, time.Time{})
+	store := &countingStore{MemoryStore: NewMemoryStore(key), err: errors.New("connection refused")}
+	auth := NewAuthenticator(store, Config{})
+
+	if _, err := auth.Authenticate(context.Background(), secret); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got %v, want the store error", err)
+	}
+	store.err = nil
+	if got, err := auth.Authenticate(context.Background(), secret); err != nil || got.ID != key.ID {
+		t.Errorf("got %+v, %v after the store recovered, want the key", got, err)
+	}
+}
+
+func TestAuthenticateBoundsMisses(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	key, secret := Generate("acme", []string{AllScopes}, time.Time{})
+	store := &countingStore{MemoryStore: NewMemoryStore(key)}
+	auth := NewAuthenticator(store, Config{TTL: time.Minute, MaxKeys: 1, MaxMisses: 2, Clock: clock})
+	ctx := context.Background()
+
+	if _, err := auth.Authenticate(ctx, secret); err != nil {
+		t.Fatalf("got %v want the key", err)
+	}
+	// Guessed keys evict each other, not the known key
+	for i := 0; i < 5; i++ {
+		if _, err := auth.Authenticate(ctx, fmt.Sprintf("guess-%d", i)); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("guess %d: got %v want ErrUnknownKey", i, err)
+		}
+	}
+	if _, err := auth.Authenticate(ctx, secret); err != nil {
+		t.Fatalf("got %v want the key", err)
+	}
+	if store.lookups != 6 {
+		t.Errorf("got %d lookups want 6", store.lookups)
+	}
+
+	// Lookups are repeated once they expire
+	clock.Advance(time.Minute)
+	auth.Authenticate(ctx, secret)
+	if store.lookups != 7 {
+		t.Errorf("got %d lookups after the TTL want 7", store.lookups)
+	}
+}
+
+func TestKeyCheck(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		key  Key
+		want error
+	}{
+		{"active", Key{}, nil},
+		{"expires later", Key{ExpiresAt: now.Add(time.Second)}, nil},
+		{"expired", Key{ExpiresAt: now}, ErrExpired},
+		{"revoked later", Key{RevokedAt: now.Add(time.Hour)}, nil},
+		{"revoked", Key{RevokedAt: now.Add(-time.Hour)}, ErrRevoked},
+	}
+	for _, tt := range tests {
+		if err := tt.key.Check(now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v want %v", tt.name, err, tt.want)
+		}
+	}
+}
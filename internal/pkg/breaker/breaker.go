@@ -0,0 +1,292 @@
+// Package breaker implements a circuit breaker that stops calling a failing
+// upstream for a while, so callers fail fast instead of waiting on it.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// StateClosed lets every call through and counts failures.
+	StateClosed State = iota
+	// StateHalfOpen lets a limited number of probe calls through to test
+	// whether the upstream recovered.
+	StateHalfOpen
+	// StateOpen rejects every call until the open timeout expires.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText renders the state by name in JSON.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrOpen is returned by Allow while the breaker rejects calls.
+var ErrOpen = errors.New("circuit breaker is open")
+
+var (
+	stateGauge = metrics.NewGaugeVec(
+		"circuit_breaker_state",
+		"Circuit breaker state (0 closed, 1 half-open, 2 open).",
+		"name",
+	)
+	transitionsTotal = metrics.NewCounterVec(
+		"circuit_breaker_transitions_total",
+		"Circuit breaker state changes by target state.",
+		"name", "state",
+	)
+	rejectedTotal = metrics.NewCounterVec(
+		"circuit_breaker_rejected_total",
+		"Calls rejected by an open circuit breaker.",
+		"name",
+	)
+)
+
+// Config configures a Breaker. Zero values get the defaults noted below.
+type Config struct {
+	Name string
+	// ConsecutiveFailures opens the breaker after that many failures in a
+	// row (default 5).
+	ConsecutiveFailures int
+	// FailureRatio opens the breaker when the share of failed calls in the
+	// current interval reaches it, once MinRequests calls were made.
+	// Zero disables the ratio check.
+	FailureRatio float64
+	MinRequests  int
+	// Interval is how long closed state counts are kept (default 1m).
+	Interval time.Duration
+	// OpenTimeout is how long the breaker stays open before probing the
+	// upstream again (default 30s).
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probe calls let through while half
+	// open; all of them must succeed to close the breaker (default 1).
+	HalfOpenRequests int
+}
+
+// Status is a snapshot of a breaker for health checks and the admin API.
+type Status struct {
+	Name                string    `json:"name"`
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Requests            int       `json:"requests"`
+	Failures            int       `json:"failures"`
+	OpenedAt            time.Time `json:"opened_at,omitzero"`
+	RetryAfter          string    `json:"retry_after,omitempty"`
+}
+
+// Breaker is a circuit breaker safe for concurrent use.
+type Breaker struct {
+	cfg Config
+
+	mu          sync.Mutex
+	state       State
+	consecutive int
+	requests    int
+	failures    int
+	probes      int
+	successes   int
+	intervalEnd time.Time
+	openedAt    time.Time
+}
+
+// New creates a closed breaker.
+func New(cfg Config) *Breaker {
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+
+	b := &Breaker{cfg: cfg, intervalEnd: time.Now().Add(cfg.Interval)}
+	stateGauge.Set(float64(StateClosed), cfg.Name)
+	return b
+}
+
+// Allow reports whether a call may proceed. When it returns nil the caller
+// must report the outcome of the call with Done, or give it up with Cancel.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.advance(now)
+
+	switch b.state {
+	case StateOpen:
+		rejectedTotal.Inc(b.cfg.Name)
+		return ErrOpen
+	case StateHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			rejectedTotal.Inc(b.cfg.Name)
+			return ErrOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Done records the outcome of a call let through by Allow.
+func (b *Breaker) Done(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.advance(now)
+
+	switch b.state {
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		b.requests++
+		if success {
+			b.consecutive = 0
+			return
+		}
+		b.consecutive++
+		b.failures++
+		if b.tripped() {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+// Cancel gives back a call let through by Allow that ended without an
+// outcome, e.g. because the caller went away, so it counts neither as a
+// success nor as a failure. A probe call frees its slot for the next one.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	if b.state == StateHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// tripped reports whether the closed state counts exceed a threshold.
+func (b *Breaker) tripped() bool {
+	if b.consecutive >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	return b.cfg.FailureRatio > 0 && b.requests >= max(b.cfg.MinRequests, 1) &&
+		float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio
+}
+
+// advance applies the transitions that only depend on time: an open breaker
+// becomes half open after its timeout, and closed counts reset every interval.
+// Must be called with mu held.
+func (b *Breaker) advance(now time.Time) {
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+			b.setState(StateHalfOpen, now)
+		}
+	case StateClosed:
+		if now.After(b.intervalEnd) {
+			b.requests, b.failures = 0, 0
+			b.intervalEnd = now.Add(b.cfg.Interval)
+		}
+	}
+}
+
+// setState switches to state and resets its counters. Must be called with mu held.
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.probes, b.successes = 0, 0
+
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.consecutive, b.requests, b.failures = 0, 0, 0
+		b.intervalEnd = now.Add(b.cfg.Interval)
+		b.openedAt = time.Time{}
+	}
+
+	stateGauge.Set(float64(state), b.cfg.Name)
+	transitionsTotal.Inc(b.cfg.Name, state.String())
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	return b.state
+}
+
+// RetryAfter returns how long until an open breaker lets probe calls through.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.retryAfter(time.Now())
+}
+
+func (b *Breaker) retryAfter(now time.Time) time.Duration {
+	b.advance(now)
+	switch b.state {
+	case StateOpen:
+		return b.cfg.OpenTimeout - now.Sub(b.openedAt)
+	case StateHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return time.Second
+		}
+	}
+	return 0
+}
+
+// Status returns a snapshot of the breaker.
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	s := Status{
+		Name:                b.cfg.Name,
+		ConsecutiveFailures: b.consecutive,
+		Requests:            b.requests,
+		Failures:            b.failures,
+	}
+	if d := b.retryAfter(now); d > 0 {
+		s.RetryAfter = d.Round(time.Second).String()
+	}
+	s.State = b.state
+	s.OpenedAt = b.openedAt
+	return s
+}
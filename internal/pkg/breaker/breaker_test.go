@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := New(Config{Name: "consecutive-test", ConsecutiveFailures: 3, OpenTimeout: 50 * time.Millisecond})
+
+	call := func(success bool) error {
+		if err := b.Allow(); err != nil {
+			return err
+		}
+		b.Done(success)
+		return nil
+	}
+
+	// A success in between resets the consecutive count
+	call(false)
+	call(false)
+	call(true)
+	call(false)
+	call(false)
+	if state := b.State(); state != StateClosed {
+		t.Fatalf("after interrupted failures: got %s want closed", state)
+	}
+
+	call(false)
+	if state := b.State(); state != StateOpen {
+		t.Fatalf("after 3 consecutive failures: got %s want open", state)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("while open: got %v want ErrOpen", err)
+	}
+	if d := b.RetryAfter(); d <= 0 || d > 50*time.Millisecond {
+		t.Errorf("RetryAfter: got %v", d)
+	}
+
+	// After the timeout a single probe is let through
+	time.Sleep(60 * time.Millisecond)
+	if state := b.State(); state != StateHalfOpen {
+		t.Fatalf("after the open timeout: got %s want half-open", state)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe: got %v want nil", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("second probe: got %v want ErrOpen", err)
+	}
+
+	// A failed probe opens the breaker again, a successful one closes it
+	b.Done(false)
+	if state := b.State(); state != StateOpen {
+		t.Fatalf("after a failed probe: got %s want open", state)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := call(true); err != nil {
+		t.Fatalf("probe: got %v want nil", err)
+	}
+	if state := b.State(); state != StateClosed {
+		t.Errorf("after a successful probe: got %s want closed", state)
+	}
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b := New(Config{Name: "ratio-test", ConsecutiveFailures: 100, FailureRatio: 0.5, MinRequests: 4})
+
+	for _, success := range []bool{false, true, true} {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		b.Done(success)
+	}
+	if state := b.State(); state != StateClosed {
+		t.Fatalf("below MinRequests: got %s want closed", state)
+	}
+
+	b.Allow()
+	b.Done(false)
+	if state := b.State(); state != StateOpen {
+		t.Errorf("at 50%% failures: got %s want open", state)
+	}
+	if got := stateGauge.Value("ratio-test"); got != float64(StateOpen) {
+		t.Errorf("state gauge: got %v want %v", got, float64(StateOpen))
+	}
+}
+
+func TestBreakerCancelReleasesProbe(t *testing.T) {
+	b := New(Config{Name: "cancel-test", ConsecutiveFailures: 1, OpenTimeout: 10 * time.Millisecond})
+
+	b.Allow()
+	b.Done(false)
+	time.Sleep(20 * time.Millisecond)
+
+	// A cancelled probe neither closes nor reopens the breaker
+	if err := b.Allow(); err != nil {
+		t.Fatalf("half-open probe: %v", err)
+	}
+	b.Cancel()
+	if state := b.State(); state != StateHalfOpen {
+		t.Fatalf("after a cancelled probe: got %s want half-open", state)
+	}
+
+	// and gives its slot to the next probe
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe after cancel: %v", err)
+	}
+	b.Done(true)
+	if state := b.State(); state != StateClosed {
+		t.Errorf("after a successful probe: got %s want closed", state)
+	}
+}
@@ -0,0 +1,244 @@
+// Package jwtauth verifies JSON Web Tokens issued by an identity provider,
+// signed with HS256, RS256 or ES256, using only the standard library. The
+// claims of verified tokens travel in the request context, so rate limiters
+// can key clients on them.
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// ErrInvalidToken is wrapped by every error of Verify.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+// KeyPrefix prefixes the customer IDs and rate limit keys taken from tokens,
+// so subjects never collide with the customers of API keys or client IPs.
+const KeyPrefix = "jwt:"
+
+// String returns the claim called name when it is a string, "" otherwise.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the sub claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// Customer returns the customer ID of the subject, prefixed with KeyPrefix.
+func (c Claims) Customer() string {
+	return KeyPrefix + c.Subject()
+}
+
+// Audience returns the aud claim, which may be a string or a list.
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		var list []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// numericDate returns the NumericDate claim called name, false when it is
+// absent.
+func (c Claims) numericDate(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+	sec, frac := int64(n), n-float64(int64(n))
+	return time.Unix(sec, int64(frac*float64(time.Second))), true, nil
+}
+
+// Config configures a Verifier. Zero values get the defaults noted below.
+type Config struct {
+	// Issuer is the iss claim tokens must carry (default any).
+	Issuer string
+	// Audience is a value the aud claim of tokens must hold (default any).
+	Audience string
+	// Leeway tolerates clock skew with the issuer on exp and nbf (default none).
+	Leeway time.Duration
+	// Clock is the time source (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// Verifier verifies tokens against a KeySet.
+type Verifier struct {
+	keys *KeySet
+	cfg  Config
+}
+
+// NewVerifier returns a Verifier accepting tokens signed with keys.
+func NewVerifier(keys *KeySet, cfg Config) *Verifier {
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+	return &Verifier{keys: keys, cfg: cfg}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature of token and its exp, nbf, iss and aud claims,
+// returning its claims. Tokens must expire.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := v.keys.verify(h, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims of a token.
+func (v *Verifier) validate(claims Claims) error {
+	now := v.cfg.Clock.Now()
+
+	exp, ok, err := claims.numericDate("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(v.cfg.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	nbf, ok, err := claims.numericDate("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	if v.cfg.Issuer != "" && claims.String("iss") != v.cfg.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.cfg.Audience != "" && !slices.Contains(claims.Audience(), v.cfg.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks sig over input with key under alg.
+func verifySignature(alg string, key any, input, sig []byte) bool {
+	sum := sha256.Sum256(input)
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims.
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims carried by ctx.
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// ClaimKey returns a KeyFunc keying clients on the string claim called name
+// of their verified token, prefixed with KeyPrefix, falling back to the
+// client IP for requests without one.
+func ClaimKey(name string) ratelimit.KeyFunc {
+	return func(r *http.Request) string {
+		if claims, ok := FromContext(r.Context()); ok {
+			if key := claims.String(name); key != "" {
+				return KeyPrefix + key
+			}
+		}
+		return ratelimit.ClientIP(r)
+	}
+}
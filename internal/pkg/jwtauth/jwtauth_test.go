@@ -0,0 +1,213 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+var (
+	hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, _  = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _   = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+// mint returns a token with claims, signed under alg with the test key of
+// that algorithm.
+func mint(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	h := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	input := segment(t, h) + "." + segment(t, claims)
+	sum := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, hmacSecret)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case RS256:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:]); err != nil {
+			t.Fatalf("error signing: %v", err)
+		}
+	case ES256:
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, sum[:])
+		if err != nil {
+			t.Fatalf("error signing: %v", err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// tamper replaces the claims of token, keeping its signature.
+func tamper(token, claims string) string {
+	parts := strings.Split(token, ".")
+	return parts[0] + "." + claims + "." + parts[2]
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testJWKS returns a JWKS holding the test keys.
+func testJWKS() []byte {
+	data, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64(hmacSecret)},
+		{"kty": "RSA", "kid": "rs", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		// Skipped: not for signatures, and an unsupported curve
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+	}})
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	keys, err := ParseJWKS(testJWKS())
+	if err != nil {
+		t.Fatalf("ParseJWKS: %v", err)
+	}
+	if keys.Len() != 3 {
+		t.Fatalf("got %d keys want 3", keys.Len())
+	}
+	v := NewVerifier(keys, Config{
+		Issuer:   "https://id.example.com",
+		Audience: "rate-limiting-api",
+		Leeway:   30 * time.Second,
+		Clock:    ratelimittest.NewFakeClock(now),
+	})
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"sub":  "alice",
+			"iss":  "https://id.example.com",
+			"aud":  []string{"other", "rate-limiting-api"},
+			"exp":  now.Add(time.Hour).Unix(),
+			"nbf":  now.Unix(),
+			"tier": "pro",
+		}
+	}
+	with := func(name string, value any) map[string]any {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", mint(t, HS256, "hs", valid()), true},
+		{"RS256", mint(t, RS256, "rs", valid()), true},
+		{"ES256", mint(t, ES256, "es", valid()), true},
+		{"without kid", mint(t, ES256, "", valid()), true},
+		{"audience as a string", mint(t, HS256, "hs", with("aud", "rate-limiting-api")), true},
+		{"expired within leeway", mint(t, HS256, "hs", with("exp", now.Add(-10*time.Second).Unix())), true},
+		{"expired", mint(t, HS256, "hs", with("exp", now.Add(-time.Minute).Unix())), false},
+		{"without expiry", mint(t, HS256, "hs", with("exp", nil)), false},
+		{"not valid yet", mint(t, HS256, "hs", with("nbf", now.Add(time.Minute).Unix())), false},
+		{"wrong issuer", mint(t, HS256, "hs", with("iss", "https://evil.example.com")), false},
+		{"wrong audience", mint(t, HS256, "hs", with("aud", "other")), false},
+		{"unknown kid", mint(t, HS256, "nope", valid()), false},
+		{"kid of another algorithm", mint(t, RS256, "es", valid()), false},
+		{"none", segment(t, map[string]string{"alg": "none"}) + "." + segment(t, valid()) + ".", false},
+		{"tampered", tamper(mint(t, RS256, "rs", valid()), segment(t, with("sub", "mallory"))), false},
+		{"malformed", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		claims, err := v.Verify(tt.token)
+		if tt.ok && (err != nil || claims.Subject() != "alice" || claims.String("tier") != "pro") {
+			t.Errorf("%s: got %v, %v; want alice on pro", tt.name, claims, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	// An HS256 token signed with the PEM of the RSA public key must not
+	// verify against that key
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keys := &KeySet{}
+	if err := keys.AddPEM("rs", pemKey); err != nil {
+		t.Fatalf("AddPEM: %v", err)
+	}
+	v := NewVerifier(keys, Config{})
+
+	input := segment(t, map[string]string{"alg": HS256, "kid": "rs"}) + "." +
+		segment(t, map[string]any{"sub": "mallory", "exp": time.Now().Add(time.Hour).Unix()})
+	mac := hmac.New(sha256.New, pemKey)
+	mac.Write([]byte(input))
+	if _, err := v.Verify(input + "." + b64(mac.Sum(nil))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v want ErrInvalidToken", err)
+	}
+}
+
+func TestKeySetRejectsWeakKeys(t *testing.T) {
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	keys := &KeySet{}
+	if err := keys.AddHMAC("", []byte("short")); err == nil {
+		t.Errorf("short HMAC secret was accepted")
+	}
+	if err := keys.AddPublicKey("", &weak.PublicKey); err == nil {
+		t.Errorf("1024 bit RSA key was accepted")
+	}
+	if err := keys.AddPublicKey("", &p384.PublicKey); err == nil {
+		t.Errorf("P-384 key was accepted")
+	}
+}
+
+func TestClaimKey(t *testing.T) {
+	key := ClaimKey("sub")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if got := key(req); got != "192.0.2.1" {
+		t.Errorf("without claims: got %q want the client IP", got)
+	}
+	req = req.WithContext(NewContext(req.Context(), Claims{"sub": "alice"}))
+	if got := key(req); got != "jwt:alice" {
+		t.Errorf("got %q want jwt:alice", got)
+	}
+}
@@ -0,0 +1,182 @@
+package jwtauth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// minRSABits is the smallest RSA key accepted.
+const minRSABits = 2048
+
+// KeySet holds the keys tokens may be signed with.
+type KeySet struct {
+	keys []key
+}
+
+// key is a verification key, usable with one algorithm.
+type key struct {
+	kid string
+	alg string
+	// key is a []byte for HS256, an *rsa.PublicKey for RS256 and an
+	// *ecdsa.PublicKey on P-256 for ES256.
+	key any
+}
+
+// AddHMAC adds an HS256 secret, identified by kid when tokens carry one.
+func (ks *KeySet) AddHMAC(kid string, secret []byte) error {
+	if len(secret) < 32 {
+		return errors.New("HS256 secrets must be at least 32 bytes")
+	}
+	ks.keys = append(ks.keys, key{kid: kid, alg: HS256, key: secret})
+	return nil
+}
+
+// AddPublicKey adds an RSA key for RS256 or a P-256 key for ES256,
+// identified by kid when tokens carry one.
+func (ks *KeySet) AddPublicKey(kid string, pub any) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA keys must be at least %d bits", minRSABits)
+		}
+		ks.keys = append(ks.keys, key{kid: kid, alg: RS256, key: pub})
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return errors.New("ECDSA keys must be on P-256")
+		}
+		ks.keys = append(ks.keys, key{kid: kid, alg: ES256, key: pub})
+	default:
+		return fmt.Errorf("unsupported public key %T", pub)
+	}
+	return nil
+}
+
+// AddPEM adds the PEM encoded public key in data, see AddPublicKey.
+func (ks *KeySet) AddPEM(kid string, data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM block found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("error parsing public key: %v", err)
+	}
+	return ks.AddPublicKey(kid, pub)
+}
+
+// Len returns the number of keys in the set.
+func (ks *KeySet) Len() int {
+	return len(ks.keys)
+}
+
+// verify checks sig over input with the key named by the token header, or
+// with every key of the header's algorithm when it names none.
+func (ks *KeySet) verify(h header, input, sig []byte) error {
+	switch h.Alg {
+	case HS256, RS256, ES256:
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+
+	found := false
+	for _, k := range ks.keys {
+		if (h.Kid != "" && k.kid != h.Kid) || k.alg != h.Alg {
+			continue
+		}
+		found = true
+		if verifySignature(h.Alg, k.key, input, sig) {
+			return nil
+		}
+	}
+	if !found && h.Kid != "" {
+		return fmt.Errorf("%w: unknown %s key %q", ErrInvalidToken, h.Alg, h.Kid)
+	}
+	if !found {
+		return fmt.Errorf("%w: no %s key", ErrInvalidToken, h.Alg)
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// jwk is a JSON Web Key, as far as the supported key types go.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the keys of a JSON Web Key Set. Keys not meant for
+// signatures and key types other than oct, RSA and EC on P-256 are skipped.
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %v", err)
+	}
+
+	ks := &KeySet{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if err := ks.addJWK(k); err != nil {
+			return nil, fmt.Errorf("error parsing key %d (%q) of JWKS: %v", i, k.Kid, err)
+		}
+	}
+	return ks, nil
+}
+
+// addJWK adds k unless its type is not supported.
+func (ks *KeySet) addJWK(k jwk) error {
+	switch {
+	case k.Kty == "oct" && (k.Alg == "" || k.Alg == HS256):
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return fmt.Errorf("invalid k: %v", err)
+		}
+		return ks.AddHMAC(k.Kid, secret)
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == RS256):
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("invalid n: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return errors.New("invalid e")
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		return ks.AddPublicKey(k.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp})
+	case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == ES256):
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return errors.New("invalid coordinates")
+		}
+		// Rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return fmt.Errorf("invalid point: %v", err)
+		}
+		return ks.AddPublicKey(k.Kid, &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		})
+	}
+	return nil
+}
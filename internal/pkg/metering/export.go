@@ -0,0 +1,103 @@
+package metering
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Format is the file format of an export.
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat returns the format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSONL:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want csv or jsonl", s)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// Settled returns the end of the last hour whose rollups are final at now.
+func Settled(now time.Time) time.Time {
+	return now.Add(-Settle).UTC().Truncate(time.Hour)
+}
+
+// Export writes the rollups of the hours in [from, to) to w in format.
+// Hours not settled at now are left out, so exporting the same range again
+// always gives the same file. It returns the range actually exported.
+func Export(ctx context.Context, store Store, from, to, now time.Time, format Format, w io.Writer) (time.Time, time.Time, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC().Truncate(time.Hour)
+	if settled := Settled(now); to.After(settled) {
+		to = settled
+	}
+	if !from.Before(to) {
+		return from, from, writeHeader(w, format)
+	}
+
+	if err := writeHeader(w, format); err != nil {
+		return from, to, err
+	}
+
+	var write func(Row) error
+	flush := func() error { return nil }
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(r Row) error { return enc.Encode(r) }
+	default:
+		cw := csv.NewWriter(w)
+		write = func(r Row) error {
+			return cw.Write([]string{r.Customer, r.Route, r.Hour.UTC().Format(time.RFC3339), strconv.FormatInt(r.Requests, 10)})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+
+	if err := store.Rows(ctx, from, to, write); err != nil {
+		return from, to, fmt.Errorf("error exporting usage: %v", err)
+	}
+	return from, to, flush()
+}
+
+func writeHeader(w io.Writer, format Format) error {
+	if format != FormatCSV {
+		return nil
+	}
+	_, err := io.WriteString(w, "customer,route,hour,requests\n")
+	return err
+}
+
+// ParseTime parses the bound of an export range, either a date such as
+// 2025-01-31, taken in UTC, or an RFC 3339 time.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want a date such as 2025-01-31 or an RFC 3339 time", s)
+	}
+	return t, nil
+}
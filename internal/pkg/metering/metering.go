@@ -0,0 +1,224 @@
+// Package metering rolls up the requests admitted for every customer per
+// route and hour, for billing. Counts are aggregated in memory and applied
+// to a Store in batches, which the Store applies at most once so retried
+// flushes never count a request twice.
+package metering
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Row is the number of requests of a customer on a route during the hour
+// starting at Hour.
+type Row struct {
+	Customer string    `json:"customer"`
+	Route    string    `json:"route"`
+	Hour     time.Time `json:"hour"`
+	Requests int64     `json:"requests"`
+}
+
+// Store keeps the hourly rollups.
+type Store interface {
+	// Apply adds the requests of rows to the stored rollups, unless the
+	// batch with the same ID was applied already.
+	Apply(ctx context.Context, batch string, rows []Row) error
+	// Rows calls fn with the rollups of the hours in [from, to), ordered by
+	// hour, customer and route.
+	Rows(ctx context.Context, from, to time.Time, fn func(Row) error) error
+}
+
+// Config configures a Meter. Zero values get the defaults noted below.
+type Config struct {
+	// Interval is how often counts are flushed to the store (default 10s).
+	// It must stay well below Settle for exports to be complete.
+	Interval time.Duration
+	// Clock is the time source (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// Settle is how long after an hour ended its rollups are final and exported.
+// Requests of settled hours that could not be flushed in time are counted in
+// the hour they are flushed in instead.
+const Settle = 5 * time.Minute
+
+var (
+	meterFlushes = metrics.NewCounterVec(
+		"metering_flushes_total",
+		"Flushes of the usage rollups by outcome (ok or error).",
+		"outcome",
+	)
+	meterLate = metrics.NewCounterVec(
+		"metering_late_requests_total",
+		"Requests flushed after their hour settled, counted in the hour of the flush.",
+		"route",
+	)
+)
+
+type rollupKey struct {
+	customer, route string
+	hour            time.Time
+}
+
+// Meter counts admitted requests until they are flushed.
+type Meter struct {
+	store Store
+	cfg   Config
+
+	// flushMu serializes flushes, so a retried batch is never in flight twice
+	flushMu sync.Mutex
+
+	mu     sync.Mutex
+	counts map[rollupKey]int64
+	// pending is the batch that failed to apply, retried with the same ID
+	pending   []Row
+	pendingID string
+}
+
+// New returns a Meter flushing to store.
+func New(store Store, cfg Config) *Meter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+	return &Meter{store: store, cfg: cfg, counts: make(map[rollupKey]int64)}
+}
+
+// Record counts one request of customer on route, now.
+func (m *Meter) Record(customer, route string) {
+	key := rollupKey{customer, route, m.cfg.Clock.Now().UTC().Truncate(time.Hour)}
+
+	m.mu.Lock()
+	m.counts[key]++
+	m.mu.Unlock()
+}
+
+// Flush applies the counts recorded since the last successful flush. A
+// batch that fails is retried by the next flush, ahead of newer counts.
+// Counts of hours settled meanwhile, which exports may already have
+// returned, are moved to the current hour.
+func (m *Meter) Flush(ctx context.Context) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	now := m.cfg.Clock.Now().UTC()
+	m.mu.Lock()
+	if m.pending == nil && len(m.counts) > 0 {
+		for key, n := range m.counts {
+			m.pending = append(m.pending, Row{Customer: key.customer, Route: key.route, Hour: key.hour, Requests: n})
+		}
+		m.pendingID = newBatchID()
+		clear(m.counts)
+	}
+	settled := Settled(now)
+	for i, r := range m.pending {
+		if r.Hour.Before(settled) {
+			meterLate.Add(float64(r.Requests), r.Route)
+			m.pending[i].Hour = now.Truncate(time.Hour)
+		}
+	}
+	batch, rows := m.pendingID, m.pending
+	m.mu.Unlock()
+
+	if rows == nil {
+		return nil
+	}
+	if err := m.store.Apply(ctx, batch, rows); err != nil {
+		meterFlushes.Inc("error")
+		return fmt.Errorf("error flushing %d usage rollups: %v", len(rows), err)
+	}
+	meterFlushes.Inc("ok")
+
+	m.mu.Lock()
+	m.pending, m.pendingID = nil, ""
+	m.mu.Unlock()
+	return nil
+}
+
+// Run flushes every interval until ctx is done, reporting failed flushes to
+// onError when set.
+func (m *Meter) Run(ctx context.Context, onError func(error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.cfg.Clock.After(m.cfg.Interval):
+			if err := m.Flush(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// newBatchID returns a random batch ID.
+func newBatchID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MemoryStore is a Store keeping the rollups in this process, for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	rollups map[rollupKey]int64
+	batches map[string]bool
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rollups: make(map[rollupKey]int64), batches: make(map[string]bool)}
+}
+
+// Apply implements Store.
+func (s *MemoryStore) Apply(_ context.Context, batch string, rows []Row) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.batches[batch] {
+		return nil
+	}
+	s.batches[batch] = true
+	for _, r := range rows {
+		s.rollups[rollupKey{r.Customer, r.Route, r.Hour.UTC()}] += r.Requests
+	}
+	return nil
+}
+
+// Rows implements Store.
+func (s *MemoryStore) Rows(_ context.Context, from, to time.Time, fn func(Row) error) error {
+	s.mu.Lock()
+	var rows []Row
+	for key, n := range s.rollups {
+		if !key.hour.Before(from) && key.hour.Before(to) {
+			rows = append(rows, Row{Customer: key.customer, Route: key.route, Hour: key.hour, Requests: n})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.Customer != b.Customer {
+			return a.Customer < b.Customer
+		}
+		return a.Route < b.Route
+	})
+	for _, r := range rows {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package metering
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// lossyStore applies batches but reports a failure while lose is set, like
+// a commit whose answer was lost.
+type lossyStore struct {
+	*MemoryStore
+	lose    bool
+	batches []string
+}
+
+func (s *lossyStore) Apply(ctx context.Context, batch string, rows []Row) error {
+	s.batches = append(s.batches, batch)
+	s.MemoryStore.Apply(ctx, batch, rows)
+	if s.lose {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func TestMeterRetriesBatchOnce(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 3, 1, 10, 59, 0, 0, time.UTC))
+	store := &lossyStore{MemoryStore: NewMemoryStore(), lose: true}
+	meter := New(store, Config{Clock: clock})
+
+	meter.Record("acme", "/fixed")
+	clock.Advance(2 * time.Minute)
+	meter.Record("acme", "/fixed")
+	meter.Record("acme", "/sliding")
+	if err := meter.Flush(context.Background()); err == nil {
+		t.Fatalf("Flush succeeded with a lost answer")
+	}
+
+	// Requests recorded meanwhile wait for the failed batch
+	store.lose = false
+	meter.Record("acme", "/fixed")
+	if err := meter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err := meter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if len(store.batches) != 3 || store.batches[0] != store.batches[1] || store.batches[1] == store.batches[2] {
+		t.Errorf("got batches %v, want the failed one retried then a new one", store.batches)
+	}
+
+	var buf bytes.Buffer
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	Export(context.Background(), store, from, from.AddDate(0, 0, 1), from.AddDate(0, 0, 2), FormatCSV, &buf)
+	want := "customer,route,hour,requests\n" +
+		"acme,/fixed,2025-03-01T10:00:00Z,1\n" +
+		"acme,/fixed,2025-03-01T11:00:00Z,2\n" +
+		"acme,/sliding,2025-03-01T11:00:00Z,1\n"
+	if buf.String() != want {
+		t.Errorf("got export\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestExportLeavesOutUnsettledHours(t *testing.T) {
+	store := NewMemoryStore()
+	hour := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	store.Apply(context.Background(), "b", []Row{
+		{Customer: "acme", Route: "/fixed", Hour: hour, Requests: 3},
+		{Customer: "acme", Route: "/fixed", Hour: hour.Add(time.Hour), Requests: 4},
+	})
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{"hour still open", hour.Add(30 * time.Minute), ""},
+		{"hour not settled", hour.Add(time.Hour + time.Minute), ""},
+		{"hour settled", hour.Add(time.Hour + Settle), `{"customer":"acme","route":"/fixed","hour":"2025-03-01T10:00:00Z","requests":3}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			_, to, err := Export(context.Background(), store, hour, hour.AddDate(0, 0, 1), tt.now, FormatJSONL, &buf)
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q want %q", buf.String(), tt.want)
+			}
+			if to.After(tt.now) {
+				t.Errorf("exported up to %v, after now", to)
+			}
+		})
+	}
+}
+
+// downStore fails every batch while down is set.
+type downStore struct {
+	*MemoryStore
+	down bool
+}
+
+func (s *downStore) Apply(ctx context.Context, batch string, rows []Row) error {
+	if s.down {
+		return errors.New("connection refused")
+	}
+	return s.MemoryStore.Apply(ctx, batch, rows)
+}
+
+func TestMeterMovesLateCountsOutOfSettledHours(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 3, 1, 10, 59, 0, 0, time.UTC))
+	store := &downStore{MemoryStore: NewMemoryStore(), down: true}
+	meter := New(store, Config{Clock: clock})
+
+	meter.Record("acme", t.Name())
+	if err := meter.Flush(context.Background()); err == nil {
+		t.Fatalf("Flush succeeded with the store down")
+	}
+
+	// The store comes back after the hour was settled and exported
+	export := func(from time.Time) string {
+		var buf bytes.Buffer
+		Export(context.Background(), store, from, from.Add(time.Hour), clock.Now(), FormatCSV, &buf)
+		return buf.String()
+	}
+	clock.Advance(Settle + 2*time.Minute)
+	hour := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	exported := export(hour)
+
+	late := meterLate.Value(t.Name())
+	store.down = false
+	if err := meter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := export(hour); got != exported {
+		t.Errorf("export of a settled hour changed from\n%s\nto\n%s", exported, got)
+	}
+	if got := meterLate.Value(t.Name()) - late; got != 1 {
+		t.Errorf("got %v late requests want 1", got)
+	}
+
+	// The late request is billed in the hour it was flushed in
+	clock.Advance(time.Hour)
+	want := "customer,route,hour,requests\n" +
+		"acme," + t.Name() + ",2025-03-01T11:00:00Z,1\n"
+	if got := export(hour.Add(time.Hour)); got != want {
+		t.Errorf("got export\n%s\nwant\n%s", got, want)
+	}
+}
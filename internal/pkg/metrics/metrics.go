@@ -0,0 +1,226 @@
+// Package metrics provides labelled counters and gauges exposed in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]metric)
+)
+
+// register adds m to the default registry, returning the existing metric if
+// one was already registered under name.
+func register(name string, m metric) metric {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if existing, ok := registry[name]; ok {
+		return existing
+	}
+	registry[name] = m
+	return m
+}
+
+// vec holds the values of a metric for each combination of label values.
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.keys[key]; !ok {
+		v.keys[key] = append([]string(nil), labelValues...)
+	}
+	v.values[key] += delta
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.keys[key]; !ok {
+		v.keys[key] = append([]string(nil), labelValues...)
+	}
+	v.values[key] = value
+}
+
+func (v *vec) get(labelValues []string) float64 {
+	key := v.key(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[key]
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, v.keys[key]), formatValue(v.values[key]))
+	}
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct {
+	v *vec
+}
+
+// NewCounterVec registers a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return register(name, &CounterVec{v: newVec(name, help, "counter", labels)}).(*CounterVec)
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+// Add increases the counter for the given label values by delta.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.v.add(delta, labelValues)
+}
+
+// Value returns the current value for the given label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.v.get(labelValues)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.v.write(w)
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct {
+	v *vec
+}
+
+// NewGaugeVec registers a gauge with the given label names.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return register(name, &GaugeVec{v: newVec(name, help, "gauge", labels)}).(*GaugeVec)
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.set(value, labelValues)
+}
+
+// Add adds delta, which may be negative, to the gauge for the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.add(delta, labelValues)
+}
+
+// Value returns the current value for the given label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.v.get(labelValues)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.v.write(w)
+}
+
+// WriteTo writes every registered metric to w, sorted by name.
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registered metrics for Prometheus to scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Requests handled.", "route", "code")
+	inFlight := NewGaugeVec("test_in_flight", "Requests in flight.")
+
+	requests.Inc("/a", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc("/b", "500")
+	inFlight.Set(3)
+	inFlight.Add(-1)
+
+	if got := requests.Value("/a", "200"); got != 3 {
+		t.Errorf("expected counter value 3, got %v", got)
+	}
+
+	// Registering the same name again returns the existing metric
+	if again := NewCounterVec("test_requests_total", "Requests handled.", "route", "code"); again != requests {
+		t.Errorf("expected re-registration to return the existing counter")
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="/a",code="200"} 3`,
+		`test_requests_total{route="/b",code="500"} 1`,
+		"# TYPE test_in_flight gauge",
+		"test_in_flight 2",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
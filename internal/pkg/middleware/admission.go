@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+)
+
+// Priority classifies requests for load shedding. Lower priorities are shed first.
+type Priority int
+
+const (
+	PriorityLow      Priority = iota // anonymous traffic
+	PriorityNormal                   // free tier customers
+	PriorityHigh                     // paid customers
+	PriorityCritical                 // health checks, never shed
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityHigh:     "high",
+	PriorityCritical: "critical",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "priority(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParsePriority parses a priority class name such as "high".
+func ParsePriority(name string) (Priority, error) {
+	for p, n := range priorityNames {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return p, nil
+		}
+	}
+	return PriorityLow, fmt.Errorf("unknown priority class %q", name)
+}
+
+// inFlightShare is the fraction of MaxInFlight each class may fill before it
+// is shed, so lower classes are dropped well before the instance is saturated.
+var inFlightShare = map[Priority]float64{
+	PriorityLow:    0.5,
+	PriorityNormal: 0.7,
+	PriorityHigh:   0.9,
+}
+
+// latencyShedRatio is how far the average latency may exceed
+// LatencyThreshold before each class is shed.
+var latencyShedRatio = map[Priority]float64{
+	PriorityLow:    1,
+	PriorityNormal: 1.5,
+	PriorityHigh:   2,
+}
+
+// AdmissionConfig configures the global admission controller.
+type AdmissionConfig struct {
+	// MaxInFlight is the number of concurrent requests the instance can handle.
+	MaxInFlight int
+	// LatencyThreshold is the average handler latency above which shedding starts.
+	LatencyThreshold time.Duration
+	// RetryAfter is advertised to shed clients.
+	RetryAfter time.Duration
+	// CustomerPriorities assigns classes to the customers identified by
+	// Authenticate or JWT.
+	CustomerPriorities map[string]Priority
+	// PlanPriorities assigns classes to the plans of customers missing from
+	// CustomerPriorities, as resolved by Plans.
+	PlanPriorities map[string]Priority
+	// Plans resolves the plans of customers for PlanPriorities.
+	Plans *plans.Resolver
+	// RoutePriorities assigns classes to route templates and takes precedence over customers.
+	RoutePriorities map[string]Priority
+	// CustomerPriority applies to customers without a class of their own or of their plan.
+	CustomerPriority Priority
+	// AnonymousPriority applies to callers not identified.
+	AnonymousPriority Priority
+}
+
+// AdmissionStatus reports the current load seen by the admission controller.
+type AdmissionStatus struct {
+	InFlight         int               `json:"in_flight"`
+	MaxInFlight      int               `json:"max_in_flight"`
+	AverageLatency   string            `json:"average_latency"`
+	LatencyThreshold string            `json:"latency_threshold"`
+	Shedding         []string          `json:"shedding"`
+	Shed             map[string]uint64 `json:"shed"`
+}
+
+// AdmissionController sheds low priority traffic when the whole instance is
+// overloaded, independently of the per-client rate limits.
+type AdmissionController struct {
+	cfg AdmissionConfig
+
+	mu          sync.Mutex
+	inFlight    int
+	latency     float64 // exponentially weighted moving average in seconds
+	lastLatency time.Time
+	shed        map[Priority]uint64
+}
+
+// latencyWeight is the weight of each new sample in the latency average.
+const latencyWeight = 0.1
+
+// latencyHalfLife decays the average while no samples arrive, so the
+// controller recovers even when shedding stops all measured traffic.
+const latencyHalfLife = time.Second
+
+// NewAdmissionController creates an admission controller from cfg.
+func NewAdmissionController(cfg AdmissionConfig) *AdmissionController {
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+	return &AdmissionController{
+		cfg:  cfg,
+		shed: make(map[Priority]uint64),
+	}
+}
+
+// Classify returns the priority class of a request from its route and the
+// customer identified by Authenticate or JWT. Plans that cannot be resolved
+// leave the customer in CustomerPriority.
+func (a *AdmissionController) Classify(ctx *gin.Context) Priority {
+	if p, ok := a.cfg.RoutePriorities[routeName(ctx)]; ok {
+		return p
+	}
+
+	customer := ctx.GetString(CustomerKey)
+	if customer == "" {
+		return a.cfg.AnonymousPriority
+	}
+	if p, ok := a.cfg.CustomerPriorities[customer]; ok {
+		return p
+	}
+	if a.cfg.Plans != nil && len(a.cfg.PlanPriorities) > 0 {
+		if sub, err := resolvePlan(ctx, a.cfg.Plans, customer); err == nil {
+			if p, ok := a.cfg.PlanPriorities[sub.Plan.Name]; ok {
+				return p
+			}
+		}
+	}
+	return a.cfg.CustomerPriority
+}
+
+// averageLatency returns the decayed latency average. The caller must hold a.mu.
+func (a *AdmissionController) averageLatency(now time.Time) float64 {
+	if a.lastLatency.IsZero() {
+		return 0
+	}
+	idle := now.Sub(a.lastLatency)
+	return a.latency * math.Pow(0.5, float64(idle)/float64(latencyHalfLife))
+}
+
+// shouldShed reports whether a request of class p must be shed. The caller must hold a.mu.
+func (a *AdmissionController) shouldShed(p Priority, now time.Time) bool {
+	if p >= PriorityCritical {
+		return false
+	}
+	if a.cfg.MaxInFlight > 0 && float64(a.inFlight) >= inFlightShare[p]*float64(a.cfg.MaxInFlight) {
+		return true
+	}
+	if a.cfg.LatencyThreshold > 0 {
+		ratio := a.averageLatency(now) / a.cfg.LatencyThreshold.Seconds()
+		if ratio > latencyShedRatio[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// admit registers a request of class p as in flight unless it must be shed.
+func (a *AdmissionController) admit(p Priority) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.shouldShed(p, time.Now()) {
+		a.shed[p]++
+		return false
+	}
+	a.inFlight++
+	return true
+}
+
+// done records the completion of an admitted request.
+func (a *AdmissionController) done(latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.inFlight--
+	a.latency = a.averageLatency(now)*(1-latencyWeight) + latency.Seconds()*latencyWeight
+	a.lastLatency = now
+}
+
+// Status returns the controller's current load and shedding state.
+func (a *AdmissionController) Status() AdmissionStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	status := AdmissionStatus{
+		InFlight:         a.inFlight,
+		MaxInFlight:      a.cfg.MaxInFlight,
+		AverageLatency:   time.Duration(a.averageLatency(now) * float64(time.Second)).String(),
+		LatencyThreshold: a.cfg.LatencyThreshold.String(),
+		Shedding:         []string{},
+		Shed:             make(map[string]uint64),
+	}
+	for p := PriorityLow; p < PriorityCritical; p++ {
+		if a.shouldShed(p, now) {
+			status.Shedding = append(status.Shedding, p.String())
+		}
+	}
+	for p, n := range a.shed {
+		status.Shed[p.String()] = n
+	}
+	return status
+}
+
+// Middleware returns the admission control middleware. Shed requests get
+// 503 Service Unavailable, unlike the 429 returned for per-client limits.
+// It must run after Authenticate or JWT, which identify the customer.
+func (a *AdmissionController) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !a.admit(a.Classify(ctx)) {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(a.cfg.RetryAfter.Seconds()))))
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Service overloaded, please try again later.",
+				"message": "The server is shedding load. Please retry after the indicated delay.",
+			})
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			a.done(time.Since(start))
+		}()
+		ctx.Next()
+	}
+}
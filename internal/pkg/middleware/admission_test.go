@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+)
+
+func TestAdmissionClassify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	resolver := plans.NewResolver(planSource{
+		"classify-pro":  {Customer: "classify-pro", Plan: plans.Plan{Name: t.Name() + " pro"}},
+		"classify-free": {Customer: "classify-free", Plan: plans.Plan{Name: t.Name() + " free"}},
+	}, plans.Config{})
+	auth, secrets := issue("classify-vip", "classify-pro", "classify-free", "classify-unknown")
+	admission := NewAdmissionController(AdmissionConfig{
+		CustomerPriorities: map[string]Priority{"classify-vip": PriorityCritical},
+		PlanPriorities:     map[string]Priority{t.Name() + " pro": PriorityHigh},
+		Plans:              resolver,
+		RoutePriorities:    map[string]Priority{"/health": PriorityCritical},
+		CustomerPriority:   PriorityNormal,
+		AnonymousPriority:  PriorityLow,
+	})
+
+	var got Priority
+	r := gin.New()
+	r.Use(Authenticate(auth))
+	classify := func(c *gin.Context) {
+		got = admission.Classify(c)
+		c.Status(http.StatusOK)
+	}
+	r.GET("/api", classify)
+	r.GET("/health", classify)
+
+	tests := []struct {
+		name   string
+		path   string
+		apiKey string
+		want   Priority
+	}{
+		{"anonymous", "/api", "", PriorityLow},
+		{"customer priority", "/api", secrets[0], PriorityCritical},
+		{"plan priority", "/api", secrets[1], PriorityHigh},
+		{"plan without a priority", "/api", secrets[2], PriorityNormal},
+		{"customer without a plan", "/api", secrets[3], PriorityNormal},
+		{"route priority", "/health", "", PriorityCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d want %d", w.Code, http.StatusOK)
+			}
+			if got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/apikey"
+)
+
+// Context keys set by Authenticate for the handlers behind it. JWT sets
+// CustomerKey too.
+const (
+	CustomerKey = "customer"
+	APIKeyKey   = "api_key"
+)
+
+// Authenticate identifies the caller from the API key in the X-API-Key
+// header, setting CustomerKey and APIKeyKey. Unknown, revoked and expired
+// keys are rejected, and callers without a key are anonymous. It must run
+// before the limiters, which key customers on their ID.
+func Authenticate(auth *apikey.Authenticator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		secret := ctx.GetHeader("X-API-Key")
+		if secret == "" {
+			ctx.Next()
+			return
+		}
+
+		key, err := auth.Authenticate(ctx.Request.Context(), secret)
+		switch {
+		case errors.Is(err, apikey.ErrUnknownKey), errors.Is(err, apikey.ErrRevoked), errors.Is(err, apikey.ErrExpired):
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			// A key that cannot be checked is not trusted
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "authentication is unavailable, try again later"})
+			return
+		}
+
+		ctx.Set(CustomerKey, key.Customer)
+		ctx.Set(APIKeyKey, key)
+		ctx.Next()
+	}
+}
+
+// RequireCustomer rejects anonymous callers. It must follow the middleware
+// identifying customers.
+func RequireCustomer() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString(CustomerKey) == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key or token required"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireScope rejects callers whose API key does not grant scope. Callers
+// without an API key pass through; RequireCustomer decides whether
+// anonymous callers are allowed.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		v, ok := ctx.Get(APIKeyKey)
+		if ok && !v.(apikey.Key).HasScope(scope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+			return
+		}
+		ctx.Next()
+	}
+}
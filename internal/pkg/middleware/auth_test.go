@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/apikey"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// issue returns an Authenticator knowing one key per customer given, granting
+// every scope, and the secrets of those keys in the same order.
+func issue(customers ...string) (*apikey.Authenticator, []string) {
+	store := apikey.NewMemoryStore()
+	var secrets []string
+	for _, customer := range customers {
+		key, secret := apikey.Generate(customer, []string{apikey.AllScopes}, time.Time{})
+		store.Save(key)
+		secrets = append(secrets, secret)
+	}
+	return apikey.NewAuthenticator(store, apikey.Config{}), secrets
+}
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, required := range []bool{false, true} {
+		clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+		store := apikey.NewMemoryStore()
+		reader, readerSecret := apikey.Generate("acme", []string{"usage:read"}, time.Time{})
+		expiring, expiringSecret := apikey.Generate("acme", []string{apikey.AllScopes}, clock.Now().Add(time.Hour))
+		revoked, revokedSecret := apikey.Generate("acme", []string{apikey.AllScopes}, time.Time{})
+		revoked.RevokedAt = clock.Now()
+		store.Save(reader)
+		store.Save(expiring)
+		store.Save(revoked)
+		auth := apikey.NewAuthenticator(store, apikey.Config{Clock: clock})
+
+		r := gin.New()
+		r.Use(Authenticate(auth))
+		if required {
+			r.Use(RequireCustomer())
+		}
+		r.GET("/usage", RequireScope("usage:read"), func(c *gin.Context) { c.String(http.StatusOK, c.GetString(CustomerKey)) })
+		r.GET("/download", RequireScope("instagram:download"), func(c *gin.Context) { c.String(http.StatusOK, c.GetString(CustomerKey)) })
+
+		anonymous := http.StatusOK
+		if required {
+			anonymous = http.StatusUnauthorized
+		}
+		tests := []struct {
+			path, secret string
+			advance      time.Duration
+			status       int
+		}{
+			{"/usage", readerSecret, 0, http.StatusOK},
+			{"/download", readerSecret, 0, http.StatusForbidden},
+			{"/download", expiringSecret, 0, http.StatusOK},
+			{"/download", revokedSecret, 0, http.StatusUnauthorized},
+			{"/download", readerSecret[:len(readerSecret)-1] + "x", 0, http.StatusUnauthorized},
+			{"/download", "", 0, anonymous},
+			// Expiry applies to cached keys too
+			{"/download", expiringSecret, time.Hour, http.StatusUnauthorized},
+		}
+		for i, tt := range tests {
+			clock.Advance(tt.advance)
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.secret != "" {
+				req.Header.Set("X-API-Key", tt.secret)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("required %v, request %d: got %d want %d", required, i, w.Code, tt.status)
+			}
+		}
+	}
+}
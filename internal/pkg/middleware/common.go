@@ -1,10 +1,64 @@
+// Package middleware adapts the ratelimit package to the gin framework.
 package middleware
 
-import "sync"
+import (
+	"time"
 
-var (
-	mu             sync.Mutex
-	fixedWindows   = make(map[string]*FixedWindow)
-	slidingWindows = make(map[string]*SlidingWindow)
-	tokenBuckets   = make(map[string]*TokenBucket)
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
 )
+
+// GetClientIP returns the IP address of the client as ratelimit.ClientIP
+// resolves it, believing only the proxies set by ratelimit.SetTrustedProxies.
+func GetClientIP(ctx *gin.Context) string {
+	return ratelimit.ClientIP(ctx.Request)
+}
+
+// routeName returns the route template for the request, falling back to
+// the raw path for requests that did not match a registered route.
+func routeName(ctx *gin.Context) string {
+	if route := ctx.FullPath(); route != "" {
+		return route
+	}
+	return ctx.Request.URL.Path
+}
+
+// Limit enforces a limiter as gin middleware. Clients are identified by the
+// policy's KeyFunc, or by the client IP as resolved by gin.
+func Limit(l *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		enforce(ctx, l, l.Key(ctx.Request, func() string { return GetClientIP(ctx) }))
+	}
+}
+
+// enforce limits the request of the client identified by key with l.
+func enforce(ctx *gin.Context, l *ratelimit.Limiter, key string) {
+	route := routeName(ctx)
+	d := l.Allow(ctx.Request.Context(), route, key)
+	if !d.Allowed {
+		l.Reject(ctx.Writer, ctx.Request, d)
+		ctx.Abort()
+		return
+	}
+
+	start := time.Now()
+	ctx.Next()
+	d.Done(time.Since(start), ctx.Writer.Status())
+	l.Charge(ctx.Request.Context(), route, key, ctx.Writer.Status())
+}
+
+// FixedWindowMiddleware implements a fixed window rate limiting algorithm.
+func FixedWindowMiddleware(limit int, window time.Duration, opts ...ratelimit.Option) gin.HandlerFunc {
+	return Limit(ratelimit.NewFixedWindow(limit, window, opts...))
+}
+
+// SlidingWindowMiddleware implements a sliding window rate limiting algorithm.
+func SlidingWindowMiddleware(limit int, window time.Duration, opts ...ratelimit.Option) gin.HandlerFunc {
+	return Limit(ratelimit.NewSlidingWindow(limit, window, opts...))
+}
+
+// TokenBucketMiddleware implements a token bucket rate limiting algorithm.
+func TokenBucketMiddleware(rateLimit, burst int, opts ...ratelimit.Option) gin.HandlerFunc {
+	return Limit(ratelimit.NewTokenBucket(rateLimit, burst, opts...))
+}
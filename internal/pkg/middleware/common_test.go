@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+func TestMiddlewareBoundaries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type request struct {
+		advance    time.Duration
+		status     int
+		retryAfter string
+	}
+
+	tests := []struct {
+		name       string
+		middleware func(clock ratelimit.Clock) gin.HandlerFunc
+		requests   []request
+	}{
+		{
+			name: "fixed window",
+			middleware: func(clock ratelimit.Clock) gin.HandlerFunc {
+				return FixedWindowMiddleware(2, 30*time.Second, ratelimit.WithName("mw-fixed"), ratelimit.WithClock(clock))
+			},
+			requests: []request{
+				{status: http.StatusOK},
+				{advance: 10 * time.Second, status: http.StatusOK},
+				{advance: 10 * time.Second, status: http.StatusTooManyRequests, retryAfter: "10"},
+				{advance: 10 * time.Second, status: http.StatusOK},
+			},
+		},
+		{
+			name: "sliding window",
+			middleware: func(clock ratelimit.Clock) gin.HandlerFunc {
+				return SlidingWindowMiddleware(2, 30*time.Second, ratelimit.WithName("mw-sliding"), ratelimit.WithClock(clock))
+			},
+			requests: []request{
+				{status: http.StatusOK},
+				{advance: 10 * time.Second, status: http.StatusOK},
+				{advance: 10 * time.Second, status: http.StatusTooManyRequests, retryAfter: "10"},
+				{advance: 10 * time.Second, status: http.StatusOK},
+				{advance: time.Second, status: http.StatusTooManyRequests, retryAfter: "9"},
+			},
+		},
+		{
+			name: "token bucket",
+			middleware: func(clock ratelimit.Clock) gin.HandlerFunc {
+				return TokenBucketMiddleware(1, 2, ratelimit.WithName("mw-token-bucket"), ratelimit.WithClock(clock))
+			},
+			requests: []request{
+				{status: http.StatusOK},
+				{status: http.StatusOK},
+				{status: http.StatusTooManyRequests, retryAfter: "1"},
+				{advance: time.Second, status: http.StatusOK},
+			},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			ip := fmt.Sprintf("192.0.2.%d", n+1)
+			ratelimit.ResetKey(ip)
+
+			r := gin.New()
+			r.GET("/limited", tt.middleware(clock), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, req := range tt.requests {
+				clock.Advance(req.advance)
+
+				httpReq := httptest.NewRequest("GET", "/limited", nil)
+				httpReq.RemoteAddr = ip + ":1234"
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httpReq)
+
+				if w.Code != req.status || w.Header().Get("Retry-After") != req.retryAfter {
+					t.Errorf("request %d: got %d with Retry-After %q, want %d with %q",
+						i, w.Code, w.Header().Get("Retry-After"), req.status, req.retryAfter)
+				}
+			}
+		})
+	}
+}
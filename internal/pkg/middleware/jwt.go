@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/jwtauth"
+)
+
+// Context keys set by JWT for the handlers behind it.
+const (
+	ClaimsKey = "claims"
+	TierKey   = "tier"
+)
+
+// JWTConfig configures JWT.
+type JWTConfig struct {
+	// TierClaim is the claim naming the caller's plan, none when empty.
+	TierClaim string
+	// TierIssuers lists the iss claims of the tokens whose tier is trusted.
+	// Tiers of other tokens are ignored.
+	TierIssuers []string
+}
+
+// JWT identifies the caller from the bearer token in the Authorization
+// header. The claims of a valid token are set as ClaimsKey and in the
+// request context, for limiters keying on jwtauth.ClaimKey; its sub claim,
+// prefixed with jwtauth.KeyPrefix, becomes the CustomerKey and the tier
+// claim of trusted issuers the TierKey naming the caller's plan. Invalid
+// tokens are rejected, and callers without one are anonymous. Callers
+// already identified by an API key are left alone.
+func JWT(v *jwtauth.Verifier, cfg JWTConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || ctx.GetString(CustomerKey) != "" {
+			ctx.Next()
+			return
+		}
+
+		claims, err := v.Verify(token)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if claims.Subject() == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has no subject"})
+			return
+		}
+
+		ctx.Set(ClaimsKey, claims)
+		ctx.Set(CustomerKey, claims.Customer())
+		tier := claims.String(cfg.TierClaim)
+		if cfg.TierClaim != "" && tier != "" && slices.Contains(cfg.TierIssuers, claims.String("iss")) {
+			ctx.Set(TierKey, tier)
+		}
+		ctx.Request = ctx.Request.WithContext(jwtauth.NewContext(ctx.Request.Context(), claims))
+		ctx.Next()
+	}
+}
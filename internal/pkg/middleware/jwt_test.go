@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/jwtauth"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+var jwtSecret = []byte("middleware-test-secret-0123456789")
+
+// mintHS256 returns a token with claims signed with jwtSecret, expiring in
+// an hour.
+func mintHS256(claims map[string]any) string {
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := enc(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + enc(claims)
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newJWTVerifier(t *testing.T) *jwtauth.Verifier {
+	t.Helper()
+	keys := &jwtauth.KeySet{}
+	if err := keys.AddHMAC("", jwtSecret); err != nil {
+		t.Fatalf("AddHMAC: %v", err)
+	}
+	return jwtauth.NewVerifier(keys, jwtauth.Config{})
+}
+
+func TestJWTKeysOnSubject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(JWT(newJWTVerifier(t), JWTConfig{TierClaim: "tier", TierIssuers: []string{"https://auth.example.com"}}))
+	r.GET("/limited", Limit(ratelimit.NewFixedWindow(1, time.Minute,
+		ratelimit.WithName(t.Name()),
+		ratelimit.WithKeyFunc(jwtauth.ClaimKey("sub")),
+	)), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(CustomerKey)+" "+c.GetString(TierKey))
+	})
+
+	alice := mintHS256(map[string]any{"sub": "alice", "tier": "pro", "iss": "https://auth.example.com"})
+	bob := mintHS256(map[string]any{"sub": "bob"})
+	// The tier of an issuer not trusted with tiers is ignored
+	mallory := mintHS256(map[string]any{"sub": "mallory", "tier": "pro", "iss": "https://other.example.com"})
+	// Subjects never share the limit of the client IPs
+	ip := mintHS256(map[string]any{"sub": "192.0.2.48"})
+	tests := []struct {
+		token  string
+		status int
+		body   string
+	}{
+		{alice, http.StatusOK, "jwt:alice pro"},
+		{alice, http.StatusTooManyRequests, ""},
+		// Same IP, another subject
+		{bob, http.StatusOK, "jwt:bob "},
+		{mallory, http.StatusOK, "jwt:mallory "},
+		{ip, http.StatusOK, "jwt:192.0.2.48 "},
+		// Anonymous callers are keyed on their IP
+		{"", http.StatusOK, " "},
+		{"", http.StatusTooManyRequests, ""},
+		{alice + "x", http.StatusUnauthorized, ""},
+		{mintHS256(map[string]any{"tier": "pro"}), http.StatusUnauthorized, ""},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = "192.0.2.48:1234"
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("request %d: got %d %q want %d %q", i, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestJWTTierSelectsPlan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fixed := func(limit int) plans.Limit {
+		return plans.Limit{Algorithm: ratelimit.AlgorithmFixedWindow, Limit: limit, Window: time.Minute}
+	}
+	free := plans.Plan{Name: t.Name() + " free", Limits: map[string]plans.Limit{"/limited": fixed(1)}}
+	pro := plans.Plan{Name: t.Name() + " pro", Limits: map[string]plans.Limit{"/limited": fixed(2)}}
+	// Only the free plan is stored for carol, the token asserts pro
+	resolver := plans.NewResolver(planSource{
+		"jwt:carol":   {Customer: "jwt:carol", Plan: free},
+		"jwt:dave":    {Customer: "jwt:dave", Plan: free},
+		"jwt:pro-any": {Customer: "jwt:pro-any", Plan: pro},
+	}, plans.Config{})
+
+	r := gin.New()
+	r.Use(JWT(newJWTVerifier(t), JWTConfig{TierClaim: "tier", TierIssuers: []string{"https://auth.example.com"}}))
+	r.GET("/limited", PlanLimit(resolver, ratelimit.NewFixedWindow(100, time.Minute, ratelimit.WithName(t.Name()+" default"))),
+		func(c *gin.Context) { c.String(http.StatusOK, c.GetString(PlanKey)) })
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	token := mintHS256(map[string]any{"sub": "carol", "tier": pro.Name, "iss": "https://auth.example.com"})
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := get(token)
+		if w.Code != want {
+			t.Errorf("request %d: got %d want %d", i, w.Code, want)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != pro.Name {
+			t.Errorf("request %d: got plan %q want %q", i, w.Body.String(), pro.Name)
+		}
+	}
+
+	// Without a trusted issuer the stored plan applies
+	token = mintHS256(map[string]any{"sub": "dave", "tier": pro.Name})
+	if w := get(token); w.Code != http.StatusOK || w.Body.String() != free.Name {
+		t.Errorf("untrusted tier: got %d with plan %q, want 200 with %q", w.Code, w.Body.String(), free.Name)
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/metering"
+)
+
+// Meter records the requests of customers for billing, once the route's
+// limiters and quota admitted them. It runs ahead of the routes, after
+// Authenticate, and records the customer once they are done; requests of
+// anonymous callers are not metered.
+func Meter(m *metering.Meter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		customer := ctx.GetString(CustomerKey)
+		if customer == "" || ctx.IsAborted() {
+			return
+		}
+		m.Record(customer, routeName(ctx))
+	}
+}
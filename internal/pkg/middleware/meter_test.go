@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/metering"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+func TestMeterReconcilesWithQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	metered := plans.Plan{Name: t.Name(), Quotas: map[string]quota.Quota{
+		"/download": {Period: quota.Daily, Limit: 5},
+	}}
+	resolver := plans.NewResolver(planSource{"meter-customer": {Customer: "meter-customer", Plan: metered}}, plans.Config{})
+	auth, secrets := issue("meter-customer")
+	quotas := quota.New(quota.NewMemoryStore(), quota.Config{Clock: clock})
+	store := metering.NewMemoryStore()
+	meter := metering.New(store, metering.Config{Clock: clock})
+
+	r := gin.New()
+	r.Use(Authenticate(auth), Meter(meter))
+	r.GET("/download",
+		PlanLimit(resolver, ratelimit.NewFixedWindow(100, time.Minute, ratelimit.WithName("meter-default"))),
+		Quota(quotas),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 8; i++ {
+		req := httptest.NewRequest("GET", "/download", nil)
+		req.Header.Set("X-API-Key", secrets[0])
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		// Spread over two hours of the same day
+		clock.Advance(15 * time.Minute)
+	}
+	meter.Flush(context.Background())
+
+	var billed int64
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	store.Rows(context.Background(), day, day.AddDate(0, 0, 1), func(r metering.Row) error {
+		billed += r.Requests
+		return nil
+	})
+	usage, _ := quotas.Usage(context.Background(), "meter-customer", day)
+	if len(usage) != 1 || billed != int64(usage[0].Used) || billed != 5 {
+		t.Errorf("billed %d requests, quota counted %+v; want both 5", billed, usage)
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Context keys set by PlanLimit for the handlers behind it.
+const (
+	PlanKey         = "plan"
+	SubscriptionKey = "subscription"
+)
+
+// PlanLimit enforces the limit the plan of the caller sets on the route. It
+// must run after Authenticate or JWT, which identify the customer. The plan
+// is the one named by the TierKey JWT sets, or else the customer's stored
+// subscription. Customers are limited on their ID, so all their keys share
+// one quota. Anonymous callers, plans not listing the route and failed plan
+// lookups fall back to l.
+func PlanLimit(resolver *plans.Resolver, l *ratelimit.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limitPlan(ctx, resolver, l, l.Key(ctx.Request, func() string { return GetClientIP(ctx) }))
+	}
+}
+
+// resolvePlan returns the subscription of customer, on the plan named by the
+// TierKey JWT sets or else the stored one.
+func resolvePlan(ctx *gin.Context, resolver *plans.Resolver, customer string) (plans.Subscription, error) {
+	if tier := ctx.GetString(TierKey); tier != "" {
+		return resolver.ResolveTier(ctx.Request.Context(), customer, tier)
+	}
+	return resolver.Resolve(ctx.Request.Context(), customer)
+}
+
+// limitPlan enforces the limit the plan of the caller sets on the route, or
+// else l on key.
+func limitPlan(ctx *gin.Context, resolver *plans.Resolver, l *ratelimit.Limiter, key string) {
+	customer := ctx.GetString(CustomerKey)
+	if customer == "" {
+		enforce(ctx, l, key)
+		return
+	}
+
+	sub, err := resolvePlan(ctx, resolver, customer)
+	if err != nil {
+		// The plan store being down must not take the API down with it
+		enforce(ctx, l, key)
+		return
+	}
+
+	ctx.Set(PlanKey, sub.Plan.Name)
+	ctx.Set(SubscriptionKey, sub)
+	if planLimiter := resolver.Limiter(sub, routeName(ctx)); planLimiter != nil {
+		enforce(ctx, planLimiter, sub.Customer)
+		return
+	}
+	enforce(ctx, l, key)
+}
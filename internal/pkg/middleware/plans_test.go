@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+type planSource map[string]plans.Subscription
+
+func (s planSource) Subscription(_ context.Context, customer string) (plans.Subscription, error) {
+	sub, ok := s[customer]
+	if !ok {
+		return plans.Subscription{}, plans.ErrUnknownCustomer
+	}
+	return sub, nil
+}
+
+func (s planSource) Plan(_ context.Context, name string) (plans.Plan, error) {
+	for _, sub := range s {
+		if sub.Plan.Name == name {
+			return sub.Plan, nil
+		}
+	}
+	return plans.Plan{}, plans.ErrUnknownPlan
+}
+
+func TestPlanLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fixed := func(limit int) plans.Limit {
+		return plans.Limit{Algorithm: ratelimit.AlgorithmFixedWindow, Limit: limit, Window: time.Minute}
+	}
+	free := plans.Plan{Name: t.Name() + " free", Limits: map[string]plans.Limit{"/limited": fixed(1)}}
+	pro := plans.Plan{Name: t.Name() + " pro", Limits: map[string]plans.Limit{"/limited": fixed(3)}}
+	resolver := plans.NewResolver(planSource{
+		"plan-free-customer": {Customer: "plan-free-customer", Plan: free},
+		"plan-pro-customer":  {Customer: "plan-pro-customer", Plan: pro},
+	}, plans.Config{})
+	auth, secrets := issue("plan-free-customer", "plan-pro-customer", "plan-pro-customer")
+	keys := map[string]string{"free": secrets[0], "pro": secrets[1], "pro-2": secrets[2], "bogus": "bogus"}
+
+	r := gin.New()
+	r.Use(Authenticate(auth))
+	r.GET("/limited", PlanLimit(resolver, ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithName("plan-default"))),
+		func(c *gin.Context) { c.String(http.StatusOK, c.GetString(PlanKey)) })
+
+	tests := []struct {
+		apiKey string
+		status int
+	}{
+		{"free", http.StatusOK},
+		{"free", http.StatusTooManyRequests},
+		// Both keys of the customer share the pro quota
+		{"pro", http.StatusOK},
+		{"pro-2", http.StatusOK},
+		{"pro", http.StatusOK},
+		{"pro-2", http.StatusTooManyRequests},
+		{"bogus", http.StatusUnauthorized},
+		// Anonymous callers get the route's default limit
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+		{"", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = "192.0.2.44:1234"
+		if tt.apiKey != "" {
+			req.Header.Set("X-API-Key", keys[tt.apiKey])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("request %d with key %q: got %d want %d", i, tt.apiKey, w.Code, tt.status)
+		}
+	}
+}
+
+func TestPlanLimitRoutesApart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	plan := plans.Plan{Name: t.Name(), Limits: map[string]plans.Limit{
+		"/hourly": {Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 2, Window: time.Hour},
+		"/second": {Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 1, Window: time.Second},
+	}}
+	resolver := plans.NewResolver(planSource{"plan-routes-customer": {Customer: "plan-routes-customer", Plan: plan}}, plans.Config{})
+	auth, secrets := issue("plan-routes-customer")
+
+	r := gin.New()
+	r.Use(Authenticate(auth))
+	fallback := ratelimit.NewFixedWindow(100, time.Minute, ratelimit.WithName(t.Name()+" default"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/hourly", PlanLimit(resolver, fallback), ok)
+	r.GET("/second", PlanLimit(resolver, fallback), ok)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/hourly", http.StatusOK},
+		// The routes of a plan do not throttle each other
+		{"/second", http.StatusOK},
+		{"/second", http.StatusTooManyRequests},
+		// and the short window does not reset the long one
+		{"/hourly", http.StatusOK},
+		{"/hourly", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("X-API-Key", secrets[0])
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("request %d (%s): got %d want %d", i, tt.path, w.Code, tt.status)
+		}
+	}
+}
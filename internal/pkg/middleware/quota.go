@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+)
+
+// Quota enforces the quota the plan of the caller sets on the route. It
+// must run after PlanLimit, which resolves the plan of the caller; requests
+// of anonymous callers and routes without a quota pass through. The quota is
+// reported in the X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset
+// (Unix time of the next reset) headers.
+func Quota(quotas *quota.Quotas) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		v, ok := ctx.Get(SubscriptionKey)
+		if !ok {
+			ctx.Next()
+			return
+		}
+		sub := v.(plans.Subscription)
+		route := routeName(ctx)
+		q, ok := sub.Plan.Quotas[route]
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		res, err := quotas.Consume(ctx.Request.Context(), sub.Customer, route, q, 1)
+		if err != nil {
+			// Usage that could not be counted is not billed, but the
+			// request is served
+			ctx.Next()
+			return
+		}
+
+		h := ctx.Writer.Header()
+		h.Set("X-Quota-Limit", strconv.Itoa(res.Limit))
+		h.Set("X-Quota-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("X-Quota-Reset", strconv.FormatInt(res.Reset.Unix(), 10))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Round(time.Second)/time.Second)))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":    "quota exceeded",
+				"period":   q.Period,
+				"limit":    res.Limit,
+				"reset_at": res.Reset,
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
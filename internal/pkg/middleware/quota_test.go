@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+func TestQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC))
+	basic := plans.Plan{Name: t.Name(), Quotas: map[string]quota.Quota{
+		"/download": {Period: quota.Daily, Limit: 2},
+	}}
+	resolver := plans.NewResolver(planSource{"quota-customer": {Customer: "quota-customer", Plan: basic}}, plans.Config{})
+	auth, secrets := issue("quota-customer")
+	quotas := quota.New(quota.NewMemoryStore(), quota.Config{Clock: clock})
+
+	r := gin.New()
+	r.Use(Authenticate(auth))
+	handler := []gin.HandlerFunc{
+		PlanLimit(resolver, ratelimit.NewFixedWindow(100, time.Minute, ratelimit.WithName("quota-default"))),
+		Quota(quotas),
+		func(c *gin.Context) { c.Status(http.StatusOK) },
+	}
+	r.GET("/download", handler...)
+	r.GET("/free", handler...)
+
+	get := func(path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, want := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		w := get("/download", secrets[0])
+		if w.Code != want.status || w.Header().Get("X-Quota-Remaining") != want.remaining {
+			t.Errorf("request %d: got %d with %q remaining, want %d with %q",
+				i, w.Code, w.Header().Get("X-Quota-Remaining"), want.status, want.remaining)
+		}
+		if got := w.Header().Get("X-Quota-Reset"); got != "1738368000" {
+			t.Errorf("request %d: got X-Quota-Reset %q, want midnight", i, got)
+		}
+		if want.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "43200" {
+			t.Errorf("request %d: got Retry-After %q, want the 12h until midnight", i, w.Header().Get("Retry-After"))
+		}
+	}
+
+	// Routes without a quota and anonymous callers are not counted
+	if w := get("/free", secrets[0]); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "" {
+		t.Errorf("route without quota: got %d with %q remaining", w.Code, w.Header().Get("X-Quota-Remaining"))
+	}
+	if w := get("/download", ""); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "" {
+		t.Errorf("anonymous caller: got %d with %q remaining", w.Code, w.Header().Get("X-Quota-Remaining"))
+	}
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Segment kinds of route patterns, ranked by how specific they are.
+const (
+	segmentWildcard = iota
+	segmentParam
+	segmentStatic
+	// segmentEnd ranks the end of a pattern above a wildcard matching
+	// nothing, so "/a" beats "/a/*" on "/a".
+	segmentEnd
+)
+
+// RoutePolicies picks the limiter of a request from its method and route
+// template, e.g. "POST /instagram/*" or "GET /users/:id". Patterns are
+// matched against the Gin route template rather than the request path, so
+// ":name" matches any one segment, "*" any remaining segments, and other
+// segments only the same segment of the template. The method is optional
+// and "*" matches every method.
+//
+// The most specific pattern wins: segment by segment from the left, a static
+// segment beats a parameter, which beats a wildcard. Among equally specific
+// patterns one naming the method beats one that does not, and then the
+// first added wins. Requests matching no pattern get the default limiter,
+// if any.
+type RoutePolicies struct {
+	rules    []policyRule
+	fallback *ratelimit.Limiter
+}
+
+// policyRule is a pattern and the limiter it applies.
+type policyRule struct {
+	method   string
+	segments []string
+	ranks    []int
+	limiter  *ratelimit.Limiter
+}
+
+// NewRoutePolicies returns policies applying fallback to the requests no
+// pattern matches. A nil fallback leaves them unlimited.
+func NewRoutePolicies(fallback *ratelimit.Limiter) *RoutePolicies {
+	return &RoutePolicies{fallback: fallback}
+}
+
+// Add applies l to the requests matching pattern. It panics if pattern is
+// malformed.
+func (p *RoutePolicies) Add(pattern string, l *ratelimit.Limiter) *RoutePolicies {
+	rule, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("middleware: invalid route pattern %q: %v", pattern, err))
+	}
+	rule.limiter = l
+	p.rules = append(p.rules, rule)
+	// Stable, so the first added wins among equally specific patterns
+	sort.SliceStable(p.rules, func(i, j int) bool { return p.rules[i].before(p.rules[j]) })
+	return p
+}
+
+// Match returns the limiter of requests with method on route, the route
+// template, or nil when neither a pattern nor a default applies.
+func (p *RoutePolicies) Match(method, route string) *ratelimit.Limiter {
+	segments := splitPath(route)
+	for _, rule := range p.rules {
+		if rule.matches(method, segments) {
+			return rule.limiter
+		}
+	}
+	return p.fallback
+}
+
+// parsePattern parses "[METHOD ]PATH".
+func parsePattern(pattern string) (policyRule, error) {
+	var rule policyRule
+	path := pattern
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		rule.method, path = method, strings.TrimSpace(rest)
+		if rule.method == "*" {
+			rule.method = ""
+		} else if rule.method != strings.ToUpper(rule.method) {
+			return rule, fmt.Errorf("method %q must be upper case", rule.method)
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return rule, fmt.Errorf("path must start with /")
+	}
+
+	rule.segments = splitPath(path)
+	for i, segment := range rule.segments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			if i != len(rule.segments)-1 {
+				return rule, fmt.Errorf("wildcard must be the last segment")
+			}
+			rule.ranks = append(rule.ranks, segmentWildcard)
+		case strings.HasPrefix(segment, ":"):
+			rule.ranks = append(rule.ranks, segmentParam)
+		default:
+			rule.ranks = append(rule.ranks, segmentStatic)
+		}
+	}
+	if len(rule.ranks) == 0 || rule.ranks[len(rule.ranks)-1] != segmentWildcard {
+		rule.ranks = append(rule.ranks, segmentEnd)
+	}
+	return rule, nil
+}
+
+// splitPath returns the segments of path, ignoring empty ones.
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// before reports whether r is more specific than o.
+func (r policyRule) before(o policyRule) bool {
+	for i := 0; i < len(r.ranks) && i < len(o.ranks); i++ {
+		if r.ranks[i] != o.ranks[i] {
+			return r.ranks[i] > o.ranks[i]
+		}
+	}
+	return r.method != "" && o.method == ""
+}
+
+// matches reports whether r applies to requests with method on the route
+// template split into segments.
+func (r policyRule) matches(method string, segments []string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	for i, segment := range r.segments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			return true
+		case i >= len(segments):
+			return false
+		case strings.HasPrefix(segment, ":"):
+		case segment != segments[i]:
+			return false
+		}
+	}
+	return len(segments) == len(r.segments)
+}
+
+// RouteLimit enforces the limiter p matches to each request. Clients are
+// identified as by Limit, so overrides and resets of their key apply, and
+// the routes a pattern matches share its limit.
+func RouteLimit(p *RoutePolicies) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		l := p.Match(ctx.Request.Method, ctx.FullPath())
+		if l == nil {
+			ctx.Next()
+			return
+		}
+		enforce(ctx, l, l.Key(ctx.Request, func() string { return GetClientIP(ctx) }))
+	}
+}
+
+// RoutePlanLimit is like RouteLimit with the limits of the caller's plan
+// taking precedence, as in PlanLimit.
+func RoutePlanLimit(resolver *plans.Resolver, p *RoutePolicies) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		l := p.Match(ctx.Request.Method, ctx.FullPath())
+		if l == nil {
+			ctx.Next()
+			return
+		}
+		limitPlan(ctx, resolver, l, l.Key(ctx.Request, func() string { return GetClientIP(ctx) }))
+	}
+}
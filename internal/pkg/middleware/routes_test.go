@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+func TestRoutePoliciesMatch(t *testing.T) {
+	named := func(name string) *ratelimit.Limiter {
+		return ratelimit.NewFixedWindow(1, time.Minute, ratelimit.WithName(name))
+	}
+	p := NewRoutePolicies(named("default")).
+		Add("/*", named("any")).
+		Add("POST /instagram/*", named("instagram")).
+		Add("POST /instagram/download", named("download")).
+		Add("GET /users/:id", named("user")).
+		Add("* /users/:id", named("user any method")).
+		Add("/users/me", named("me")).
+		Add("GET /users/:id/*", named("user subtree")).
+		Add("GET /users/:id/*rest", named("user subtree again")).
+		Add("GET /", named("root"))
+
+	tests := []struct {
+		method, route string
+		want          string
+	}{
+		{"POST", "/instagram/download", "download"},
+		{"POST", "/instagram/profile", "instagram"},
+		{"POST", "/instagram", "instagram"},
+		// Methods not named fall through to less specific patterns
+		{"GET", "/instagram/download", "any"},
+		{"GET", "/users/:id", "user"},
+		{"DELETE", "/users/:id", "user any method"},
+		// Static segments of patterns match the template, not the path
+		{"GET", "/users/me", "me"},
+		{"GET", "/users/:id/posts", "user subtree"},
+		{"GET", "/", "root"},
+		{"POST", "/", "any"},
+	}
+	for _, tt := range tests {
+		if got := p.Match(tt.method, tt.route).Policy().Name; got != tt.want {
+			t.Errorf("%s %s: got %q want %q", tt.method, tt.route, got, tt.want)
+		}
+	}
+
+	if got := NewRoutePolicies(nil).Match("GET", "/"); got != nil {
+		t.Errorf("got %v want no limiter", got)
+	}
+}
+
+func TestRoutePoliciesRejectMalformedPatterns(t *testing.T) {
+	for _, pattern := range []string{"", "users", "GET users", "get /users", "GET /*/users"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: want panic", pattern)
+				}
+			}()
+			NewRoutePolicies(nil).Add(pattern, ratelimit.NewFixedWindow(1, time.Minute))
+		}()
+	}
+}
+
+func TestRouteLimitKeysOnClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := NewRoutePolicies(ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithName(t.Name()+" default"))).
+		Add("GET /users/:id", ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithName(t.Name()+" users")))
+
+	r := gin.New()
+	r.Use(RouteLimit(p))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/users/:id", ok)
+	r.GET("/other", ok)
+
+	get := func(path, ip string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		// Path parameters share the limit of their pattern
+		{"/users/1", http.StatusOK},
+		{"/users/2", http.StatusOK},
+		{"/users/3", http.StatusTooManyRequests},
+		// The default policy limits the client across the routes it covers
+		{"/other", http.StatusOK},
+		{"/missing", http.StatusNotFound},
+		{"/other", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		if got := get(tt.path, "192.0.2.49"); got != tt.status {
+			t.Errorf("request %d (%s): got %d want %d", i, tt.path, got, tt.status)
+		}
+	}
+
+	// Overrides and resets of the client key apply to route policies
+	ratelimit.SetOverride("198.51.100.9", 0, 0)
+	t.Cleanup(func() { ratelimit.RemoveOverride("198.51.100.9") })
+	if got := get("/users/1", "198.51.100.9"); got != http.StatusTooManyRequests {
+		t.Errorf("overridden client: got %d want %d", got, http.StatusTooManyRequests)
+	}
+	ratelimit.ResetKey("192.0.2.49")
+	if got := get("/users/1", "192.0.2.49"); got != http.StatusOK {
+		t.Errorf("reset client: got %d want %d", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,302 @@
+// Package plans applies per-customer subscription plans to rate limits.
+// Each plan holds its own limits and quotas keyed by route template, and a
+// Resolver maps customers to their plan, caching the answers of the store
+// plans are kept in.
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/quota"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+var (
+	// ErrUnknownCustomer is returned for customers that do not exist.
+	ErrUnknownCustomer = errors.New("unknown customer")
+	// ErrUnknownPlan is returned for plans that do not exist.
+	ErrUnknownPlan = errors.New("unknown plan")
+)
+
+// Limit is the limit a plan sets on one route. For window algorithms Limit
+// is the number of requests per Window; for the token bucket Limit is the
+// refill rate per second and Burst the bucket size.
+type Limit struct {
+	Algorithm ratelimit.Algorithm
+	Limit     int
+	Window    time.Duration
+	Burst     int
+}
+
+// MarshalJSON renders the window in a human readable form.
+func (l Limit) MarshalJSON() ([]byte, error) {
+	out := limitJSON{Algorithm: l.Algorithm, Limit: l.Limit, Burst: l.Burst}
+	if l.Window > 0 {
+		out.Window = l.Window.String()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON parses a limit, with the window as a duration such as 1m.
+func (l *Limit) UnmarshalJSON(data []byte) error {
+	var in limitJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	*l = Limit{Algorithm: in.Algorithm, Limit: in.Limit, Burst: in.Burst}
+	if in.Window != "" {
+		d, err := time.ParseDuration(in.Window)
+		if err != nil {
+			return fmt.Errorf("invalid window %q: %v", in.Window, err)
+		}
+		l.Window = d
+	}
+	return nil
+}
+
+type limitJSON struct {
+	Algorithm ratelimit.Algorithm `json:"algorithm"`
+	Limit     int                 `json:"limit"`
+	Window    string              `json:"window,omitempty"`
+	Burst     int                 `json:"burst,omitempty"`
+}
+
+// Validate reports whether the limit can be enforced.
+func (l Limit) Validate() error {
+	switch l.Algorithm {
+	case ratelimit.AlgorithmFixedWindow, ratelimit.AlgorithmSlidingWindow:
+		if l.Window <= 0 {
+			return fmt.Errorf("%s needs a positive window", l.Algorithm)
+		}
+	case ratelimit.AlgorithmTokenBucket:
+		if l.Burst <= 0 {
+			return fmt.Errorf("%s needs a positive burst", l.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q", l.Algorithm)
+	}
+	if l.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+// Plan is a named set of limits and quotas, keyed by the route template
+// they apply to. Routes a plan does not list keep their default limit and
+// have no quota.
+type Plan struct {
+	Name   string                 `json:"name"`
+	Limits map[string]Limit       `json:"limits"`
+	Quotas map[string]quota.Quota `json:"quotas,omitempty"`
+}
+
+// Validate reports whether every limit and quota of the plan can be enforced.
+func (p Plan) Validate() error {
+	if p.Name == "" {
+		return errors.New("plan name is required")
+	}
+	for route, l := range p.Limits {
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("route %s: %v", route, err)
+		}
+	}
+	for route, q := range p.Quotas {
+		if err := q.Validate(); err != nil {
+			return fmt.Errorf("quota of route %s: %v", route, err)
+		}
+	}
+	return nil
+}
+
+// Subscription is the plan a customer is on.
+type Subscription struct {
+	Customer string
+	Plan     Plan
+}
+
+// Source looks up the subscription of a customer, returning
+// ErrUnknownCustomer when there is none, and plans by name, returning
+// ErrUnknownPlan when there is none.
+type Source interface {
+	Subscription(ctx context.Context, customer string) (Subscription, error)
+	Plan(ctx context.Context, name string) (Plan, error)
+}
+
+// Config configures a Resolver. Zero values get the defaults noted below.
+type Config struct {
+	// TTL is how long an answer of the source is reused (default 1m). It
+	// bounds how long other replicas apply a changed plan or assignment.
+	TTL time.Duration
+	// MaxCustomers bounds the number of cached customers (default 10000).
+	MaxCustomers int
+	// Options are added to the options of every limiter built for a plan.
+	Options []ratelimit.Option
+	// Clock is the time source (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// Resolver finds the plan of a customer and enforces it with one limiter per
+// plan and route.
+type Resolver struct {
+	source Source
+	cfg    Config
+
+	mu       sync.Mutex
+	cache    map[string]cached
+	tiers    map[string]cached
+	limiters map[string]planLimiter
+}
+
+// cached is the answer of the source for a customer or a plan name.
+type cached struct {
+	sub     Subscription
+	err     error
+	expires time.Time
+}
+
+// planLimiter is the limiter enforcing a plan's limit on a route.
+type planLimiter struct {
+	limit   Limit
+	limiter *ratelimit.Limiter
+}
+
+// NewResolver returns a Resolver looking up plans in source.
+func NewResolver(source Source, cfg Config) *Resolver {
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Minute
+	}
+	if cfg.MaxCustomers <= 0 {
+		cfg.MaxCustomers = 10000
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+
+	return &Resolver{
+		source:   source,
+		cfg:      cfg,
+		cache:    make(map[string]cached),
+		tiers:    make(map[string]cached),
+		limiters: make(map[string]planLimiter),
+	}
+}
+
+// Resolve returns the subscription of customer. Unknown customers are cached
+// too, so they cannot flood the source.
+func (r *Resolver) Resolve(ctx context.Context, customer string) (Subscription, error) {
+	now := r.cfg.Clock.Now()
+
+	r.mu.Lock()
+	c, ok := r.cache[customer]
+	r.mu.Unlock()
+	if ok && now.Before(c.expires) {
+		return c.sub, c.err
+	}
+
+	sub, err := r.source.Subscription(ctx, customer)
+	if err != nil && !errors.Is(err, ErrUnknownCustomer) {
+		// Not an answer about the customer, ask again next time
+		return Subscription{}, err
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= r.cfg.MaxCustomers {
+		clear(r.cache)
+	}
+	r.cache[customer] = cached{sub: sub, err: err, expires: now.Add(r.cfg.TTL)}
+	r.mu.Unlock()
+	return sub, err
+}
+
+// ResolveTier returns customer subscribed to the plan named tier, for callers
+// whose plan is asserted by their credentials, such as a token claim, rather
+// than stored. Unknown plans are cached too.
+func (r *Resolver) ResolveTier(ctx context.Context, customer, tier string) (Subscription, error) {
+	now := r.cfg.Clock.Now()
+
+	r.mu.Lock()
+	c, ok := r.tiers[tier]
+	r.mu.Unlock()
+	if !ok || !now.Before(c.expires) {
+		plan, err := r.source.Plan(ctx, tier)
+		if err != nil && !errors.Is(err, ErrUnknownPlan) {
+			return Subscription{}, err
+		}
+
+		c = cached{sub: Subscription{Plan: plan}, err: err, expires: now.Add(r.cfg.TTL)}
+		r.mu.Lock()
+		if len(r.tiers) >= r.cfg.MaxCustomers {
+			clear(r.tiers)
+		}
+		r.tiers[tier] = c
+		r.mu.Unlock()
+	}
+
+	if c.err != nil {
+		return Subscription{}, c.err
+	}
+	return Subscription{Customer: customer, Plan: c.sub.Plan}, nil
+}
+
+// Limiter returns the limiter enforcing the limit of sub's plan on route,
+// or nil when the plan keeps the route's default limit.
+func (r *Resolver) Limiter(sub Subscription, route string) *ratelimit.Limiter {
+	limit, ok := sub.Plan.Limits[route]
+	if !ok {
+		return nil
+	}
+
+	id := sub.Plan.Name + " " + route
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if pl, ok := r.limiters[id]; ok && pl.limit == limit {
+		return pl.limiter
+	}
+	pl := planLimiter{limit: limit, limiter: r.newLimiter(id, limit)}
+	r.limiters[id] = pl
+	return pl.limiter
+}
+
+// newLimiter builds the limiter enforcing limit under the policy name.
+func (r *Resolver) newLimiter(name string, limit Limit) *ratelimit.Limiter {
+	opts := append([]ratelimit.Option{ratelimit.WithName(name)}, r.cfg.Options...)
+	opts = append(opts, ratelimit.WithClock(r.cfg.Clock))
+
+	switch limit.Algorithm {
+	case ratelimit.AlgorithmSlidingWindow:
+		return ratelimit.NewSlidingWindow(limit.Limit, limit.Window, opts...)
+	case ratelimit.AlgorithmTokenBucket:
+		return ratelimit.NewTokenBucket(limit.Limit, limit.Burst, opts...)
+	default:
+		return ratelimit.NewFixedWindow(limit.Limit, limit.Window, opts...)
+	}
+}
+
+// Invalidate forgets the cached subscription of customer, e.g. once they
+// moved to another plan.
+func (r *Resolver) Invalidate(customer string) {
+	r.mu.Lock()
+	delete(r.cache, customer)
+	r.mu.Unlock()
+}
+
+// InvalidatePlan forgets the cached subscriptions to the named plan, e.g.
+// once its limits changed.
+func (r *Resolver) InvalidatePlan(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tiers, name)
+	for customer, c := range r.cache {
+		if c.sub.Plan.Name == name {
+			delete(r.cache, customer)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package plans
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// fakeSource serves subscriptions from a map, counting lookups.
+type fakeSource struct {
+	mu      sync.Mutex
+	subs    map[string]Subscription
+	err     error
+	lookups int
+}
+
+func (s *fakeSource) Subscription(_ context.Context, customer string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookups++
+	if s.err != nil {
+		return Subscription{}, s.err
+	}
+	sub, ok := s.subs[customer]
+	if !ok {
+		return Subscription{}, ErrUnknownCustomer
+	}
+	return sub, nil
+}
+
+func (s *fakeSource) Plan(_ context.Context, name string) (Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookups++
+	if s.err != nil {
+		return Plan{}, s.err
+	}
+	for _, sub := range s.subs {
+		if sub.Plan.Name == name {
+			return sub.Plan, nil
+		}
+	}
+	return Plan{}, ErrUnknownPlan
+}
+
+func newSource() *fakeSource {
+	free := Plan{Name: "free", Limits: map[string]Limit{
+		"/fixed": {Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 2, Window: time.Minute},
+	}}
+	return &fakeSource{subs: map[string]Subscription{
+		"acme": {Customer: "acme", Plan: free},
+	}}
+}
+
+func TestResolverCachesAnswers(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := newSource()
+	r := NewResolver(source, Config{TTL: time.Minute, Clock: clock})
+
+	for i := 0; i < 3; i++ {
+		if sub, err := r.Resolve(context.Background(), "acme"); err != nil || sub.Customer != "acme" {
+			t.Fatalf("got %+v, %v; want acme", sub, err)
+		}
+		if _, err := r.Resolve(context.Background(), "bogus"); !errors.Is(err, ErrUnknownCustomer) {
+			t.Fatalf("got %v want ErrUnknownCustomer", err)
+		}
+	}
+	if source.lookups != 2 {
+		t.Errorf("got %d lookups want 2", source.lookups)
+	}
+
+	clock.Advance(time.Minute)
+	r.Resolve(context.Background(), "acme")
+	if source.lookups != 3 {
+		t.Errorf("got %d lookups after the TTL, want 3", source.lookups)
+	}
+}
+
+func TestResolverInvalidation(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := newSource()
+	r := NewResolver(source, Config{Clock: clock})
+	r.Resolve(context.Background(), "acme")
+
+	pro := Plan{Name: "pro"}
+	source.subs["acme"] = Subscription{Customer: "acme", Plan: pro}
+	r.Invalidate("acme")
+	if sub, _ := r.Resolve(context.Background(), "acme"); sub.Plan.Name != "pro" {
+		t.Errorf("got plan %q after Invalidate, want pro", sub.Plan.Name)
+	}
+
+	pro.Limits = map[string]Limit{"/fixed": {Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 1, Burst: 5}}
+	source.subs["acme"] = Subscription{Customer: "acme", Plan: pro}
+	r.InvalidatePlan("pro")
+	if sub, _ := r.Resolve(context.Background(), "acme"); len(sub.Plan.Limits) != 1 {
+		t.Errorf("got limits %v after InvalidatePlan, want the new ones", sub.Plan.Limits)
+	}
+}
+
+func TestResolveTier(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := newSource()
+	r := NewResolver(source, Config{Clock: clock})
+
+	for _, subject := range []string{"alice", "bob"} {
+		sub, err := r.ResolveTier(context.Background(), subject, "free")
+		if err != nil || sub.Customer != subject || sub.Plan.Name != "free" {
+			t.Fatalf("got %+v, %v; want %s on free", sub, err, subject)
+		}
+	}
+	if _, err := r.ResolveTier(context.Background(), "alice", "gold"); !errors.Is(err, ErrUnknownPlan) {
+		t.Errorf("got %v want ErrUnknownPlan", err)
+	}
+	if source.lookups != 2 {
+		t.Errorf("got %d lookups want 2", source.lookups)
+	}
+
+	r.InvalidatePlan("free")
+	r.ResolveTier(context.Background(), "alice", "free")
+	if source.lookups != 3 {
+		t.Errorf("got %d lookups after InvalidatePlan, want 3", source.lookups)
+	}
+}
+
+func TestResolverDoesNotCacheErrors(t *testing.T) {
+	source := newSource()
+	source.err = errors.New("connection refused")
+	r := NewResolver(source, Config{})
+
+	r.Resolve(context.Background(), "acme")
+	source.err = nil
+	if sub, err := r.Resolve(context.Background(), "acme"); err != nil || sub.Customer != "acme" {
+		t.Errorf("got %+v, %v after the source recovered, want acme", sub, err)
+	}
+}
+
+func TestResolverLimiters(t *testing.T) {
+	r := NewResolver(newSource(), Config{})
+	sub, _ := r.Resolve(context.Background(), "acme")
+
+	l := r.Limiter(sub, "/fixed")
+	if l == nil || l.Policy().Limit != 2 || l.Policy().Name != "free /fixed" {
+		t.Fatalf("got limiter %+v, want free /fixed with limit 2", l)
+	}
+	if r.Limiter(sub, "/fixed") != l {
+		t.Errorf("limiter was rebuilt for an unchanged plan")
+	}
+	if r.Limiter(sub, "/sliding") != nil {
+		t.Errorf("got a limiter for a route the plan does not list")
+	}
+
+	sub.Plan.Limits = map[string]Limit{"/fixed": {Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 5, Window: time.Minute}}
+	if got := r.Limiter(sub, "/fixed").Policy().Limit; got != 5 {
+		t.Errorf("got limit %d after the plan changed, want 5", got)
+	}
+}
+
+func TestLimitJSON(t *testing.T) {
+	var l Limit
+	if err := l.UnmarshalJSON([]byte(`{"algorithm":"sliding_window","limit":10,"window":"1m"}`)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if l.Window != time.Minute || l.Validate() != nil {
+		t.Errorf("got %+v, %v; want a valid 1m window", l, l.Validate())
+	}
+
+	out, _ := l.MarshalJSON()
+	if string(out) != `{"algorithm":"sliding_window","limit":10,"window":"1m0s"}` {
+		t.Errorf("got %s", out)
+	}
+
+	if err := (Limit{Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 1}).Validate(); err == nil {
+		t.Errorf("fixed window without a window was accepted")
+	}
+}
@@ -0,0 +1,196 @@
+// Package quota enforces long-horizon quotas, such as downloads per month,
+// separately from the short-term rate limiters. Usage is counted per
+// customer in calendar periods and kept in a persistent Store.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Period is the calendar period a quota resets on.
+type Period string
+
+const (
+	Daily   Period = "day"
+	Monthly Period = "month"
+)
+
+// Bounds returns the start and end of the period containing t, on the
+// calendar of loc. Days are not always 24 hours long around DST changes.
+func (p Period) Bounds(t time.Time, loc *time.Location) (start, end time.Time) {
+	t = t.In(loc)
+	switch p {
+	case Monthly:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// Quota is a number of requests allowed per calendar period.
+type Quota struct {
+	Period Period `json:"period"`
+	Limit  int    `json:"limit"`
+}
+
+// Validate reports whether the quota can be enforced.
+func (q Quota) Validate() error {
+	if q.Period != Daily && q.Period != Monthly {
+		return fmt.Errorf("unknown period %q, want day or month", q.Period)
+	}
+	if q.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+// Usage is the consumption of a quota in one period.
+type Usage struct {
+	Quota       string    `json:"quota"`
+	PeriodStart time.Time `json:"period_start"`
+	Used        int       `json:"used"`
+}
+
+// Store persists the usage of every customer.
+type Store interface {
+	// Consume adds n to the usage of customer's quota in the period
+	// starting at start, unless that would exceed limit. It returns the
+	// usage after the call and whether n was added.
+	Consume(ctx context.Context, customer, quota string, start time.Time, n, limit int) (used int, ok bool, err error)
+	// Usage returns the usage of customer in the periods started at or
+	// after since, most recent first.
+	Usage(ctx context.Context, customer string, since time.Time) ([]Usage, error)
+}
+
+// Config configures Quotas. Zero values get the defaults noted below.
+type Config struct {
+	// Location is the timezone periods start in (default UTC).
+	Location *time.Location
+	// Clock is the time source (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// Result is the outcome of consuming a quota.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the end of the current period.
+	Reset time.Time
+	// RetryAfter is how long until the period ends, set when the request
+	// was denied.
+	RetryAfter time.Duration
+}
+
+var quotaDecisions = metrics.NewCounterVec(
+	"quota_decisions_total",
+	"Quota decisions by quota and outcome (allowed or exceeded).",
+	"quota", "outcome",
+)
+
+// Quotas counts the usage of customers against their quotas.
+type Quotas struct {
+	store Store
+	cfg   Config
+}
+
+// New returns Quotas keeping usage in store.
+func New(store Store, cfg Config) *Quotas {
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+	return &Quotas{store: store, cfg: cfg}
+}
+
+// Bounds returns the start and end of the current period of q.
+func (qs *Quotas) Bounds(q Quota) (start, end time.Time) {
+	return q.Period.Bounds(qs.cfg.Clock.Now(), qs.cfg.Location)
+}
+
+// Consume takes n requests from customer's quota named name, in the current
+// period. Requests over the quota are not counted.
+func (qs *Quotas) Consume(ctx context.Context, customer, name string, q Quota, n int) (Result, error) {
+	now := qs.cfg.Clock.Now()
+	start, end := q.Period.Bounds(now, qs.cfg.Location)
+
+	used, ok, err := qs.store.Consume(ctx, customer, name, start, n, q.Limit)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Allowed: ok, Limit: q.Limit, Remaining: max(q.Limit-used, 0), Reset: end}
+	if ok {
+		quotaDecisions.Inc(name, "allowed")
+	} else {
+		quotaDecisions.Inc(name, "exceeded")
+		res.RetryAfter = end.Sub(now)
+	}
+	return res, nil
+}
+
+// Usage returns the usage of customer in the periods started at or after
+// since, most recent first.
+func (qs *Quotas) Usage(ctx context.Context, customer string, since time.Time) ([]Usage, error) {
+	return qs.store.Usage(ctx, customer, since)
+}
+
+// MemoryStore is a Store keeping usage in this process, for tests.
+type MemoryStore struct {
+	mu    sync.Mutex
+	usage map[memoryKey]int
+}
+
+type memoryKey struct {
+	customer, quota string
+	start           time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{usage: make(map[memoryKey]int)}
+}
+
+// Consume implements Store.
+func (s *MemoryStore) Consume(_ context.Context, customer, quota string, start time.Time, n, limit int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memoryKey{customer, quota, start.UTC()}
+	if s.usage[key]+n > limit {
+		return s.usage[key], false, nil
+	}
+	s.usage[key] += n
+	return s.usage[key], true, nil
+}
+
+// Usage implements Store.
+func (s *MemoryStore) Usage(_ context.Context, customer string, since time.Time) ([]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Usage
+	for key, used := range s.usage {
+		if key.customer == customer && !key.start.Before(since) {
+			list = append(list, Usage{Quota: key.quota, PeriodStart: key.start, Used: used})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].PeriodStart.Equal(list[j].PeriodStart) {
+			return list[i].PeriodStart.After(list[j].PeriodStart)
+		}
+		return list[i].Quota < list[j].Quota
+	})
+	return list, nil
+}
@@ -0,0 +1,89 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+func TestPeriodBounds(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		period     Period
+		loc        *time.Location
+		at         time.Time
+		start, end time.Time
+	}{
+		{
+			name:   "day in UTC",
+			period: Daily,
+			loc:    time.UTC,
+			at:     time.Date(2025, 3, 30, 23, 30, 0, 0, time.UTC),
+			start:  time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC),
+			end:    time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 23:30 UTC is already the next day in Paris, which is 23 hours
+			// long as clocks went forward that night
+			name:   "day across DST",
+			period: Daily,
+			loc:    paris,
+			at:     time.Date(2025, 3, 29, 23, 30, 0, 0, time.UTC),
+			start:  time.Date(2025, 3, 30, 0, 0, 0, 0, paris),
+			end:    time.Date(2025, 3, 31, 0, 0, 0, 0, paris),
+		},
+		{
+			name:   "month",
+			period: Monthly,
+			loc:    paris,
+			at:     time.Date(2025, 12, 31, 23, 30, 0, 0, time.UTC),
+			start:  time.Date(2026, 1, 1, 0, 0, 0, 0, paris),
+			end:    time.Date(2026, 2, 1, 0, 0, 0, 0, paris),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.period.Bounds(tt.at, tt.loc)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("got %v - %v want %v - %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestQuotasResetOnCalendar(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 31, 22, 0, 0, 0, time.UTC))
+	quotas := New(NewMemoryStore(), Config{Clock: clock})
+	q := Quota{Period: Monthly, Limit: 2}
+
+	for i, want := range []bool{true, true, false} {
+		res, err := quotas.Consume(context.Background(), "acme", "downloads", q, 1)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+		if res.Allowed != want || res.Remaining != max(1-i, 0) {
+			t.Errorf("request %d: got allowed %v, remaining %d; want %v, %d", i, res.Allowed, res.Remaining, want, max(1-i, 0))
+		}
+		if want := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC); !res.Reset.Equal(want) {
+			t.Errorf("request %d: got reset %v want %v", i, res.Reset, want)
+		}
+	}
+
+	// February starts with a fresh quota
+	clock.Advance(2 * time.Hour)
+	if res, _ := quotas.Consume(context.Background(), "acme", "downloads", q, 1); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("got allowed %v, remaining %d in the next month; want true, 1", res.Allowed, res.Remaining)
+	}
+
+	usage, _ := quotas.Usage(context.Background(), "acme", time.Time{})
+	if len(usage) != 2 || usage[0].Used != 1 || usage[1].Used != 2 {
+		t.Errorf("got usage %+v, want 1 in February then 2 in January", usage)
+	}
+}
@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+// AdaptiveConfig makes a policy's limit follow the health of the routes it
+// protects using additive-increase/multiplicative-decrease (AIMD). Every
+// Interval the effective limit is multiplied by DecreaseFactor when the
+// average handler latency exceeds LatencyTarget or the share of 5xx responses
+// exceeds ErrorRateThreshold, and raised by Increase otherwise, always
+// staying within [Floor, Ceiling].
+type AdaptiveConfig struct {
+	Floor              int
+	Ceiling            int
+	Increase           int
+	DecreaseFactor     float64
+	LatencyTarget      time.Duration
+	ErrorRateThreshold float64
+	Interval           time.Duration
+	// MinSamples is the number of requests an interval needs before it can
+	// count as degraded, so a single slow request does not halve the limit.
+	MinSamples int
+}
+
+// AdaptiveStatus reports the effective limit of an adaptive policy on a route.
+type AdaptiveStatus struct {
+	Policy         string  `json:"policy"`
+	Route          string  `json:"route"`
+	EffectiveLimit int     `json:"effective_limit"`
+	Floor          int     `json:"floor"`
+	Ceiling        int     `json:"ceiling"`
+	AverageLatency string  `json:"average_latency"`
+	ErrorRate      float64 `json:"error_rate"`
+}
+
+var effectiveLimitGauge = metrics.NewGaugeVec(
+	"ratelimit_effective_limit",
+	"Current effective limit of adaptive rate limit policies.",
+	"policy", "route",
+)
+
+// WithAdaptive adjusts the policy limit to backend latency and error rate.
+// Zero fields fall back to sensible defaults: the policy limit as ceiling,
+// a floor of 1, steps of +1 and ×0.5, a 5% error threshold and a 5s interval.
+func WithAdaptive(cfg AdaptiveConfig) Option {
+	return func(p *Policy) {
+		if cfg.Ceiling <= 0 {
+			cfg.Ceiling = p.Limit
+		}
+		if cfg.Floor <= 0 {
+			cfg.Floor = 1
+		}
+		if cfg.Increase <= 0 {
+			cfg.Increase = 1
+		}
+		if cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1 {
+			cfg.DecreaseFactor = 0.5
+		}
+		if cfg.ErrorRateThreshold <= 0 {
+			cfg.ErrorRateThreshold = 0.05
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = 5 * time.Second
+		}
+		if cfg.MinSamples <= 0 {
+			cfg.MinSamples = 10
+		}
+		p.Adaptive = &cfg
+	}
+}
+
+// adaptiveLimit tracks the effective limit of one policy on one route.
+type adaptiveLimit struct {
+	policy string
+	route  string
+	cfg    AdaptiveConfig
+	clock  Clock
+
+	mu          sync.Mutex
+	limit       int
+	windowStart time.Time
+	requests    int
+	errors      int
+	latency     time.Duration
+
+	// Results of the last completed interval, for reporting
+	lastLatency   time.Duration
+	lastErrorRate float64
+}
+
+var (
+	adaptiveMu     sync.Mutex
+	adaptiveLimits = make(map[string]*adaptiveLimit)
+)
+
+// adaptiveFor returns the adaptive state for policy on route, creating it
+// at the policy limit on first use.
+func adaptiveFor(policy Policy, route string) *adaptiveLimit {
+	adaptiveMu.Lock()
+	defer adaptiveMu.Unlock()
+
+	key := policy.Name + "\x00" + route
+	a, exists := adaptiveLimits[key]
+	if !exists {
+		limit := min(max(policy.Limit, policy.Adaptive.Floor), policy.Adaptive.Ceiling)
+		a = &adaptiveLimit{
+			policy:      policy.Name,
+			route:       route,
+			cfg:         *policy.Adaptive,
+			clock:       policy.clock,
+			limit:       limit,
+			windowStart: policy.clock.Now(),
+		}
+		adaptiveLimits[key] = a
+		effectiveLimitGauge.Set(float64(limit), policy.Name, route)
+	}
+	return a
+}
+
+// Limit returns the current effective limit.
+func (a *adaptiveLimit) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// observe records the outcome of an admitted request and adjusts the limit
+// once the current interval is over.
+func (a *adaptiveLimit) observe(latency time.Duration, status int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requests++
+	a.latency += latency
+	if status >= 500 {
+		a.errors++
+	}
+
+	now := a.clock.Now()
+	if now.Sub(a.windowStart) < a.cfg.Interval {
+		return
+	}
+
+	avgLatency := a.latency / time.Duration(a.requests)
+	errorRate := float64(a.errors) / float64(a.requests)
+	degraded := a.requests >= a.cfg.MinSamples &&
+		((a.cfg.LatencyTarget > 0 && avgLatency > a.cfg.LatencyTarget) || errorRate > a.cfg.ErrorRateThreshold)
+
+	if degraded {
+		a.limit = max(a.cfg.Floor, int(float64(a.limit)*a.cfg.DecreaseFactor))
+	} else {
+		a.limit = min(a.cfg.Ceiling, a.limit+a.cfg.Increase)
+	}
+	effectiveLimitGauge.Set(float64(a.limit), a.policy, a.route)
+
+	a.lastLatency = avgLatency
+	a.lastErrorRate = errorRate
+	a.windowStart = now
+	a.requests = 0
+	a.errors = 0
+	a.latency = 0
+}
+
+// AdaptiveLimits returns the effective limits of all adaptive policies.
+func AdaptiveLimits() []AdaptiveStatus {
+	adaptiveMu.Lock()
+	list := make([]*adaptiveLimit, 0, len(adaptiveLimits))
+	for _, a := range adaptiveLimits {
+		list = append(list, a)
+	}
+	adaptiveMu.Unlock()
+
+	statuses := make([]AdaptiveStatus, 0, len(list))
+	for _, a := range list {
+		a.mu.Lock()
+		statuses = append(statuses, AdaptiveStatus{
+			Policy:         a.policy,
+			Route:          a.route,
+			EffectiveLimit: a.limit,
+			Floor:          a.cfg.Floor,
+			Ceiling:        a.cfg.Ceiling,
+			AverageLatency: a.lastLatency.String(),
+			ErrorRate:      a.lastErrorRate,
+		})
+		a.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Policy != statuses[j].Policy {
+			return statuses[i].Policy < statuses[j].Policy
+		}
+		return statuses[i].Route < statuses[j].Route
+	})
+	return statuses
+}
@@ -0,0 +1,361 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// step advances the clock, then checks hits requests against the limiter.
+type step struct {
+	advance    time.Duration
+	hits       int
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+}
+
+func runSteps(t *testing.T, newLimiter func(clock Clock) *Limiter, steps []step) {
+	t.Helper()
+	configureStores(t, StoreConfig{})
+
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := newLimiter(clock)
+
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		hits := s.hits
+		if hits == 0 {
+			hits = 1
+		}
+
+		d := limiter.AllowN(context.Background(), "algorithms-test", t.Name(), hits)
+		if d.Allowed != s.allowed || d.Remaining != s.remaining || d.RetryAfter != s.retryAfter {
+			t.Errorf("step %d: got allowed %v, remaining %d, retry after %v; want %v, %d, %v",
+				i, d.Allowed, d.Remaining, d.RetryAfter, s.allowed, s.remaining, s.retryAfter)
+		}
+	}
+}
+
+func TestFixedWindowBoundaries(t *testing.T) {
+	newLimiter := func(clock Clock) *Limiter {
+		return NewFixedWindow(3, 10*time.Second, WithName("fixed-boundaries"), WithClock(clock))
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"fills the window", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: 10 * time.Second},
+		}},
+		{"resets exactly at the window end", []step{
+			{hits: 3, allowed: true, remaining: 0},
+			{advance: 10*time.Second - time.Millisecond, allowed: false, retryAfter: time.Millisecond},
+			{advance: time.Millisecond, allowed: true, remaining: 2},
+		}},
+		{"does not slide", []step{
+			{allowed: true, remaining: 2},
+			{advance: 9 * time.Second, hits: 2, allowed: true, remaining: 0},
+			{advance: time.Second, hits: 3, allowed: true, remaining: 0},
+		}},
+		{"counts hits together", []step{
+			{hits: 2, allowed: true, remaining: 1},
+			{hits: 2, allowed: false, remaining: 1, retryAfter: 10 * time.Second},
+			{hits: 1, allowed: true, remaining: 0},
+		}},
+		{"rejects hits larger than the limit", []step{
+			{hits: 4, allowed: false, remaining: 3, retryAfter: 10 * time.Second},
+			{hits: 3, allowed: true, remaining: 0},
+			{hits: 4, allowed: false, remaining: 0, retryAfter: 20 * time.Second},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runSteps(t, newLimiter, tt.steps)
+		})
+	}
+}
+
+func TestSlidingWindowBoundaries(t *testing.T) {
+	newLimiter := func(clock Clock) *Limiter {
+		return NewSlidingWindow(3, 10*time.Second, WithName("sliding-boundaries"), WithClock(clock))
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"fills the window", []step{
+			{allowed: true, remaining: 2},
+			{advance: 4 * time.Second, allowed: true, remaining: 1},
+			{advance: 4 * time.Second, allowed: true, remaining: 0},
+			{advance: time.Second, allowed: false, remaining: 0, retryAfter: time.Second},
+		}},
+		{"frees a slot as each request leaves the window", []step{
+			{allowed: true, remaining: 2},
+			{advance: 4 * time.Second, hits: 2, allowed: true, remaining: 0},
+			{advance: 6*time.Second - time.Millisecond, allowed: false, retryAfter: time.Millisecond},
+			{advance: time.Millisecond, allowed: true, remaining: 0},
+			{advance: 2 * time.Second, allowed: false, retryAfter: 2 * time.Second},
+		}},
+		{"waits for enough requests to expire", []step{
+			{allowed: true, remaining: 2},
+			{advance: 2 * time.Second, allowed: true, remaining: 1},
+			{advance: 2 * time.Second, hits: 3, allowed: false, remaining: 1, retryAfter: 8 * time.Second},
+			{advance: 8 * time.Second, hits: 3, allowed: true, remaining: 0},
+		}},
+		{"rejects hits larger than the limit", []step{
+			{hits: 4, allowed: false, remaining: 3, retryAfter: 10 * time.Second},
+			{hits: 3, allowed: true, remaining: 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runSteps(t, newLimiter, tt.steps)
+		})
+	}
+}
+
+func TestTokenBucketBoundaries(t *testing.T) {
+	newLimiter := func(clock Clock) *Limiter {
+		return NewTokenBucket(1, 3, WithName("token-bucket-boundaries"), WithClock(clock))
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"drains the burst", []step{
+			{allowed: true, remaining: 2},
+			{allowed: true, remaining: 1},
+			{allowed: true, remaining: 0},
+			{allowed: false, remaining: 0, retryAfter: time.Second},
+		}},
+		{"refills one token per second", []step{
+			{hits: 3, allowed: true, remaining: 0},
+			{advance: 500 * time.Millisecond, allowed: false, retryAfter: 500 * time.Millisecond},
+			{advance: 500 * time.Millisecond, allowed: true, remaining: 0},
+			{advance: 2 * time.Second, hits: 2, allowed: true, remaining: 0},
+		}},
+		{"never holds more than the burst", []step{
+			{allowed: true, remaining: 2},
+			{advance: time.Hour, allowed: true, remaining: 2},
+		}},
+		{"rejects hits larger than the burst", []step{
+			{hits: 4, allowed: false, remaining: 3, retryAfter: time.Second},
+			{hits: 3, allowed: true, remaining: 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runSteps(t, newLimiter, tt.steps)
+		})
+	}
+}
+
+func TestWaitModeUsesClock(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	clock := ratelimittest.NewFakeClock(time.Now())
+
+	for _, limiter := range []*Limiter{
+		NewFixedWindow(1, 10*time.Second, WithName("wait-fixed"), WithMaxWait(time.Minute), WithClock(clock)),
+		NewSlidingWindow(1, 10*time.Second, WithName("wait-sliding"), WithMaxWait(time.Minute), WithClock(clock)),
+		NewTokenBucket(1, 1, WithName("wait-bucket"), WithMaxWait(time.Minute), WithClock(clock)),
+	} {
+		name := limiter.Policy().Name
+		limiter.Allow(context.Background(), "wait-test", name)
+
+		done := make(chan Decision)
+		go func() { done <- limiter.Allow(context.Background(), "wait-test", name) }()
+
+		// The second request sleeps on the clock until capacity is available
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Second)
+		if d := <-done; !d.Allowed {
+			t.Errorf("%s: waiting request was rejected", name)
+		}
+	}
+}
+
+func TestChargeOnOutcome(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		limiter    *Limiter
+		retryAfter time.Duration
+	}{
+		{NewFixedWindow(2, 10*time.Second, WithName("charge-fixed"), WithChargeOn(400), WithClock(clock)), 10 * time.Second},
+		{NewSlidingWindow(2, 10*time.Second, WithName("charge-sliding"), WithChargeOn(400), WithClock(clock)), 10 * time.Second},
+		{NewTokenBucket(1, 2, WithName("charge-bucket"), WithChargeOn(400), WithClock(clock)), time.Second},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		l := tt.limiter
+		name := l.Policy().Name
+
+		// Successful requests are never counted
+		for i := 0; i < 3; i++ {
+			if d := l.Allow(ctx, "charge-test", name); !d.Allowed || d.Remaining != 2 {
+				t.Errorf("%s: request %d got allowed %v, remaining %d; want true, 2", name, i, d.Allowed, d.Remaining)
+			}
+			l.Charge(ctx, "charge-test", name, 200)
+		}
+
+		// Failures accumulate until the next request is rejected
+		for i := 0; i < 2; i++ {
+			if d := l.Allow(ctx, "charge-test", name); !d.Allowed {
+				t.Errorf("%s: failure %d was rejected", name, i)
+			}
+			l.Charge(ctx, "charge-test", name, 400)
+		}
+		d := l.Allow(ctx, "charge-test", name)
+		if d.Allowed || d.RetryAfter != tt.retryAfter {
+			t.Errorf("%s: got allowed %v, retry after %v; want false, %v", name, d.Allowed, d.RetryAfter, tt.retryAfter)
+		}
+
+		clock.Advance(tt.retryAfter)
+		if d := l.Allow(ctx, "charge-test", name); !d.Allowed {
+			t.Errorf("%s: rejected after %v", name, tt.retryAfter)
+		}
+	}
+}
+
+func TestChargeOverLimit(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	opts := []Option{WithChargeOn(400), WithClock(clock), WithMaxWait(time.Minute)}
+
+	limiters := []*Limiter{
+		NewFixedWindow(2, 10*time.Second, append(opts, WithName("charge-over-fixed"))...),
+		NewSlidingWindow(2, 10*time.Second, append(opts, WithName("charge-over-sliding"))...),
+		NewTokenBucket(1, 2, append(opts, WithName("charge-over-bucket"))...),
+	}
+	for _, l := range limiters {
+		name := l.Policy().Name
+		// Three requests admitted at once all fail
+		for i := 0; i < 3; i++ {
+			l.Allow(ctx, "charge-test", name)
+		}
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 3; i++ {
+				l.Charge(ctx, "charge-test", name, 400)
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: charges waited for capacity", name)
+		}
+	}
+
+	mu.Lock()
+	fixed, _ := defaultState.fixedWindows.get(stateKey("charge-over-fixed", "charge-over-fixed"), clock.Now())
+	sliding, _ := defaultState.slidingWindows.get(stateKey("charge-over-sliding", "charge-over-sliding"), clock.Now())
+	bucket, _ := defaultState.tokenBuckets.get(stateKey("charge-over-bucket", "charge-over-bucket"), clock.Now())
+	mu.Unlock()
+
+	// Every failure is counted, even over the limit
+	if fixed == nil || fixed.count != 3 {
+		t.Errorf("fixed window: got %v want 3 requests counted", fixed)
+	}
+	if sliding == nil || len(sliding.requests) != 3 {
+		t.Errorf("sliding window: got %v want 3 requests counted", sliding)
+	}
+	if bucket == nil || bucket.limiter.TokensAt(clock.Now()) != -1 {
+		t.Errorf("token bucket: got %v want a debt of 1 token", bucket)
+	}
+}
+
+func TestJanitorUsesClock(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	clock := ratelimittest.NewFakeClock(time.Now())
+	limiter := NewFixedWindow(1, 10*time.Second, WithName("janitor-clock"), WithClock(clock))
+	limiter.Allow(context.Background(), "janitor-test", "janitor-key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunJanitor(ctx, clock)
+
+	// The janitor sweeps once per interval and waits on the clock again
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	clock.BlockUntil(1)
+
+	mu.Lock()
+	size := defaultState.fixedWindows.len()
+	mu.Unlock()
+	if size != 0 {
+		t.Errorf("got %d keys after the window expired, want 0", size)
+	}
+}
+
+func TestAdaptiveUsesClock(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	clock := ratelimittest.NewFakeClock(time.Now())
+	limiter := NewFixedWindow(10, time.Hour, WithName("adaptive-clock"), WithClock(clock), WithAdaptive(AdaptiveConfig{
+		LatencyTarget: 100 * time.Millisecond,
+		Interval:      time.Minute,
+		MinSamples:    1,
+	}))
+
+	// The limit only adjusts once the interval has elapsed on the clock
+	limiter.Allow(context.Background(), "adaptive-test", "adaptive-key").Done(time.Second, 200)
+	if d := limiter.Allow(context.Background(), "adaptive-test", "adaptive-key"); d.Limit != 10 {
+		t.Fatalf("got limit %d within the interval, want 10", d.Limit)
+	}
+	clock.Advance(time.Minute)
+	limiter.Allow(context.Background(), "adaptive-test", "adaptive-key").Done(time.Second, 200)
+	if d := limiter.Allow(context.Background(), "adaptive-test", "adaptive-key"); d.Limit != 5 {
+		t.Errorf("got limit %d after a slow interval, want 5", d.Limit)
+	}
+}
+
+func TestRoutesBounded(t *testing.T) {
+	routesMu.Lock()
+	saved, savedMax := trackedRoutes, maxTrackedRoutes
+	trackedRoutes, maxTrackedRoutes = make(map[string]struct{}), 2
+	routesMu.Unlock()
+	t.Cleanup(func() {
+		routesMu.Lock()
+		trackedRoutes, maxTrackedRoutes = saved, savedMax
+		routesMu.Unlock()
+	})
+
+	limiter := NewFixedWindow(100, time.Minute, WithName("routes-bounded"), WithAdaptive(AdaptiveConfig{Interval: time.Minute}))
+	for _, route := range []string{"/a", "/b", "/c", "/d", "/a"} {
+		limiter.Allow(context.Background(), route, "routes-key")
+	}
+
+	// Routes beyond the bound share one entry in the statistics and adaptive limits
+	routes := map[string]uint64{}
+	for _, r := range Stats(100).Routes {
+		if r.Policy == "routes-bounded" {
+			routes[r.Route] = r.Allowed
+		}
+	}
+	if len(routes) != 3 || routes["/a"] != 2 || routes["/b"] != 1 || routes[OtherRoute] != 2 {
+		t.Errorf("got routes %v, want /a, /b and %s", routes, OtherRoute)
+	}
+	adaptive := 0
+	for _, a := range AdaptiveLimits() {
+		if a.Policy == "routes-bounded" {
+			adaptive++
+		}
+	}
+	if adaptive != 3 {
+		t.Errorf("got %d adaptive limits want 3", adaptive)
+	}
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import "time"
+
+// Cache is a bounded map of per-client state for the packages built on
+// ratelimit, backed by the same LRU store as the limiters: once it holds
+// maxKeys it evicts the least recently used key, and entries expire at a
+// time set by their owner. Its size and evictions are exported under the
+// store metrics with its name. Like a map it must be guarded by its owner.
+type Cache[V any] struct {
+	s *store[V]
+}
+
+// NewCache returns an empty cache named name holding up to maxKeys keys
+// (default 100000).
+func NewCache[V any](name string, maxKeys int) *Cache[V] {
+	s := newStore[V](name)
+	s.configure(StoreConfig{MaxKeys: maxKeys})
+	return &Cache[V]{s: s}
+}
+
+// Get returns the value of key, marking it as used at now.
+func (c *Cache[V]) Get(key string, now time.Time) (V, bool) {
+	return c.s.get(key, now)
+}
+
+// Set stores value under key until expiresAt, evicting the least recently
+// used key to make room.
+func (c *Cache[V]) Set(key string, value V, now, expiresAt time.Time) {
+	c.s.delete(key)
+	c.s.add(key, value, now, expiresAt)
+}
+
+// Expire sets when key expires.
+func (c *Cache[V]) Expire(key string, expiresAt time.Time) {
+	c.s.expire(key, expiresAt)
+}
+
+// Delete forgets key and reports whether it was stored.
+func (c *Cache[V]) Delete(key string) bool {
+	return c.s.delete(key)
+}
+
+// Sweep forgets the keys expired at now.
+func (c *Cache[V]) Sweep(now time.Time) {
+	c.s.sweep(now)
+}
+
+// Len returns the number of keys stored.
+func (c *Cache[V]) Len() int {
+	return c.s.len()
+}
+
+// Each calls fn for every key from the least to the most recently used.
+func (c *Cache[V]) Each(fn func(key string, value V)) {
+	c.s.each(func(e *storeEntry[V]) { fn(e.key, e.value) })
+}
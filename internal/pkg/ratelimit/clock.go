@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time to limiters and the janitor, so tests can control it.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel receiving the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the wall clock used unless another is injected.
+var SystemClock Clock = systemClock{}
+
+// WithClock makes the limiter read the time from clock instead of the system clock.
+func WithClock(clock Clock) Option {
+	return func(p *Policy) {
+		p.clock = clock
+	}
+}
+
+// sleepContext pauses for d on clock and reports whether it completed
+// before ctx was done.
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-clock.After(d):
+		return true
+	}
+}
@@ -0,0 +1,337 @@
+// Package cluster limits every key on a single replica, its owner, chosen by
+// consistent hashing over a configured member list. Replicas forward the
+// checks of keys they do not own to the owner over HTTP, cache its denials
+// briefly, and limit locally while the owner cannot be reached.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Path is where a Cluster's Handler is expected to be mounted on every member.
+const Path = "/internal/cluster/check"
+
+// maxCachedDecisions bounds the denials cached for keys owned elsewhere.
+const maxCachedDecisions = 10000
+
+var forwardsTotal = metrics.NewCounterVec(
+	"cluster_forwards_total",
+	"Checks of keys owned by another replica by owner and outcome (ok, cached, error or fallback).",
+	"owner", "outcome",
+)
+
+// Config configures a Cluster. Zero values get the defaults noted below.
+type Config struct {
+	// Self is the base URL of this replica as listed in Members.
+	Self string
+	// Members are the base URLs of every replica, including this one.
+	Members []string
+	// Token authenticates forwarded checks. It must be the same on every
+	// replica; without it every check is refused.
+	Token string
+	// VirtualNodes is the number of ring points per member (default 128).
+	VirtualNodes int
+	// Timeout bounds a forwarded check on top of the policy's MaxWait
+	// (default 200ms).
+	Timeout time.Duration
+	// CacheTTL is the longest an owner's denial is reused without asking it
+	// again, never past the denial's RetryAfter (default 1s).
+	CacheTTL time.Duration
+	// FailureBackoff is how long an unreachable owner's keys are limited
+	// locally before forwarding to it again (default 1s).
+	FailureBackoff time.Duration
+	// Client sends forwarded checks (default http.DefaultClient).
+	Client *http.Client
+	// Clock tells the time for the cache and backoff (default the system clock).
+	Clock ratelimit.Clock
+}
+
+// CheckRequest is the body of a forwarded check.
+type CheckRequest struct {
+	Policy string              `json:"policy"`
+	Route  string              `json:"route"`
+	Key    string              `json:"key"`
+	Hits   int                 `json:"hits"`
+	Mode   ratelimit.CheckMode `json:"mode,omitempty"`
+}
+
+// CheckResponse is the owner's decision on a forwarded check, with
+// durations in nanoseconds.
+type CheckResponse struct {
+	Allowed    bool          `json:"allowed"`
+	Limit      int           `json:"limit"`
+	Remaining  int           `json:"remaining"`
+	Reset      time.Duration `json:"reset"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+type cacheKey struct {
+	policy string
+	key    string
+}
+
+// cachedDecision is an owner's denial of hits requests, decided at at.
+type cachedDecision struct {
+	decision ratelimit.Decision
+	hits     int
+	at       time.Time
+	until    time.Time
+}
+
+// Cluster implements ratelimit.Forwarder over a consistent hash ring.
+type Cluster struct {
+	cfg Config
+
+	mu       sync.Mutex
+	ring     *Ring
+	limiters map[string]*ratelimit.Limiter
+	cache    map[cacheKey]cachedDecision
+	// down holds until when unreachable owners are skipped
+	down map[string]time.Time
+}
+
+var _ ratelimit.Forwarder = (*Cluster)(nil)
+
+// New returns a Cluster for cfg.
+func New(cfg Config) *Cluster {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 200 * time.Millisecond
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = time.Second
+	}
+	if cfg.FailureBackoff <= 0 {
+		cfg.FailureBackoff = time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+
+	return &Cluster{
+		cfg:      cfg,
+		ring:     NewRing(cfg.Members, cfg.VirtualNodes),
+		limiters: make(map[string]*ratelimit.Limiter),
+		cache:    make(map[cacheKey]cachedDecision),
+		down:     make(map[string]time.Time),
+	}
+}
+
+// Self returns the base URL of this replica.
+func (c *Cluster) Self() string {
+	return c.cfg.Self
+}
+
+// Members returns the current members, sorted.
+func (c *Cluster) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ring.Members()
+}
+
+// SetMembers replaces the member list. Only the keys gained by new members
+// or owned by removed ones change owner.
+func (c *Cluster) SetMembers(members []string) {
+	ring := NewRing(members, c.cfg.VirtualNodes)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ring = ring
+	// Cached denials may come from a replica that no longer owns the key
+	c.cache = make(map[cacheKey]cachedDecision)
+	c.down = make(map[string]time.Time)
+}
+
+// Owner returns the member owning key.
+func (c *Cluster) Owner(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ring.Owner(key)
+}
+
+// Register makes l available to the checks forwarded by other members.
+func (c *Cluster) Register(l *ratelimit.Limiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limiters[l.Policy().Name] = l
+}
+
+// Forward asks the owner of key to decide the check, unless this replica
+// owns it, a cached denial still applies or the owner is unreachable.
+// Charges are always sent, they must be counted.
+func (c *Cluster) Forward(ctx context.Context, l *ratelimit.Limiter, route, key string, hits int, mode ratelimit.CheckMode) (ratelimit.Decision, bool) {
+	policy := l.Policy()
+	ck := cacheKey{policy.Name, key}
+	now := c.cfg.Clock.Now()
+
+	c.mu.Lock()
+	owner := c.ring.Owner(key)
+	if owner == "" || owner == c.cfg.Self {
+		c.mu.Unlock()
+		return ratelimit.Decision{}, false
+	}
+	if cached, ok := c.cache[ck]; ok && mode != ratelimit.CheckCharge && now.Before(cached.until) && hits >= cached.hits {
+		c.mu.Unlock()
+		forwardsTotal.Inc(owner, "cached")
+		elapsed := now.Sub(cached.at)
+		d := cached.decision
+		d.Reset = max(d.Reset-elapsed, 0)
+		d.RetryAfter -= elapsed
+		return d, true
+	}
+	if until, down := c.down[owner]; down && now.Before(until) {
+		c.mu.Unlock()
+		forwardsTotal.Inc(owner, "fallback")
+		return ratelimit.Decision{}, false
+	}
+	c.mu.Unlock()
+
+	maxWait := policy.MaxWait
+	if mode == ratelimit.CheckCharge {
+		// Charges never wait for capacity
+		maxWait = 0
+	}
+	d, err := c.check(ctx, owner, maxWait, CheckRequest{Policy: policy.Name, Route: route, Key: key, Hits: hits, Mode: mode})
+	if err != nil {
+		forwardsTotal.Inc(owner, "error")
+		// A client giving up says nothing about the owner
+		if ctx.Err() == nil {
+			c.mu.Lock()
+			c.down[owner] = now.Add(c.cfg.FailureBackoff)
+			c.mu.Unlock()
+		}
+		return ratelimit.Decision{}, false
+	}
+	forwardsTotal.Inc(owner, "ok")
+
+	if !d.Allowed && d.RetryAfter > 0 {
+		c.cacheDenial(ck, cachedDecision{decision: d, hits: hits, at: now, until: now.Add(min(d.RetryAfter, c.cfg.CacheTTL))})
+	}
+	return d, true
+}
+
+// cacheDenial remembers a denial, dropping expired ones when the cache is
+// full. Allowed decisions are never cached: the owner must count every hit.
+func (c *Cluster) cacheDenial(k cacheKey, cached cachedDecision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxCachedDecisions {
+		for ck, d := range c.cache {
+			if !cached.at.Before(d.until) {
+				delete(c.cache, ck)
+			}
+		}
+		if len(c.cache) >= maxCachedDecisions {
+			return
+		}
+	}
+	c.cache[k] = cached
+}
+
+func (c *Cluster) check(ctx context.Context, owner string, maxWait time.Duration, creq CheckRequest) (ratelimit.Decision, error) {
+	body, err := json.Marshal(creq)
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("error encoding check: %v", err)
+	}
+
+	// The owner may hold the check for up to the policy's MaxWait
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout+maxWait)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, owner+Path, bytes.NewReader(body))
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("error forwarding check to %s: %v", owner, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+
+	resp, err := c.cfg.Client.Do(req)
+	if err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("error forwarding check to %s: %v", owner, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ratelimit.Decision{}, fmt.Errorf("error forwarding check to %s: status %d", owner, resp.StatusCode)
+	}
+	var cresp CheckResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cresp); err != nil {
+		return ratelimit.Decision{}, fmt.Errorf("error reading check from %s: %v", owner, err)
+	}
+	return ratelimit.Decision{
+		Allowed:    cresp.Allowed,
+		Limit:      cresp.Limit,
+		Remaining:  cresp.Remaining,
+		Reset:      cresp.Reset,
+		RetryAfter: cresp.RetryAfter,
+	}, nil
+}
+
+// Handler decides the checks forwarded by other members with the local
+// limiter of the same policy.
+func (c *Cluster) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.cfg.Token)) != 1 {
+			http.Error(w, "invalid cluster token", http.StatusUnauthorized)
+			return
+		}
+
+		var creq CheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&creq); err != nil {
+			http.Error(w, fmt.Sprintf("invalid check: %v", err), http.StatusBadRequest)
+			return
+		}
+		if creq.Hits <= 0 {
+			http.Error(w, "invalid check: hits must be positive", http.StatusBadRequest)
+			return
+		}
+		switch creq.Mode {
+		case ratelimit.CheckAllow, ratelimit.CheckPeek, ratelimit.CheckCharge:
+		default:
+			http.Error(w, fmt.Sprintf("invalid check: unknown mode %q", creq.Mode), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		l, ok := c.limiters[creq.Policy]
+		c.mu.Unlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown policy %q", creq.Policy), http.StatusNotFound)
+			return
+		}
+
+		// Decide here even if the ring changed in the meantime, so a check
+		// is never forwarded twice
+		d := l.CheckLocal(r.Context(), creq.Route, creq.Key, creq.Hits, creq.Mode)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CheckResponse{
+			Allowed:    d.Allowed,
+			Limit:      d.Limit,
+			Remaining:  d.Remaining,
+			Reset:      d.Reset,
+			RetryAfter: d.RetryAfter,
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// member is one server of a test cluster, with its own limiter state.
+type member struct {
+	cluster *Cluster
+	server  *httptest.Server
+	// checks counts the checks forwarded to this member by the others
+	checks atomic.Int32
+}
+
+// startCluster runs n members in this process, each limiting /limited to
+// limit requests per minute, keyed on the X-Client header.
+func startCluster(t *testing.T, n, limit int, clock ratelimit.Clock) []*member {
+	t.Helper()
+
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		urls[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+
+	members := make([]*member, n)
+	for i, srv := range servers {
+		m := &member{server: srv}
+		m.cluster = New(Config{Self: urls[i], Members: urls, Token: "secret", Clock: clock})
+
+		limiter := ratelimit.NewFixedWindow(limit, time.Minute,
+			ratelimit.WithName(t.Name()),
+			ratelimit.WithState(ratelimit.NewState()),
+			ratelimit.WithForwarder(m.cluster),
+			ratelimit.WithKeyFunc(func(r *http.Request) string { return r.Header.Get("X-Client") }),
+			ratelimit.WithClock(clock),
+		)
+		mux := http.NewServeMux()
+		mux.Handle("/limited", limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		mux.Handle(Path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.checks.Add(1)
+			m.cluster.Handler().ServeHTTP(w, r)
+		}))
+
+		srv.Config.Handler = mux
+		srv.Start()
+		t.Cleanup(srv.Close)
+		members[i] = m
+	}
+	return members
+}
+
+func (m *member) get(t *testing.T, client string) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", m.server.URL+"/limited", nil)
+	req.Header.Set("X-Client", client)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /limited: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// split returns the member owning client and the others.
+func split(members []*member, client string) (*member, []*member) {
+	var owner *member
+	var others []*member
+	for _, m := range members {
+		if m.cluster.Owner(client) == m.cluster.Self() {
+			owner = m
+		} else {
+			others = append(others, m)
+		}
+	}
+	return owner, others
+}
+
+func TestClusterLimitsKeyOnOwner(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	members := startCluster(t, 3, 4, clock)
+	owner, others := split(members, "alice")
+
+	// Requests spread over every member share a single limit
+	for i := 0; i < 4; i++ {
+		if resp := members[i%3].get(t, "alice"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d want 200", i, resp.StatusCode)
+		}
+	}
+	for i, m := range members {
+		resp := m.get(t, "alice")
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+			t.Errorf("member %d: got %d with Retry-After %q, want 429 with 60",
+				i, resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+	}
+	// Only the owner decides
+	for i, m := range others {
+		if n := m.checks.Load(); n != 0 {
+			t.Errorf("non-owner %d received %d checks", i, n)
+		}
+	}
+
+	// Denials are cached, so the owner is not asked again until they expire
+	checks := owner.checks.Load()
+	others[0].get(t, "alice")
+	if owner.checks.Load() != checks {
+		t.Errorf("cached denial was forwarded again")
+	}
+	clock.Advance(time.Second)
+	if resp := others[0].get(t, "alice"); resp.StatusCode != http.StatusTooManyRequests || owner.checks.Load() != checks+1 {
+		t.Errorf("got %d with %d new checks, want 429 with 1", resp.StatusCode, owner.checks.Load()-checks)
+	}
+}
+
+func TestClusterFallsBackWhenOwnerDown(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	members := startCluster(t, 2, 2, clock)
+	owner, others := split(members, "bob")
+	owner.server.Close()
+	survivor := others[0]
+
+	fallbacks := forwardsTotal.Value(owner.server.URL, "fallback")
+	// The survivor limits the key locally instead of failing requests
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := survivor.get(t, "bob").StatusCode; got != want {
+			t.Errorf("request %d: got %d want %d", i, got, want)
+		}
+	}
+	// And skips the unreachable owner for the backoff
+	if got := forwardsTotal.Value(owner.server.URL, "fallback") - fallbacks; got != 2 {
+		t.Errorf("got %v fallbacks want 2", got)
+	}
+}
+
+func TestHandlerRejectsInvalidChecks(t *testing.T) {
+	c := New(Config{Self: "http://a", Members: []string{"http://a"}, Token: "secret"})
+	c.Register(ratelimit.NewFixedWindow(1, time.Minute, ratelimit.WithName(t.Name()), ratelimit.WithState(ratelimit.NewState())))
+
+	tests := []struct {
+		token  string
+		body   string
+		status int
+	}{
+		{"secret", "", http.StatusBadRequest},
+		{"secret", `{"policy":"missing","key":"k","hits":1}`, http.StatusNotFound},
+		{"secret", `{"policy":"` + t.Name() + `","key":"k","hits":0}`, http.StatusBadRequest},
+		{"secret", `{"policy":"` + t.Name() + `","key":"k","hits":-1}`, http.StatusBadRequest},
+		{"secret", `{"policy":"` + t.Name() + `","key":"k","hits":1,"mode":"free"}`, http.StatusBadRequest},
+		{"wrong", `{"policy":"` + t.Name() + `","key":"k","hits":1}`, http.StatusUnauthorized},
+		{"", `{"policy":"` + t.Name() + `","key":"k","hits":1}`, http.StatusUnauthorized},
+		{"secret", `{"policy":"` + t.Name() + `","key":"k","hits":1}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", Path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		c.Handler().ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("token %q, body %q: got %d want %d", tt.token, tt.body, w.Code, tt.status)
+		}
+	}
+
+	// Without a token of its own the handler refuses every check
+	open := New(Config{Self: "http://a", Members: []string{"http://a"}})
+	req := httptest.NewRequest("POST", Path, strings.NewReader(`{"policy":"missing","key":"k","hits":1}`))
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	open.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d want 401", w.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes is the number of points each member gets on the ring,
+// spreading its keys evenly over the other members when it leaves.
+const defaultVirtualNodes = 128
+
+// Ring assigns keys to members by consistent hashing: adding or removing a
+// member only moves the keys it gains or owned, about 1/n of them.
+type Ring struct {
+	members []string
+	// points are the sorted hashes of the members' virtual nodes
+	points []uint64
+	owners map[uint64]string
+}
+
+// NewRing places members on the ring with vnodes virtual nodes each
+// (default 128).
+func NewRing(members []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = defaultVirtualNodes
+	}
+
+	r := &Ring{
+		members: slices.Clone(members),
+		owners:  make(map[uint64]string, len(members)*vnodes),
+	}
+	sort.Strings(r.members)
+	r.members = slices.Compact(r.members)
+
+	for _, m := range r.members {
+		for i := 0; i < vnodes; i++ {
+			h := hash(m + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[h]; taken {
+				continue
+			}
+			r.owners[h] = m
+			r.points = append(r.points, h)
+		}
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// Owner returns the member owning key, or "" for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Members returns the members of the ring, sorted.
+func (r *Ring) Members() []string {
+	return slices.Clone(r.members)
+}
+
+// hash is FNV-1a followed by the SplitMix64 finalizer, so keys differing
+// only in their last bytes still land far apart on the ring.
+func hash(s string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(s))
+	h := f.Sum64()
+
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
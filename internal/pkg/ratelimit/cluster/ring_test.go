@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func owners(r *Ring, keys int) map[string]string {
+	out := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		out[key] = r.Owner(key)
+	}
+	return out
+}
+
+func TestRingBalance(t *testing.T) {
+	members := []string{"http://a", "http://b", "http://c", "http://d"}
+	counts := make(map[string]int)
+	for _, owner := range owners(NewRing(members, 0), 40000) {
+		counts[owner]++
+	}
+
+	for _, m := range members {
+		// Each member owns its quarter give or take a third
+		if counts[m] < 6700 || counts[m] > 13300 {
+			t.Errorf("%s owns %d of 40000 keys", m, counts[m])
+		}
+	}
+}
+
+func TestRingMovesMinimalKeys(t *testing.T) {
+	const keys = 20000
+	members := []string{"http://a", "http://b", "http://c"}
+	before := owners(NewRing(members, 0), keys)
+
+	t.Run("join", func(t *testing.T) {
+		after := owners(NewRing(append(members, "http://d"), 0), keys)
+		moved := 0
+		for key, owner := range after {
+			if owner == before[key] {
+				continue
+			}
+			moved++
+			if owner != "http://d" {
+				t.Fatalf("%s moved from %s to %s, not to the new member", key, before[key], owner)
+			}
+		}
+		// About a quarter of the keys move to the new member
+		if moved < keys/6 || moved > keys/3 {
+			t.Errorf("%d of %d keys moved", moved, keys)
+		}
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		after := owners(NewRing(members[:2], 0), keys)
+		for key, owner := range after {
+			if before[key] != "http://c" && owner != before[key] {
+				t.Fatalf("%s moved from %s to %s though its owner stayed", key, before[key], owner)
+			}
+		}
+	})
+}
+
+func TestRingEmpty(t *testing.T) {
+	if owner := NewRing(nil, 0).Owner("key"); owner != "" {
+		t.Errorf("got owner %q want none", owner)
+	}
+}
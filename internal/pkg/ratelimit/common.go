@@ -0,0 +1,111 @@
+// Package ratelimit implements the rate limiting algorithms independently of
+// any HTTP framework, together with a standard net/http middleware adapter.
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	mu sync.Mutex
+	// defaultState is shared by the limiters not given their own State
+	defaultState = NewState()
+)
+
+// KeyFunc extracts the rate limit key identifying a client from a request.
+type KeyFunc func(r *http.Request) string
+
+// trustedProxies holds the networks whose X-Forwarded-For header is believed.
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// SetTrustedProxies sets the proxies, as IP addresses or CIDR ranges, whose
+// X-Forwarded-For header identifies the client. By default no proxy is
+// trusted and clients are identified by their remote address.
+func SetTrustedProxies(proxies []string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	trustedProxies.Store(&prefixes)
+	return nil
+}
+
+// trusted reports whether ip is the address of a trusted proxy.
+func trusted(ip string) bool {
+	proxies := trustedProxies.Load()
+	if proxies == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range *proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client of r, see RemoteIP.
+func ClientIP(r *http.Request) string {
+	return RemoteIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"))
+}
+
+// RemoteIP returns the IP address of the client connected from remoteAddr,
+// excluding the port number. When remoteAddr is a trusted proxy, the client
+// is the last address of the X-Forwarded-For values forwardedFor that is not
+// a trusted proxy itself. The net/http, gin and gRPC adapters all identify
+// clients with it.
+func RemoteIP(remoteAddr string, forwardedFor []string) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// If SplitHostPort fails, use remoteAddr as-is (fallback)
+		ip = remoteAddr
+	}
+	if !trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// A malformed hop cannot be told apart from a forged one
+			return ip
+		}
+		ip = hop
+		if !trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// HeaderKey returns a KeyFunc keying clients on the given request header,
+// falling back to the client IP when the header is missing.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if key := r.Header.Get(name); key != "" {
+			return key
+		}
+		return ClientIP(r)
+	}
+}
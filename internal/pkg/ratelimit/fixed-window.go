@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type FixedWindow struct {
+	count int
+	reset time.Time
+}
+
+func CheckFixedWindowLimit(ip string, limit int, window time.Duration) bool {
+	now := time.Now()
+	return defaultState.checkFixedWindow(now, "", ip, overrideLimit(ip, limit, now), window, 1, CheckAllow).allowed
+}
+
+// checkFixedWindow counts hits requests at now against the client's current
+// window under policy, as mode says. limit already holds the client's override.
+func (st *State) checkFixedWindow(now time.Time, policy, ip string, limit int, window time.Duration, hits int, mode CheckMode) result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := stateKey(policy, ip)
+	client, exists := st.fixedWindows.get(key, now)
+
+	if !exists || !now.Before(client.reset) {
+		if hits > limit && mode != CheckCharge {
+			return result{remaining: max(limit, 0), reset: window, retryAfter: window}
+		}
+		if mode == CheckPeek {
+			return result{allowed: true, remaining: limit, reset: window}
+		}
+		// The state expires with the window
+		reset := now.Add(window)
+		if exists {
+			client.count, client.reset = hits, reset
+			st.fixedWindows.expire(key, reset)
+		} else if tracked, allowed := st.fixedWindows.add(key, &FixedWindow{count: hits, reset: reset}, now, reset); !tracked {
+			return untrackedResult(allowed, limit-hits, window)
+		}
+		return result{allowed: true, remaining: max(limit-hits, 0), reset: window}
+	}
+
+	reset := client.reset.Sub(now)
+
+	if client.count+hits > limit && mode != CheckCharge {
+		retryAfter := reset
+		if hits > limit {
+			// Never fits in a window, don't promise the next one will do
+			retryAfter = reset + window
+		}
+		return result{remaining: max(limit-client.count, 0), reset: reset, retryAfter: retryAfter}
+	}
+	if mode == CheckPeek {
+		return result{allowed: true, remaining: limit - client.count, reset: reset}
+	}
+
+	client.count += hits
+	return result{allowed: true, remaining: max(limit-client.count, 0), reset: reset}
+}
+
+// NewFixedWindow creates a limiter implementing a fixed window rate limiting algorithm.
+func NewFixedWindow(limit int, window time.Duration, opts ...Option) *Limiter {
+	policy := newPolicy(Policy{Algorithm: AlgorithmFixedWindow, Limit: limit, Window: window}, opts)
+
+	check := func(ctx context.Context, now time.Time, key string, limit, hits int, mode CheckMode) result {
+		if policy.leases != nil {
+			return checkLeased(ctx, policy, now, key, limit, window, hits, mode)
+		}
+		return policy.state.checkFixedWindow(now, policy.Name, key, limit, window, hits, mode)
+	}
+	return newLimiter(policy, func(ctx context.Context, key string, limit, hits int, mode CheckMode) result {
+		if mode == CheckCharge {
+			return check(ctx, policy.clock.Now(), key, limit, hits, mode)
+		}
+		return waitForCapacity(ctx, policy.clock, policy.MaxWait, func(now time.Time) result {
+			return check(ctx, now, key, limit, hits, mode)
+		})
+	})
+}
+
+// checkLeased counts hits requests at now in the backend shared by every
+// replica as mode says, keeping the policies apart since they share the
+// backend. While the backend fails the policy's failure mode decides, unless
+// the caller has gone away.
+func checkLeased(ctx context.Context, policy Policy, now time.Time, key string, limit int, window time.Duration, hits int, mode CheckMode) result {
+	res, err := policy.leases.check(ctx, now, stateKey(policy.Name, key), limit, window, hits, mode)
+	if err == nil {
+		return res
+	}
+	if ctx.Err() != nil {
+		// The caller went away, there is no request left to decide or count
+		return result{}
+	}
+
+	leaseFallbacks.Inc(policy.Name, string(policy.FailureMode))
+	switch policy.FailureMode {
+	case FailClosed:
+		retryAfter := policy.leases.cfg.FailureBackoff
+		return result{reset: retryAfter, retryAfter: retryAfter}
+	case FailLocal:
+		return policy.state.checkFixedWindow(now, policy.Name, key, limit, window, hits, mode)
+	default:
+		return result{allowed: true, remaining: max(limit-hits, 0), reset: window}
+	}
+}
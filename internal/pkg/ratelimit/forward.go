@@ -0,0 +1,22 @@
+package ratelimit
+
+import "context"
+
+// Forwarder sends the checks of keys owned by another replica to that
+// replica, so each key is limited in a single place.
+type Forwarder interface {
+	// Register makes l available to the checks other replicas forward here.
+	Register(l *Limiter)
+	// Forward decides the check on the replica owning key, counting the hits
+	// as mode says. It reports false when this replica owns key or the owner
+	// cannot be reached, in which case the limiter decides locally.
+	Forward(ctx context.Context, l *Limiter, route, key string, hits int, mode CheckMode) (Decision, bool)
+}
+
+// WithForwarder limits every key on the replica owning it, as chosen by f,
+// instead of on the replica receiving the request.
+func WithForwarder(f Forwarder) Option {
+	return func(p *Policy) {
+		p.Forwarder = f
+	}
+}
@@ -0,0 +1,136 @@
+// Package grpclimit enforces ratelimit limiters on gRPC servers through
+// unary and stream server interceptors.
+package grpclimit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// KeyFunc extracts the rate limit key from an incoming RPC.
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// PeerKey keys clients on the IP address of the connected peer, or the one
+// its x-forwarded-for metadata names when the peer is a trusted proxy, as
+// ratelimit.RemoteIP resolves it.
+func PeerKey(ctx context.Context, fullMethod string) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return ratelimit.RemoteIP(p.Addr.String(), md.Get("x-forwarded-for"))
+}
+
+// MetadataKey keys clients on the first value of the given metadata entry,
+// e.g. "x-api-key", falling back to the peer address when it is missing.
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(name); len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+		return PeerKey(ctx, fullMethod)
+	}
+}
+
+// MethodKey keys on the full method name, limiting each method as a whole.
+func MethodKey(ctx context.Context, fullMethod string) string {
+	return fullMethod
+}
+
+// UnaryServerInterceptor enforces l on every unary RPC, keyed by key.
+func UnaryServerInterceptor(l *ratelimit.Limiter, key KeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		k := key(ctx, info.FullMethod)
+		d := l.Allow(ctx, info.FullMethod, k)
+		if !d.Allowed {
+			return nil, rejection(l, d)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		d.Done(time.Since(start), httpStatus(err))
+		l.Charge(ctx, info.FullMethod, k, httpStatus(err))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor enforces l when a stream is opened, keyed by key.
+// Messages on an admitted stream are not limited individually.
+func StreamServerInterceptor(l *ratelimit.Limiter, key KeyFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		k := key(ctx, info.FullMethod)
+		d := l.Allow(ctx, info.FullMethod, k)
+		if !d.Allowed {
+			return rejection(l, d)
+		}
+
+		start := time.Now()
+		err := handler(srv, ss)
+		d.Done(time.Since(start), httpStatus(err))
+		l.Charge(ctx, info.FullMethod, k, httpStatus(err))
+		return err
+	}
+}
+
+// rejection maps a denied decision to ResourceExhausted, carrying the retry
+// delay as a RetryInfo status detail.
+func rejection(l *ratelimit.Limiter, d ratelimit.Decision) error {
+	r := l.Rejection(d)
+	st := status.New(codes.ResourceExhausted, r.Message)
+	if d.RetryAfter > 0 {
+		withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(d.RetryAfter)})
+		if err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// httpStatus translates an RPC result into the HTTP status it stands for,
+// as grpc-gateway maps them, so adaptive policies detect failing backends
+// and policies charge outcomes as they do over HTTP.
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// Client Closed Request, as nginx and grpc-gateway report it
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		// Unknown, Internal and DataLoss
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,146 @@
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// startServer runs a health service behind the interceptors on an
+// in-process bufconn listener and returns a client connected to it.
+func startServer(t *testing.T, unary, stream *ratelimit.Limiter, key KeyFunc) healthpb.HealthClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(unary, key)),
+		grpc.StreamInterceptor(StreamServerInterceptor(stream, key)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	limiter := ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithName("grpc-unary-test"))
+	client := startServer(t, limiter, limiter, MetadataKey("x-api-key"))
+
+	check := func(key string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := check("key-a"); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i+1, err)
+		}
+	}
+
+	err := check("key-a")
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	var retry *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = info
+		}
+	}
+	if retry == nil {
+		t.Fatalf("expected RetryInfo in status details, got %v", st.Details())
+	}
+	if delay := retry.GetRetryDelay().AsDuration(); delay <= 0 || delay > time.Minute {
+		t.Errorf("unexpected retry delay %v", delay)
+	}
+
+	// Keys are isolated from each other
+	if err := check("key-b"); err != nil {
+		t.Errorf("other key: unexpected error: %v", err)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	unary := ratelimit.NewFixedWindow(100, time.Minute, ratelimit.WithName("grpc-stream-test-unary"))
+	stream := ratelimit.NewFixedWindow(1, time.Minute, ratelimit.WithName("grpc-stream-test"))
+	client := startServer(t, unary, stream, MethodKey)
+
+	watch := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = s.Recv()
+		return err
+	}
+
+	if err := watch(); err != nil {
+		t.Fatalf("first stream: unexpected error: %v", err)
+	}
+	if err := watch(); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream: expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{status.Error(codes.Canceled, ""), 499},
+		{status.Error(codes.Unknown, ""), http.StatusInternalServerError},
+		{errors.New("plain error"), http.StatusInternalServerError},
+		{status.Error(codes.InvalidArgument, ""), http.StatusBadRequest},
+		{status.Error(codes.DeadlineExceeded, ""), http.StatusGatewayTimeout},
+		{status.Error(codes.NotFound, ""), http.StatusNotFound},
+		{status.Error(codes.AlreadyExists, ""), http.StatusConflict},
+		{status.Error(codes.PermissionDenied, ""), http.StatusForbidden},
+		{status.Error(codes.ResourceExhausted, ""), http.StatusTooManyRequests},
+		{status.Error(codes.FailedPrecondition, ""), http.StatusBadRequest},
+		{status.Error(codes.Aborted, ""), http.StatusConflict},
+		{status.Error(codes.OutOfRange, ""), http.StatusBadRequest},
+		{status.Error(codes.Unimplemented, ""), http.StatusNotImplemented},
+		{status.Error(codes.Internal, ""), http.StatusInternalServerError},
+		{status.Error(codes.Unavailable, ""), http.StatusServiceUnavailable},
+		{status.Error(codes.DataLoss, ""), http.StatusInternalServerError},
+		{status.Error(codes.Unauthenticated, ""), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := httpStatus(tt.err); got != tt.want {
+			t.Errorf("%v: got %d want %d", status.Code(tt.err), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware enforces the limiter on a net/http handler. Its method value
+// has the standard func(http.Handler) http.Handler middleware signature.
+// Wrap the handlers registered on a ServeMux rather than the mux itself:
+// requests are recorded under the pattern that matched them, which is only
+// known once the mux routed the request, and under UnmatchedRoute otherwise.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := l.Key(r, func() string { return ClientIP(r) })
+
+		route := routePattern(r)
+		d := l.Allow(r.Context(), route, key)
+		if !d.Allowed {
+			l.Reject(w, r, d)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		d.Done(time.Since(start), sw.status)
+		l.Charge(r.Context(), route, key, sw.status)
+	})
+}
+
+// FixedWindowMiddleware returns a net/http middleware implementing a fixed window rate limit.
+func FixedWindowMiddleware(limit int, window time.Duration, opts ...Option) func(http.Handler) http.Handler {
+	return NewFixedWindow(limit, window, opts...).Middleware
+}
+
+// SlidingWindowMiddleware returns a net/http middleware implementing a sliding window rate limit.
+func SlidingWindowMiddleware(limit int, window time.Duration, opts ...Option) func(http.Handler) http.Handler {
+	return NewSlidingWindow(limit, window, opts...).Middleware
+}
+
+// TokenBucketMiddleware returns a net/http middleware implementing a token bucket rate limit.
+func TokenBucketMiddleware(rateLimit, burst int, opts ...Option) func(http.Handler) http.Handler {
+	return NewTokenBucket(rateLimit, burst, opts...).Middleware
+}
+
+// UnmatchedRoute is the route of requests no ServeMux pattern matched yet.
+const UnmatchedRoute = "unmatched"
+
+// routePattern returns the ServeMux pattern that matched the request, so path
+// parameters do not create a route per value. The raw path is never used, so
+// clients cannot create routes, with their statistics and metric labels.
+func routePattern(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	return UnmatchedRoute
+}
+
+// statusWriter records the status code written by the wrapped handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	limited := FixedWindowMiddleware(2, time.Minute,
+		WithName("http-test"),
+		WithKeyFunc(HeaderKey("X-API-Key")),
+	)
+	mux.Handle("GET /items/{id}", limited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// Different path parameters share the client's quota
+	for _, path := range []string{"/items/1", "/items/2"} {
+		if w := get(path, "key-a"); w.Code != http.StatusNoContent {
+			t.Fatalf("GET %s: got %d want %d", path, w.Code, http.StatusNoContent)
+		}
+	}
+
+	w := get("/items/3", "key-a")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("over limit: got %d want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected Retry-After header")
+	}
+	expected := `{"error":"Too many requests, please try again later.","message":"You have exceeded the rate limit. Please wait before making more requests."}`
+	if w.Body.String() != expected {
+		t.Errorf("unexpected body: got %s want %s", w.Body.String(), expected)
+	}
+
+	// Another API key has its own quota
+	if w := get("/items/1", "key-b"); w.Code != http.StatusNoContent {
+		t.Errorf("other key: got %d want %d", w.Code, http.StatusNoContent)
+	}
+
+	stats := Stats(10)
+	found := false
+	for _, r := range stats.Routes {
+		if r.Route == "GET /items/{id}" && r.Allowed == 3 && r.Denied == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected decisions recorded under the route pattern, got %+v", stats.Routes)
+	}
+}
+
+func TestMiddlewareWrappingMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := NewFixedWindow(100, time.Minute, WithName("http-mux-test")).Middleware(mux)
+
+	// The mux has not matched the request yet, so every URL shares one route
+	for _, path := range []string{"/users/1", "/users/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	routes := 0
+	for _, r := range Stats(100).Routes {
+		if r.Policy != "http-mux-test" {
+			continue
+		}
+		routes++
+		if r.Route != UnmatchedRoute || r.Allowed != 3 {
+			t.Errorf("got route %q with %d allowed, want %q with 3", r.Route, r.Allowed, UnmatchedRoute)
+		}
+	}
+	if routes != 1 {
+		t.Errorf("got %d routes want 1", routes)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer forging the header", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.1, 192.0.2.1", "10.9.9.9"}, "198.51.100.1"},
+		{"client forging hops before the proxy", "10.1.2.3:1234", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"malformed hop", "10.1.2.3:1234", []string{"not-an-ip"}, "10.1.2.3"},
+		{"trusted proxy without the header", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"address without port", "203.0.113.5", nil, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RemoteIP(tt.remoteAddr, tt.forwardedFor); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("invalid proxy: expected an error")
+	}
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"sort"
+	"time"
+)
+
+// Override replaces the policy limit for a single client key.
+// For window algorithms Limit is the number of requests per window,
+// for the token bucket it is the bucket size. A limit of 0 blocks the key.
+type Override struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+var overrides = make(map[string]Override)
+
+// effectiveLimit returns the override limit for key if one is active at now,
+// otherwise the policy limit. The caller must hold mu.
+func effectiveLimit(key string, limit int, now time.Time) int {
+	o, exists := overrides[key]
+	if !exists {
+		return limit
+	}
+	if !o.ExpiresAt.IsZero() && now.After(o.ExpiresAt) {
+		delete(overrides, key)
+		return limit
+	}
+	return o.Limit
+}
+
+// overrideLimit is effectiveLimit for callers not holding mu.
+func overrideLimit(key string, limit int, now time.Time) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return effectiveLimit(key, limit, now)
+}
+
+// ResetKey clears the rate limit state tracked for key under every policy,
+// giving the client a fresh quota. It reports whether any state existed.
+func ResetKey(key string) bool {
+	// The package level check functions keep their state under no policy
+	names := []string{""}
+	for _, p := range Policies() {
+		names = append(names, p.Name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	existed := false
+	for _, name := range names {
+		sk := stateKey(name, key)
+		fixed := defaultState.fixedWindows.delete(sk)
+		sliding := defaultState.slidingWindows.delete(sk)
+		bucket := defaultState.tokenBuckets.delete(sk)
+		existed = existed || fixed || sliding || bucket
+	}
+	return existed
+}
+
+// SetOverride replaces the limit applied to key. A zero ttl keeps the
+// override until it is removed.
+func SetOverride(key string, limit int, ttl time.Duration) Override {
+	o := Override{Key: key, Limit: limit}
+	if ttl > 0 {
+		o.ExpiresAt = SystemClock.Now().Add(ttl)
+	}
+
+	mu.Lock()
+	overrides[key] = o
+	mu.Unlock()
+	return o
+}
+
+// RemoveOverride restores the policy limit for key.
+// It reports whether an override was removed.
+func RemoveOverride(key string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, exists := overrides[key]
+	delete(overrides, key)
+	return exists
+}
+
+// Overrides returns the active overrides sorted by key.
+func Overrides() []Override {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := SystemClock.Now()
+	list := make([]Override, 0, len(overrides))
+	for key, o := range overrides {
+		if !o.ExpiresAt.IsZero() && now.After(o.ExpiresAt) {
+			delete(overrides, key)
+			continue
+		}
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
@@ -0,0 +1,397 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+// Backend is a store shared by replicas that counts the hits of every key
+// in fixed windows aligned on the backend's clock.
+type Backend interface {
+	// Acquire takes up to n of the tokens left for key in its current
+	// window, allowing limit hits per window.
+	Acquire(ctx context.Context, key string, n, limit int, window time.Duration) (Grant, error)
+	// Release returns n unused tokens to key's window ending at reset.
+	Release(ctx context.Context, key string, n int, reset time.Time) error
+}
+
+// Grant is the outcome of Backend.Acquire.
+type Grant struct {
+	// Granted is the number of tokens taken, at most the number asked for.
+	Granted int
+	// Remaining is the number of tokens left in the window after the grant.
+	Remaining int
+	// Reset is the end of the window.
+	Reset time.Time
+}
+
+// LeaseConfig configures Leases. Zero values get the defaults noted below.
+type LeaseConfig struct {
+	// Fraction of the limit leased from the backend at once (default 0.1).
+	// Larger leases save backend calls but let a replica hold on to tokens
+	// other replicas could use.
+	Fraction float64
+	// TTL is how long leased tokens are used before the unused ones are
+	// returned to the backend (default 1s). Leases never outlive their window.
+	TTL time.Duration
+	// Timeout bounds every call to the backend (default 100ms).
+	Timeout time.Duration
+	// FailureBackoff is how long the backend is left alone after a failed
+	// call, so an outage does not cost every request a timeout (default 1s).
+	FailureBackoff time.Duration
+	// OnBackendChange, when set, is called with the error when the backend
+	// starts failing and with nil when it answers again.
+	OnBackendChange func(err error)
+}
+
+// FailureMode selects what a policy decides while its backend is failing.
+type FailureMode string
+
+const (
+	// FailOpen admits every request, so an outage of the shared store does
+	// not take the API down with it.
+	FailOpen FailureMode = "fail-open"
+	// FailClosed rejects every request, for limits protecting something
+	// that must never be overused.
+	FailClosed FailureMode = "fail-closed"
+	// FailLocal limits each replica on its own, in memory, until the
+	// backend is back.
+	FailLocal FailureMode = "local"
+)
+
+var (
+	leaseDecisions = metrics.NewCounterVec(
+		"ratelimit_lease_decisions_total",
+		"Leased rate limit decisions by where they were taken (local or backend).",
+		"source",
+	)
+	leaseBackendCalls = metrics.NewCounterVec(
+		"ratelimit_lease_backend_calls_total",
+		"Calls to the shared rate limit backend by operation and outcome.",
+		"op", "outcome",
+	)
+	leaseFallbacks = metrics.NewCounterVec(
+		"ratelimit_backend_fallbacks_total",
+		"Rate limit decisions taken by failure mode while the shared backend was failing.",
+		"policy", "mode",
+	)
+)
+
+// Leases serves fixed window decisions from local memory, leasing tokens in
+// batches from a shared Backend. Since the backend never grants more than
+// the limit and leases are not used past their window, replicas only
+// over-admit by what clock skew lets them use of a lease after the window
+// ended. Unused tokens are returned when a lease expires.
+type Leases struct {
+	backend Backend
+	cfg     LeaseConfig
+
+	mu     sync.Mutex
+	leases map[string]*lease
+
+	failMu sync.Mutex
+	// failed is the last backend error, nil while the backend answers
+	failed error
+	// retryAt is when the backend is called again after failing
+	retryAt time.Time
+}
+
+// lease holds the tokens leased for one key.
+type lease struct {
+	mu     sync.Mutex
+	tokens int
+	// remaining is the number of tokens the backend had left after the
+	// last grant, zero once the window is exhausted
+	remaining int
+	reset     time.Time
+	expires   time.Time
+	// dead is set once the janitor removed the lease from the map
+	dead bool
+}
+
+// NewLeases returns Leases taking tokens from backend.
+func NewLeases(backend Backend, cfg LeaseConfig) *Leases {
+	if cfg.Fraction <= 0 || cfg.Fraction > 1 {
+		cfg.Fraction = 0.1
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 100 * time.Millisecond
+	}
+	if cfg.FailureBackoff <= 0 {
+		cfg.FailureBackoff = time.Second
+	}
+
+	return &Leases{
+		backend: backend,
+		cfg:     cfg,
+		leases:  make(map[string]*lease),
+	}
+}
+
+// WithLeases makes a fixed window policy count hits in a backend shared by
+// every replica, through leases. Other algorithms keep their local state.
+func WithLeases(leases *Leases) Option {
+	return func(p *Policy) {
+		p.leases = leases
+	}
+}
+
+// WithFailureMode sets what the policy decides while its backend is
+// failing (default fail-open).
+func WithFailureMode(mode FailureMode) Option {
+	return func(p *Policy) {
+		p.FailureMode = mode
+	}
+}
+
+// unavailable returns the last backend error while calls are backing off
+// at now, nil when the backend can be called.
+func (ls *Leases) unavailable(now time.Time) error {
+	ls.failMu.Lock()
+	defer ls.failMu.Unlock()
+
+	if ls.failed != nil && now.Before(ls.retryAt) {
+		return ls.failed
+	}
+	return nil
+}
+
+// observe records the outcome of a backend call made at now, notifying
+// OnBackendChange when the backend starts or stops failing.
+func (ls *Leases) observe(now time.Time, err error) {
+	ls.failMu.Lock()
+	changed := (err == nil) != (ls.failed == nil)
+	ls.failed = err
+	if err != nil {
+		ls.retryAt = now.Add(ls.cfg.FailureBackoff)
+	}
+	ls.failMu.Unlock()
+
+	if changed && ls.cfg.OnBackendChange != nil {
+		ls.cfg.OnBackendChange(err)
+	}
+}
+
+// outcome is the label of a backend call's outcome in the metrics.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// get returns the live lease of key, locked.
+func (ls *Leases) get(key string) *lease {
+	for {
+		ls.mu.Lock()
+		l, ok := ls.leases[key]
+		if !ok {
+			l = &lease{}
+			ls.leases[key] = l
+		}
+		ls.mu.Unlock()
+
+		l.mu.Lock()
+		if !l.dead {
+			return l
+		}
+		l.mu.Unlock()
+	}
+}
+
+// batch is the number of tokens leased at once under limit.
+func (ls *Leases) batch(limit int) int {
+	return max(int(math.Ceil(float64(limit)*ls.cfg.Fraction)), 1)
+}
+
+// check takes hits tokens for key at now, from its lease when it holds
+// enough and from the backend otherwise. It returns an error, leaving the
+// decision to the policy's failure mode, when the backend could not be asked.
+// Peeks check whether a token is left without asking the backend, so they
+// are only denied once the backend is known to have none. Charges take what
+// the backend has left, so it stays exhausted until the window ends.
+func (ls *Leases) check(ctx context.Context, now time.Time, key string, limit int, window time.Duration, hits int, mode CheckMode) (result, error) {
+	l := ls.get(key)
+	defer l.mu.Unlock()
+
+	if !l.reset.IsZero() && !now.Before(l.reset) {
+		// The window ended, and its tokens with it
+		l.tokens, l.remaining, l.reset, l.expires = 0, 0, time.Time{}, time.Time{}
+	}
+	if l.tokens > 0 && !now.Before(l.expires) {
+		ls.release(ctx, key, l)
+	}
+
+	if l.tokens >= hits {
+		if mode != CheckPeek {
+			l.tokens -= hits
+		}
+		leaseDecisions.Inc("local")
+		return result{allowed: true, remaining: l.tokens + l.remaining, reset: l.reset.Sub(now)}, nil
+	}
+	if hits > limit && mode != CheckCharge {
+		return result{remaining: max(l.tokens+l.remaining, 0), reset: window, retryAfter: window}, nil
+	}
+	if l.remaining == 0 && now.Before(l.expires) {
+		// The backend had nothing left, don't ask again before the lease expires
+		leaseDecisions.Inc("local")
+		if mode == CheckCharge {
+			l.tokens = 0
+			return result{allowed: true, reset: l.reset.Sub(now)}, nil
+		}
+		return result{remaining: l.tokens, reset: l.reset.Sub(now), retryAfter: max(l.reset.Sub(now), time.Millisecond)}, nil
+	}
+	if mode == CheckPeek {
+		leaseDecisions.Inc("local")
+		return result{allowed: true, remaining: l.tokens + l.remaining, reset: max(l.reset.Sub(now), 0)}, nil
+	}
+	if err := ls.unavailable(now); err != nil {
+		return result{}, err
+	}
+
+	leaseDecisions.Inc("backend")
+	callCtx, cancel := context.WithTimeout(ctx, ls.cfg.Timeout)
+	g, err := ls.backend.Acquire(callCtx, key, max(ls.batch(limit), hits-l.tokens), limit, window)
+	cancel()
+	leaseBackendCalls.Inc("acquire", outcome(err))
+	if err != nil && ctx.Err() != nil {
+		// The client went away, which says nothing about the backend
+		return result{}, err
+	}
+	ls.observe(now, err)
+	if err != nil {
+		return result{}, err
+	}
+
+	l.tokens += g.Granted
+	l.remaining = g.Remaining
+	l.reset = g.Reset
+	l.expires = now.Add(ls.cfg.TTL)
+	if g.Reset.Before(l.expires) {
+		l.expires = g.Reset
+	}
+
+	reset := max(l.reset.Sub(now), 0)
+	if l.tokens < hits && mode == CheckCharge {
+		// Nothing more to take, the exhausted lease denies the next requests
+		l.tokens = 0
+		return result{allowed: true, remaining: l.remaining, reset: reset}, nil
+	}
+	if l.tokens < hits {
+		return result{remaining: l.tokens + l.remaining, reset: reset, retryAfter: max(reset, time.Millisecond)}, nil
+	}
+	l.tokens -= hits
+	return result{allowed: true, remaining: l.tokens + l.remaining, reset: reset}, nil
+}
+
+// release returns the unused tokens of l to the backend. The caller must
+// hold l.mu.
+func (ls *Leases) release(ctx context.Context, key string, l *lease) {
+	callCtx, cancel := context.WithTimeout(ctx, ls.cfg.Timeout)
+	err := ls.backend.Release(callCtx, key, l.tokens, l.reset)
+	cancel()
+	// Tokens that could not be returned stay taken until the window ends
+	leaseBackendCalls.Inc("release", outcome(err))
+	l.tokens = 0
+	l.expires = time.Time{}
+}
+
+// RunJanitor returns the unused tokens of expired leases and forgets them
+// until ctx is done, reading the time from clock.
+func (ls *Leases) RunJanitor(ctx context.Context, clock Clock) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-clock.After(ls.cfg.TTL):
+			ls.sweep(ctx, now)
+		}
+	}
+}
+
+// sweep returns and forgets the leases expired at now.
+func (ls *Leases) sweep(ctx context.Context, now time.Time) {
+	expired := make(map[string]*lease)
+
+	ls.mu.Lock()
+	for key, l := range ls.leases {
+		if !l.mu.TryLock() {
+			// In use, so not idle
+			continue
+		}
+		if now.Before(l.expires) {
+			l.mu.Unlock()
+			continue
+		}
+		l.dead = true
+		delete(ls.leases, key)
+		expired[key] = l
+	}
+	ls.mu.Unlock()
+
+	for key, l := range expired {
+		if l.tokens > 0 && now.Before(l.reset) {
+			ls.release(ctx, key, l)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// MemoryBackend is a Backend keeping the counts in this process, for tests
+// and benchmarks of code using Leases.
+type MemoryBackend struct {
+	clock Clock
+
+	mu     sync.Mutex
+	counts map[string]*memoryCount
+}
+
+type memoryCount struct {
+	count int
+	reset time.Time
+}
+
+// NewMemoryBackend returns an empty MemoryBackend reading the time from clock.
+func NewMemoryBackend(clock Clock) *MemoryBackend {
+	return &MemoryBackend{clock: clock, counts: make(map[string]*memoryCount)}
+}
+
+// Acquire implements Backend.
+func (b *MemoryBackend) Acquire(_ context.Context, key string, n, limit int, window time.Duration) (Grant, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	c, ok := b.counts[key]
+	if !ok || !now.Before(c.reset) {
+		c = &memoryCount{reset: now.Truncate(window).Add(window)}
+		b.counts[key] = c
+	}
+
+	granted := min(n, max(limit-c.count, 0))
+	c.count += granted
+	return Grant{Granted: granted, Remaining: max(limit-c.count, 0), Reset: c.reset}, nil
+}
+
+// Release implements Backend.
+func (b *MemoryBackend) Release(_ context.Context, key string, n int, reset time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.counts[key]; ok && c.reset.Equal(reset) {
+		c.count = max(c.count-n, 0)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// countingBackend counts the calls to a backend, optionally making each one
+// take latency like a network round trip would.
+type countingBackend struct {
+	Backend
+	latency  time.Duration
+	acquires atomic.Int64
+	releases atomic.Int64
+}
+
+func (b *countingBackend) Acquire(ctx context.Context, key string, n, limit int, window time.Duration) (Grant, error) {
+	b.acquires.Add(1)
+	if b.latency > 0 {
+		time.Sleep(b.latency)
+	}
+	return b.Backend.Acquire(ctx, key, n, limit, window)
+}
+
+func (b *countingBackend) Release(ctx context.Context, key string, n int, reset time.Time) error {
+	b.releases.Add(1)
+	return b.Backend.Release(ctx, key, n, reset)
+}
+
+// failingBackend fails every call while down is set, and blocks until the
+// call times out while hang is set.
+type failingBackend struct {
+	Backend
+	down  atomic.Bool
+	hang  atomic.Bool
+	calls atomic.Int64
+}
+
+func (b *failingBackend) Acquire(ctx context.Context, key string, n, limit int, window time.Duration) (Grant, error) {
+	b.calls.Add(1)
+	if b.hang.Load() {
+		<-ctx.Done()
+		return Grant{}, ctx.Err()
+	}
+	if b.down.Load() {
+		return Grant{}, errors.New("connection refused")
+	}
+	return b.Backend.Acquire(ctx, key, n, limit, window)
+}
+
+func newLeasedLimiter(t testing.TB, backend Backend, clock Clock, limit int, cfg LeaseConfig, opts ...Option) (*Limiter, *Leases) {
+	t.Helper()
+
+	leases := NewLeases(backend, cfg)
+	opts = append([]Option{WithName(t.Name()), WithLeases(leases), WithState(NewState()), WithClock(clock)}, opts...)
+	return NewFixedWindow(limit, time.Minute, opts...), leases
+}
+
+func TestLeasesNeverOverAdmit(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := NewMemoryBackend(clock)
+
+	var replicas []*Limiter
+	for i := 0; i < 3; i++ {
+		l, _ := newLeasedLimiter(t, backend, clock, 100, LeaseConfig{})
+		replicas = append(replicas, l)
+	}
+
+	admitted := 0
+	for i := 0; i < 300; i++ {
+		if replicas[i%3].Allow(context.Background(), "leases", "client").Allowed {
+			admitted++
+		}
+	}
+	if admitted != 100 {
+		t.Errorf("admitted %d requests across replicas, want 100", admitted)
+	}
+}
+
+func TestLeasesCutBackendCalls(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &countingBackend{Backend: NewMemoryBackend(clock)}
+	limiter, _ := newLeasedLimiter(t, backend, clock, 100, LeaseConfig{Fraction: 0.1, TTL: time.Minute})
+
+	for i := 0; i < 100; i++ {
+		if d := limiter.Allow(context.Background(), "leases", "client"); !d.Allowed || d.Remaining != 99-i {
+			t.Fatalf("request %d: got allowed %v, remaining %d; want true, %d", i, d.Allowed, d.Remaining, 99-i)
+		}
+	}
+	if n := backend.acquires.Load(); n != 10 {
+		t.Errorf("got %d backend calls for 100 requests, want 10", n)
+	}
+
+	// The last grant emptied the window, so denials are served locally
+	// until the lease expires
+	for i := 0; i < 10; i++ {
+		if limiter.Allow(context.Background(), "leases", "client").Allowed {
+			t.Fatalf("request over the limit was admitted")
+		}
+	}
+	if n := backend.acquires.Load(); n != 10 {
+		t.Errorf("got %d backend calls after 10 denials, want 10", n)
+	}
+}
+
+func TestLeasesReturnUnusedTokens(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &countingBackend{Backend: NewMemoryBackend(clock)}
+	first, firstLeases := newLeasedLimiter(t, backend, clock, 10, LeaseConfig{Fraction: 0.5})
+	second, _ := newLeasedLimiter(t, backend, clock, 10, LeaseConfig{Fraction: 0.5})
+
+	// The first replica leases 5 tokens but uses only one
+	first.Allow(context.Background(), "leases", "client")
+
+	// Its janitor returns the other 4 once the lease expires
+	clock.Advance(time.Second)
+	firstLeases.sweep(context.Background(), clock.Now())
+	if n := backend.releases.Load(); n != 1 {
+		t.Fatalf("got %d releases want 1", n)
+	}
+
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if second.Allow(context.Background(), "leases", "client").Allowed {
+			admitted++
+		}
+	}
+	if admitted != 9 {
+		t.Errorf("second replica admitted %d requests, want 9", admitted)
+	}
+}
+
+func TestLeasesEndWithWindow(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter, _ := newLeasedLimiter(t, NewMemoryBackend(clock), clock, 2, LeaseConfig{TTL: time.Hour})
+
+	limiter.Allow(context.Background(), "leases", "client")
+	limiter.Allow(context.Background(), "leases", "client")
+	if d := limiter.Allow(context.Background(), "leases", "client"); d.Allowed || d.RetryAfter != time.Minute {
+		t.Fatalf("got allowed %v, retry after %v; want false, 1m", d.Allowed, d.RetryAfter)
+	}
+
+	clock.Advance(time.Minute)
+	if d := limiter.Allow(context.Background(), "leases", "client"); !d.Allowed {
+		t.Errorf("request in the next window was rejected")
+	}
+}
+
+func TestLeasesChargeOnOutcome(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &countingBackend{Backend: NewMemoryBackend(clock)}
+	limiter, _ := newLeasedLimiter(t, backend, clock, 2, LeaseConfig{Fraction: 0.5, TTL: time.Hour}, WithChargeOn(400))
+	ctx := context.Background()
+
+	// Checks that charge nothing never ask the backend
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(ctx, "leases", "client").Allowed {
+			t.Fatalf("request %d was rejected", i)
+		}
+		limiter.Charge(ctx, "leases", "client", 200)
+	}
+	if n := backend.acquires.Load(); n != 0 {
+		t.Errorf("got %d backend calls before any charge, want 0", n)
+	}
+
+	limiter.Charge(ctx, "leases", "client", 400)
+	limiter.Charge(ctx, "leases", "client", 400)
+	if d := limiter.Allow(ctx, "leases", "client"); d.Allowed || d.RetryAfter != time.Minute {
+		t.Errorf("got allowed %v, retry after %v; want false, 1m", d.Allowed, d.RetryAfter)
+	}
+}
+
+func TestLeasesFailureModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode FailureMode
+		// admitted is the number of 5 requests admitted under a limit of 2
+		admitted int
+	}{
+		{FailOpen, 5},
+		{FailClosed, 0},
+		{FailLocal, 2},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			backend := &failingBackend{Backend: NewMemoryBackend(clock)}
+			backend.down.Store(true)
+			limiter, _ := newLeasedLimiter(t, backend, clock, 2, LeaseConfig{}, WithFailureMode(tt.mode))
+
+			fallbacks := leaseFallbacks.Value(t.Name(), string(tt.mode))
+			admitted := 0
+			for i := 0; i < 5; i++ {
+				if limiter.Allow(context.Background(), "leases", "client").Allowed {
+					admitted++
+				}
+			}
+			if admitted != tt.admitted {
+				t.Errorf("admitted %d requests want %d", admitted, tt.admitted)
+			}
+			if got := leaseFallbacks.Value(t.Name(), string(tt.mode)) - fallbacks; got != 5 {
+				t.Errorf("got %v fallbacks want 5", got)
+			}
+		})
+	}
+}
+
+func TestLeasesBackOffFailingBackend(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &failingBackend{Backend: NewMemoryBackend(clock)}
+	var changes []error
+	limiter, _ := newLeasedLimiter(t, backend, clock, 10, LeaseConfig{
+		FailureBackoff:  5 * time.Second,
+		OnBackendChange: func(err error) { changes = append(changes, err) },
+	})
+
+	backend.down.Store(true)
+	for i := 0; i < 3; i++ {
+		limiter.Allow(context.Background(), "leases", "client")
+	}
+	if n := backend.calls.Load(); n != 1 {
+		t.Errorf("got %d backend calls during the backoff, want 1", n)
+	}
+
+	// Once the backoff is over the backend is asked again, and answers
+	backend.down.Store(false)
+	clock.Advance(5 * time.Second)
+	if d := limiter.Allow(context.Background(), "leases", "client"); !d.Allowed || d.Remaining != 9 {
+		t.Errorf("got allowed %v, remaining %d; want true, 9", d.Allowed, d.Remaining)
+	}
+	if len(changes) != 2 || changes[0] == nil || changes[1] != nil {
+		t.Errorf("got backend changes %v, want the failure then nil", changes)
+	}
+}
+
+func TestLeasesTimeOutBackendCalls(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &failingBackend{Backend: NewMemoryBackend(clock)}
+	backend.hang.Store(true)
+	limiter, _ := newLeasedLimiter(t, backend, clock, 10, LeaseConfig{Timeout: 10 * time.Millisecond},
+		WithFailureMode(FailClosed))
+
+	timeouts := leaseBackendCalls.Value("acquire", "timeout")
+	start := time.Now()
+	if limiter.Allow(context.Background(), "leases", "client").Allowed {
+		t.Errorf("request was admitted by a fail-closed policy")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want about the 10ms timeout", elapsed)
+	}
+	if got := leaseBackendCalls.Value("acquire", "timeout") - timeouts; got != 1 {
+		t.Errorf("got %v timeouts want 1", got)
+	}
+}
+
+func TestLeasesSkipFallbackForCancelledCallers(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	backend := &failingBackend{Backend: NewMemoryBackend(clock)}
+	backend.hang.Store(true)
+	limiter, _ := newLeasedLimiter(t, backend, clock, 1, LeaseConfig{Timeout: time.Second},
+		WithFailureMode(FailLocal))
+
+	fallbacks := leaseFallbacks.Value(t.Name(), string(FailLocal))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if limiter.Allow(ctx, "leases", "client").Allowed {
+		t.Errorf("request of a cancelled caller was admitted")
+	}
+	if got := leaseFallbacks.Value(t.Name(), string(FailLocal)) - fallbacks; got != 0 {
+		t.Errorf("got %v fallbacks want 0", got)
+	}
+
+	// The cancelled request did not use up the local limit
+	backend.hang.Store(false)
+	backend.down.Store(true)
+	if !limiter.Allow(context.Background(), "leases", "client").Allowed {
+		t.Errorf("request was not admitted by the local fallback")
+	}
+}
+
+// BenchmarkLeasedFixedWindow compares asking the backend for every request
+// with leasing 10% of the limit at once, against a backend taking 50µs per
+// call. Clients use the full limit of 1000 requests per minute.
+func BenchmarkLeasedFixedWindow(b *testing.B) {
+	for _, bm := range []struct {
+		name     string
+		fraction float64
+	}{
+		{"per-request", 0.001},
+		{"lease-10%", 0.1},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			backend := &countingBackend{Backend: NewMemoryBackend(clock), latency: 50 * time.Microsecond}
+			limiter, _ := newLeasedLimiter(b, backend, clock, 1000, LeaseConfig{Fraction: bm.fraction, TTL: time.Minute})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				limiter.Allow(context.Background(), "leases", "client")
+				clock.Advance(60 * time.Millisecond)
+			}
+			b.ReportMetric(float64(backend.acquires.Load()+backend.releases.Load())/float64(b.N), "backend-calls/op")
+		})
+	}
+}
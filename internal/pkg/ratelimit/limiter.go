@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// result is the outcome of an algorithm check.
+type result struct {
+	allowed bool
+	// remaining is the number of requests still allowed right now
+	remaining int
+	// reset is how long until the limit is fully replenished
+	reset time.Duration
+	// retryAfter is how long until a rejected request could be admitted
+	retryAfter time.Duration
+}
+
+// CheckMode says how a check counts the requests it decides.
+type CheckMode string
+
+const (
+	// CheckAllow counts the requests if they fit in the limit, waiting up to
+	// the policy's MaxWait for them to.
+	CheckAllow CheckMode = ""
+	// CheckPeek reports whether one more request fits without counting it.
+	CheckPeek CheckMode = "peek"
+	// CheckCharge counts requests that were already answered, without waiting
+	// and even over the limit, so the next checks see the debt.
+	CheckCharge CheckMode = "charge"
+)
+
+// checkFunc decides whether the client identified by key may make hits
+// requests under limit, counting them as mode says.
+type checkFunc func(ctx context.Context, key string, limit, hits int, mode CheckMode) result
+
+// Limiter enforces a policy. It holds no framework specific state, so the
+// net/http and gin adapters share the same decisions, headers and responses.
+type Limiter struct {
+	policy Policy
+	check  checkFunc
+}
+
+// Decision is the outcome of a rate limit check. Remaining is the number of
+// requests the client may still make and Reset how long until the limit is
+// fully replenished; RetryAfter is set when the request was denied.
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+
+	adaptive *adaptiveLimit
+}
+
+// Done reports how an allowed request was handled so adaptive policies can
+// follow backend latency and error rate. It is a no-op for other policies.
+func (d Decision) Done(latency time.Duration, status int) {
+	if d.adaptive != nil {
+		d.adaptive.observe(latency, status)
+	}
+}
+
+func newLimiter(policy Policy, check checkFunc) *Limiter {
+	l := &Limiter{
+		policy: policy,
+		check:  check,
+	}
+	registerLimiter(l)
+	if policy.Forwarder != nil {
+		policy.Forwarder.Register(l)
+	}
+	return l
+}
+
+// Policy returns the policy enforced by the limiter.
+func (l *Limiter) Policy() Policy {
+	return l.policy
+}
+
+// Key returns the rate limit key for r using the policy's KeyFunc, or
+// fallback when the policy does not define one.
+func (l *Limiter) Key(r *http.Request, fallback func() string) string {
+	if l.policy.KeyFunc != nil {
+		return l.policy.KeyFunc(r)
+	}
+	return fallback()
+}
+
+// Allow decides whether the client identified by key may make a request on
+// route, waiting up to the policy's MaxWait for capacity. Policies charging
+// by outcome do not count the request, which Charge does once answered.
+func (l *Limiter) Allow(ctx context.Context, route, key string) Decision {
+	if l.policy.ChargesOutcome() {
+		return l.decide(ctx, route, key, 1, CheckPeek)
+	}
+	return l.AllowN(ctx, route, key, 1)
+}
+
+// AllowN is like Allow for hits requests at once. Either all of them are
+// admitted or none is.
+func (l *Limiter) AllowN(ctx context.Context, route, key string, hits int) Decision {
+	return l.decide(ctx, route, key, hits, CheckAllow)
+}
+
+// decide decides hits requests on the replica owning key, counting them as
+// mode says.
+func (l *Limiter) decide(ctx context.Context, route, key string, hits int, mode CheckMode) Decision {
+	route = trackRoute(route)
+	if l.policy.Forwarder != nil {
+		if d, ok := l.policy.Forwarder.Forward(ctx, l, route, key, hits, mode); ok {
+			if l.policy.Adaptive != nil {
+				// The route is still served here, so its health is observed here
+				d.adaptive = adaptiveFor(l.policy, route)
+			}
+			return d
+		}
+	}
+	return l.CheckLocal(ctx, route, key, hits, mode)
+}
+
+// AllowLocal is like AllowN but always decides on this replica.
+func (l *Limiter) AllowLocal(ctx context.Context, route, key string, hits int) Decision {
+	return l.CheckLocal(ctx, route, key, hits, CheckAllow)
+}
+
+// CheckLocal decides hits requests on this replica, counting them as mode
+// says, e.g. for the checks forwarded by other replicas. Charges are not
+// recorded as decisions, the request was decided when admitted.
+func (l *Limiter) CheckLocal(ctx context.Context, route, key string, hits int, mode CheckMode) Decision {
+	if hits <= 0 {
+		panic("ratelimit: hits must be positive")
+	}
+	route = trackRoute(route)
+
+	d := Decision{Limit: l.policy.Limit}
+	if l.policy.Adaptive != nil {
+		d.adaptive = adaptiveFor(l.policy, route)
+		d.Limit = d.adaptive.Limit()
+	}
+
+	now := l.policy.clock.Now()
+	res := l.check(ctx, key, l.globalLimit(key, d.Limit, now), hits, mode)
+	if res.allowed && mode != CheckPeek {
+		l.recordPeers(key, hits, now)
+	}
+	d.Allowed = res.allowed
+	d.Remaining = res.remaining
+	d.Reset = res.reset
+	if !res.allowed {
+		d.RetryAfter = res.retryAfter
+	}
+	if mode != CheckCharge {
+		recordDecision(route, l.policy.Name, key, d.Allowed, now)
+	}
+	return d
+}
+
+// Charge counts a request admitted by Allow once it was answered with
+// status, if the policy charges that outcome. The request is counted at once
+// even over the limit, so the next Allow sees the debt. It is a no-op for
+// policies counting requests when they are admitted.
+func (l *Limiter) Charge(ctx context.Context, route, key string, status int) {
+	if l.policy.Charges(status) {
+		l.decide(ctx, route, key, 1, CheckCharge)
+	}
+}
+
+// Rejection describes a denied decision with the policy's status and message,
+// for adapters that do not write HTTP responses.
+func (l *Limiter) Rejection(d Decision) Rejection {
+	return newRejection(l.policy, d)
+}
+
+// Reject writes the rejection response for a denied decision.
+func (l *Limiter) Reject(w http.ResponseWriter, r *http.Request, d Decision) {
+	rejectRequest(w, r, l.policy, l.Rejection(d))
+}
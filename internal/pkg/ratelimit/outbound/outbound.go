@@ -0,0 +1,266 @@
+// Package outbound rate limits and retries requests the server makes to
+// upstream services, so traffic spikes on our side are not passed on as
+// bursts that get us throttled or banned upstream.
+package outbound
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec(
+		"outbound_requests_total",
+		"Outbound request attempts by upstream host and outcome.",
+		"host", "outcome",
+	)
+	retriesTotal = metrics.NewCounterVec(
+		"outbound_retries_total",
+		"Outbound requests retried by upstream host.",
+		"host",
+	)
+)
+
+// HostLimit is the request rate allowed to one upstream host.
+type HostLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// Config configures a Transport. Zero values get the defaults noted below.
+type Config struct {
+	// Default applies to hosts without an entry in Hosts (default 5/s, burst 10).
+	Default HostLimit
+	// Hosts overrides the limit of specific hosts, keyed by URL host.
+	Hosts map[string]HostLimit
+	// MaxRetries is the number of retries after the first attempt (default 3,
+	// negative disables retries). Only idempotent requests with a replayable
+	// body are retried.
+	MaxRetries int
+	// BaseDelay and MaxDelay bound the jittered exponential backoff
+	// (default 200ms and 5s).
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxRetryAfter is the longest upstream Retry-After that is waited out;
+	// longer ones, or ones past the request deadline, return the response to
+	// the caller (default 10s, below the timeout of typical clients).
+	MaxRetryAfter time.Duration
+}
+
+// Transport is an http.RoundTripper applying a token bucket per upstream
+// host and retrying failed idempotent requests.
+type Transport struct {
+	base http.RoundTripper
+	cfg  Config
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+	// blockedUntil pauses all requests to a host that asked us to back off
+	blockedUntil map[string]time.Time
+}
+
+// NewTransport wraps base, or http.DefaultTransport when nil.
+func NewTransport(base http.RoundTripper, cfg Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.Default.RequestsPerSecond <= 0 {
+		cfg.Default.RequestsPerSecond = 5
+	}
+	if cfg.Default.Burst <= 0 {
+		cfg.Default.Burst = 10
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 200 * time.Millisecond
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 5 * time.Second
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = 10 * time.Second
+	}
+
+	return &Transport{
+		base:         base,
+		cfg:          cfg,
+		buckets:      make(map[string]*rate.Limiter),
+		blockedUntil: make(map[string]time.Time),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx, host); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			requestsTotal.Inc(host, "error")
+		} else {
+			requestsTotal.Inc(host, strconv.Itoa(resp.StatusCode))
+		}
+
+		if attempt >= t.cfg.MaxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := backoff(t.cfg.BaseDelay, t.cfg.MaxDelay, attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				// The host asked us to back off even if this caller gives up
+				t.block(host, after)
+				if after > t.cfg.MaxRetryAfter || pastDeadline(ctx, after) {
+					return resp, err
+				}
+				delay = max(delay, after)
+			}
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		if !sleepContext(ctx, delay) {
+			return nil, ctx.Err()
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+		retriesTotal.Inc(host)
+	}
+}
+
+// wait blocks until host is no longer backing off and a token is available.
+func (t *Transport) wait(ctx context.Context, host string) error {
+	t.mu.Lock()
+	until := t.blockedUntil[host]
+	bucket := t.bucket(host)
+	t.mu.Unlock()
+
+	if d := time.Until(until); d > 0 && !sleepContext(ctx, d) {
+		return ctx.Err()
+	}
+	return bucket.Wait(ctx)
+}
+
+// bucket returns the token bucket of host. Must be called with mu held.
+func (t *Transport) bucket(host string) *rate.Limiter {
+	if b, ok := t.buckets[host]; ok {
+		return b
+	}
+
+	limit, ok := t.cfg.Hosts[host]
+	if !ok {
+		limit = t.cfg.Default
+	}
+	b := rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), max(limit.Burst, 1))
+	t.buckets[host] = b
+	return b
+}
+
+// block pauses requests to host for d.
+func (t *Transport) block(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(t.blockedUntil[host]) {
+		t.blockedUntil[host] = until
+	}
+}
+
+// pastDeadline reports whether waiting d would outlast the deadline of ctx.
+func pastDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < d
+}
+
+// retryable reports whether a failed attempt may be repeated: the request
+// must be idempotent and replayable, and the failure transient.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewind returns a copy of req with a fresh body for the next attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next := req.Clone(req.Context())
+	next.Body = body
+	return next, nil
+}
+
+// backoff returns a "full jitter" delay: a random duration up to the
+// exponentially growing cap for the attempt.
+func backoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	ceiling := maxDelay
+	if attempt < 30 {
+		ceiling = min(base<<attempt, maxDelay)
+	}
+	return rand.N(ceiling) + 1
+}
+
+// retryAfter parses the Retry-After header of a response, given either in
+// seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// sleepContext sleeps for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
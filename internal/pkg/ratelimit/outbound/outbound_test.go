@@ -0,0 +1,138 @@
+package outbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportRetries(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, Config{
+		Default:   HostLimit{RequestsPerSecond: 100, Burst: 10},
+		BaseDelay: time.Millisecond,
+		MaxDelay:  5 * time.Millisecond,
+	})}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("got status %d after %d calls, want 200 after 3", resp.StatusCode, calls.Load())
+	}
+
+	// Non-idempotent requests are never retried
+	calls.Store(0)
+	resp, err = client.Post(upstream.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("POST: got status %d after %d calls, want 503 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestTransportHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, Config{
+		Default:   HostLimit{RequestsPerSecond: 100, Burst: 10},
+		BaseDelay: time.Millisecond,
+	})}
+
+	start := time.Now()
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d want 200", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+
+	// A Retry-After longer than MaxRetryAfter is returned to the caller,
+	// and the host is still backed off from
+	calls.Store(0)
+	transport := NewTransport(nil, Config{MaxRetryAfter: 500 * time.Millisecond})
+	client.Transport = transport
+	resp, err = client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("got status %d after %d calls, want 429 after 1", resp.StatusCode, calls.Load())
+	}
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	transport.mu.Lock()
+	blocked := time.Until(transport.blockedUntil[host])
+	transport.mu.Unlock()
+	if blocked <= 0 {
+		t.Errorf("host not blocked after a Retry-After over MaxRetryAfter")
+	}
+
+	// So is one past the request deadline
+	calls.Store(0)
+	client.Transport = NewTransport(nil, Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("with a deadline: got status %d after %d calls, want 429 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestTransportRateLimitsPerHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, Config{
+		Default: HostLimit{RequestsPerSecond: 10, Burst: 1},
+	})}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+	}
+	// One request is allowed immediately, the other three wait 100ms each
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("4 requests at 10/s took %v, want at least 300ms", elapsed)
+	}
+}
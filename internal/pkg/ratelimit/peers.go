@@ -0,0 +1,43 @@
+package ratelimit
+
+import "time"
+
+// Peers shares the hits admitted by a policy with the other replicas of the
+// service, so each replica enforces the limit on an estimate of the global
+// count rather than on its own share of the traffic.
+type Peers interface {
+	// Record reports hits admitted locally for key under policy at now.
+	Record(policy, key string, hits int, now time.Time)
+	// Remote returns the hits the other replicas admitted for key under
+	// policy within window before now, as last heard from them.
+	Remote(policy, key string, window time.Duration, now time.Time) int
+}
+
+// WithPeers makes a window policy count the hits admitted by other replicas
+// against its limit. Token bucket policies keep enforcing their local bucket.
+func WithPeers(peers Peers) Option {
+	return func(p *Policy) {
+		p.Peers = peers
+	}
+}
+
+// globalLimit replaces limit with the override of key, then lowers it by the
+// hits other replicas admitted for key, so the local check allows only what
+// is left of the global limit. Token buckets apply overrides to their size.
+func (l *Limiter) globalLimit(key string, limit int, now time.Time) int {
+	if l.policy.Algorithm == AlgorithmTokenBucket {
+		return limit
+	}
+	limit = overrideLimit(key, limit, now)
+	if l.policy.Peers == nil {
+		return limit
+	}
+	return max(limit-l.policy.Peers.Remote(l.policy.Name, key, l.policy.Window, now), 0)
+}
+
+// recordPeers shares hits admitted locally with the other replicas.
+func (l *Limiter) recordPeers(key string, hits int, now time.Time) {
+	if l.policy.Peers != nil && l.policy.Algorithm != AlgorithmTokenBucket {
+		l.policy.Peers.Record(l.policy.Name, key, hits, now)
+	}
+}
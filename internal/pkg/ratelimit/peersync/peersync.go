@@ -0,0 +1,318 @@
+// Package peersync shares approximate rate limit counts between replicas of
+// the server without a central store. Every replica periodically sends the
+// hits it admitted since the last broadcast to a static list of peers over
+// HTTP and adds the hits it receives to its estimate of the global count.
+package peersync
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Path is where a Syncer's Handler is expected to be mounted on every peer.
+const Path = "/internal/peersync"
+
+var (
+	broadcastsTotal = metrics.NewCounterVec(
+		"peersync_broadcasts_total",
+		"Deltas sent to peers by peer and outcome (ok or error).",
+		"peer", "outcome",
+	)
+	receivedHits = metrics.NewCounterVec(
+		"peersync_received_hits_total",
+		"Hits received from peers by sending replica.",
+		"from",
+	)
+)
+
+// Config configures a Syncer. Zero values get the defaults noted below.
+type Config struct {
+	// ID identifies this replica in the deltas it sends.
+	ID string
+	// Peers are the base URLs of the other replicas, e.g. http://10.0.0.2:8080.
+	Peers []string
+	// Token authenticates deltas between peers. It must be the same on
+	// every replica; without it every delta is refused.
+	Token string
+	// Interval is how often deltas are sent, which bounds how stale the
+	// remote counts are (default 1s).
+	Interval time.Duration
+	// Resolution is the width of the time buckets hits are counted in.
+	// Hits up to Resolution older than a window may still count against it,
+	// so coarser buckets overestimate more but use less memory (default 1s).
+	Resolution time.Duration
+	// Retention is how long remote hits are kept. It must cover the longest
+	// window enforced with the Syncer (default 1h). Hits further in the past
+	// or the future are dropped.
+	Retention time.Duration
+	// MaxKeys bounds the keys counted, in the pending and the remote hits
+	// each, evicting the least recently used one (default 100000).
+	MaxKeys int
+	// Clock tells the time deltas are received at (default the system clock).
+	Clock ratelimit.Clock
+	// Client sends the deltas (default a client timing out after Interval).
+	Client *http.Client
+}
+
+// Delta is the body of a broadcast: the hits a replica admitted since its
+// previous broadcast.
+type Delta struct {
+	From string `json:"from"`
+	Hits []Hit  `json:"hits"`
+}
+
+// Hit counts the hits admitted for a key under a policy in the time bucket
+// starting at At.
+type Hit struct {
+	Policy string    `json:"policy"`
+	Key    string    `json:"key"`
+	At     time.Time `json:"at"`
+	Hits   int       `json:"hits"`
+}
+
+// counter counts the hits of a key under a policy per time bucket, keyed by
+// the bucket start.
+type counter struct {
+	policy  string
+	key     string
+	buckets map[time.Time]int
+	// expires is when the newest bucket leaves the retention
+	expires time.Time
+}
+
+// Syncer implements ratelimit.Peers by exchanging deltas with its peers.
+type Syncer struct {
+	cfg Config
+
+	mu sync.Mutex
+	// pending holds the local hits not broadcast yet
+	pending *ratelimit.Cache[*counter]
+	// remote holds the hits heard from peers
+	remote *ratelimit.Cache[*counter]
+}
+
+var _ ratelimit.Peers = (*Syncer)(nil)
+
+// New returns a Syncer for cfg.
+func New(cfg Config) *Syncer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Resolution <= 0 {
+		cfg.Resolution = time.Second
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = time.Hour
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Interval}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = ratelimit.SystemClock
+	}
+
+	return &Syncer{
+		cfg:     cfg,
+		pending: ratelimit.NewCache[*counter]("peersync_pending", cfg.MaxKeys),
+		remote:  ratelimit.NewCache[*counter]("peersync_remote", cfg.MaxKeys),
+	}
+}
+
+// Record queues hits admitted locally for the next broadcast.
+func (s *Syncer) Record(policy, key string, hits int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(s.pending, policy, key, now.Truncate(s.cfg.Resolution), hits, now)
+}
+
+// Remote returns the hits peers reported for key under policy in the buckets
+// overlapping the window before now.
+func (s *Syncer) Remote(policy, key string, window time.Duration, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.remote.Get(counterKey(policy, key), now)
+	if !ok {
+		return 0
+	}
+	cutoff := now.Add(-window)
+	total := 0
+	for start, hits := range c.buckets {
+		if start.Add(s.cfg.Resolution).After(cutoff) {
+			total += hits
+		}
+	}
+	return total
+}
+
+// counterKey is the key of the counter of key under policy.
+func counterKey(policy, key string) string {
+	return policy + "|" + key
+}
+
+// add counts hits in the bucket starting at at of the counter of key under
+// policy, keeping the counter until the bucket leaves the retention. The
+// caller must hold s.mu.
+func (s *Syncer) add(counters *ratelimit.Cache[*counter], policy, key string, at time.Time, hits int, now time.Time) {
+	k := counterKey(policy, key)
+	expires := at.Add(s.cfg.Resolution + s.cfg.Retention)
+
+	c, ok := counters.Get(k, now)
+	if !ok {
+		c = &counter{policy: policy, key: key, buckets: make(map[time.Time]int), expires: expires}
+		counters.Set(k, c, now, expires)
+	} else if expires.After(c.expires) {
+		c.expires = expires
+		counters.Expire(k, expires)
+	}
+	c.buckets[at] += hits
+}
+
+// Handler accepts the deltas broadcast by peers.
+func (s *Syncer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.cfg.Token)) != 1 {
+			http.Error(w, "invalid peer token", http.StatusUnauthorized)
+			return
+		}
+
+		var delta Delta
+		if err := json.NewDecoder(r.Body).Decode(&delta); err != nil {
+			http.Error(w, fmt.Sprintf("invalid delta: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.merge(delta)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// merge adds the hits of a peer's delta to the remote counts. Deltas
+// looping back from this replica are ignored.
+func (s *Syncer) merge(delta Delta) {
+	if delta.From == s.cfg.ID {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.cfg.Clock.Now()
+	total := 0
+	for _, h := range delta.Hits {
+		if h.Hits <= 0 || h.At.Before(now.Add(-s.cfg.Retention)) || h.At.After(now.Add(s.cfg.Retention)) {
+			continue
+		}
+		s.add(s.remote, h.Policy, h.Key, h.At.Truncate(s.cfg.Resolution), h.Hits, now)
+		total += h.Hits
+	}
+	receivedHits.Add(float64(total), delta.From)
+}
+
+// Flush sends the pending hits to every peer at once. Peers that cannot be
+// reached miss the delta and undercount those hits until they leave the
+// window, as the counts are only approximate.
+func (s *Syncer) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = ratelimit.NewCache[*counter]("peersync_pending", s.cfg.MaxKeys)
+	s.mu.Unlock()
+
+	if pending.Len() == 0 || len(s.cfg.Peers) == 0 {
+		return nil
+	}
+
+	delta := Delta{From: s.cfg.ID}
+	pending.Each(func(_ string, c *counter) {
+		for at, hits := range c.buckets {
+			delta.Hits = append(delta.Hits, Hit{Policy: c.policy, Key: c.key, At: at, Hits: hits})
+		}
+	})
+	body, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("error encoding delta: %v", err)
+	}
+
+	errs := make([]error, len(s.cfg.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range s.cfg.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.send(ctx, peer, body)
+			if errs[i] != nil {
+				broadcastsTotal.Inc(peer, "error")
+			} else {
+				broadcastsTotal.Inc(peer, "ok")
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *Syncer) send(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+Path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error sending delta to %s: %v", peer, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending delta to %s: %v", peer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("error sending delta to %s: status %d", peer, resp.StatusCode)
+	}
+	return nil
+}
+
+// prune drops the remote hits older than the retention.
+func (s *Syncer) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remote.Sweep(now)
+	cutoff := now.Add(-s.cfg.Retention)
+	s.remote.Each(func(_ string, c *counter) {
+		for start := range c.buckets {
+			if !start.Add(s.cfg.Resolution).After(cutoff) {
+				delete(c.buckets, start)
+			}
+		}
+	})
+}
+
+// Run broadcasts the pending hits every interval until ctx is done, reading
+// the time from clock. Failed broadcasts are counted in
+// peersync_broadcasts_total and their hits are not sent again.
+func (s *Syncer) Run(ctx context.Context, clock ratelimit.Clock) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-clock.After(s.cfg.Interval):
+			s.Flush(ctx)
+			s.prune(now)
+		}
+	}
+}
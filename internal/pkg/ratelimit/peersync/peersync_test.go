@@ -0,0 +1,272 @@
+package peersync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/ratelimittest"
+)
+
+// replica is one server of a test cluster, with its own limiter state.
+type replica struct {
+	syncer *Syncer
+	server *httptest.Server
+}
+
+// startCluster runs n replicas in this process, each limiting /limited to
+// limit requests per window and syncing with all the others.
+func startCluster(t *testing.T, n, limit int, window time.Duration, clock ratelimit.Clock, cfg Config) []*replica {
+	t.Helper()
+
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		urls[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+
+	cluster := make([]*replica, n)
+	for i, srv := range servers {
+		peerCfg := cfg
+		peerCfg.ID = urls[i]
+		peerCfg.Token = "secret"
+		peerCfg.Clock = clock
+		for j, url := range urls {
+			if j != i {
+				peerCfg.Peers = append(peerCfg.Peers, url)
+			}
+		}
+		syncer := New(peerCfg)
+
+		limiter := ratelimit.NewSlidingWindow(limit, window,
+			ratelimit.WithName(t.Name()),
+			ratelimit.WithState(ratelimit.NewState()),
+			ratelimit.WithPeers(syncer),
+			ratelimit.WithClock(clock),
+		)
+		mux := http.NewServeMux()
+		mux.Handle("/limited", limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		mux.Handle(Path, syncer.Handler())
+
+		srv.Config.Handler = mux
+		srv.Start()
+		t.Cleanup(srv.Close)
+		cluster[i] = &replica{syncer: syncer, server: srv}
+	}
+	return cluster
+}
+
+func (r *replica) get(t *testing.T) int {
+	t.Helper()
+
+	resp, err := http.Get(r.server.URL + "/limited")
+	if err != nil {
+		t.Fatalf("GET /limited: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func flush(t *testing.T, cluster []*replica) {
+	t.Helper()
+
+	for _, r := range cluster {
+		if err := r.syncer.Flush(context.Background()); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+}
+
+func TestClusterEnforcesGlobalLimit(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cluster := startCluster(t, 3, 6, time.Minute, clock, Config{})
+
+	// Every replica admits its share of the global limit
+	for _, r := range cluster {
+		for i := 0; i < 2; i++ {
+			if got := r.get(t); got != http.StatusOK {
+				t.Fatalf("request %d: got %d want 200", i, got)
+			}
+		}
+	}
+
+	// Once synced each replica sees the global count
+	flush(t, cluster)
+	for i, r := range cluster {
+		if got := r.get(t); got != http.StatusTooManyRequests {
+			t.Errorf("replica %d: got %d want 429", i, got)
+		}
+	}
+
+	// And admits again once the remote hits left the window
+	clock.Advance(time.Minute + time.Second)
+	for i, r := range cluster {
+		if got := r.get(t); got != http.StatusOK {
+			t.Errorf("replica %d after the window: got %d want 200", i, got)
+		}
+	}
+}
+
+func TestClusterStaleness(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cluster := startCluster(t, 2, 4, time.Minute, clock, Config{})
+
+	// Between broadcasts replicas only know their own hits, so the
+	// cluster can overshoot by up to a limit per replica
+	admitted := 0
+	for _, r := range cluster {
+		for i := 0; i < 4; i++ {
+			if r.get(t) == http.StatusOK {
+				admitted++
+			}
+		}
+	}
+	if admitted != 8 {
+		t.Errorf("admitted %d requests before syncing, want 8", admitted)
+	}
+
+	flush(t, cluster)
+	if got := cluster[0].get(t); got != http.StatusTooManyRequests {
+		t.Errorf("got %d after syncing, want 429", got)
+	}
+}
+
+func TestClusterPeerDown(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cluster := startCluster(t, 3, 3, time.Minute, clock, Config{})
+	down := cluster[2]
+	down.server.Close()
+
+	for i := 0; i < 3; i++ {
+		cluster[0].get(t)
+	}
+	errors := broadcastsTotal.Value(down.server.URL, "error")
+	if err := cluster[0].syncer.Flush(context.Background()); err == nil {
+		t.Errorf("Flush should report the unreachable peer")
+	}
+	if got := broadcastsTotal.Value(down.server.URL, "error") - errors; got != 1 {
+		t.Errorf("broadcast errors: got %v want 1", got)
+	}
+
+	// The reachable peer still got the delta
+	if got := cluster[1].get(t); got != http.StatusTooManyRequests {
+		t.Errorf("got %d want 429", got)
+	}
+}
+
+func TestHandlerRequiresToken(t *testing.T) {
+	tests := []struct {
+		token  string
+		auth   string
+		status int
+	}{
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusNoContent},
+		// Without a token every delta is refused
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		syncer := New(Config{ID: "a", Token: tt.token})
+		req := httptest.NewRequest("POST", Path, strings.NewReader(`{"from":"b","hits":[]}`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		syncer.Handler().ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("token %q, Authorization %q: got %d want %d", tt.token, tt.auth, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRemoteResolution(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 1, 0, 0, time.UTC)
+	clock := ratelimittest.NewFakeClock(now)
+	syncer := New(Config{ID: "a", Resolution: 10 * time.Second, Retention: time.Minute, Clock: clock})
+	syncer.merge(Delta{From: "b", Hits: []Hit{
+		{Policy: "p", Key: "k", At: now.Add(-70 * time.Second), Hits: 1},
+		{Policy: "p", Key: "k", At: now.Add(-60 * time.Second), Hits: 2},
+		{Policy: "p", Key: "k", At: now.Add(-10 * time.Second), Hits: 4},
+		{Policy: "other", Key: "k", At: now, Hits: 8},
+	}})
+	// Deltas from this replica are ignored
+	syncer.merge(Delta{From: "a", Hits: []Hit{{Policy: "p", Key: "k", At: now, Hits: 16}}})
+
+	// The bucket starting 60s ago overlaps the minute window
+	if got := syncer.Remote("p", "k", time.Minute, now); got != 6 {
+		t.Errorf("got %d remote hits want 6", got)
+	}
+	if got := syncer.Remote("p", "k", 15*time.Second, now); got != 4 {
+		t.Errorf("got %d remote hits in 15s want 4", got)
+	}
+
+	syncer.prune(now)
+	if got := syncer.Remote("p", "k", time.Hour, now); got != 6 {
+		t.Errorf("got %d remote hits after pruning want 6", got)
+	}
+}
+
+func TestRemoteBounded(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := ratelimittest.NewFakeClock(now)
+	syncer := New(Config{ID: "a", Retention: time.Minute, MaxKeys: 2, Clock: clock})
+
+	syncer.merge(Delta{From: "b", Hits: []Hit{
+		{Policy: "p", Key: "k1", At: now, Hits: 1},
+		{Policy: "p", Key: "k2", At: now, Hits: 1},
+		{Policy: "p", Key: "k3", At: now, Hits: 1},
+		// Hits outside the retention are dropped
+		{Policy: "p", Key: "k3", At: now.Add(-2 * time.Minute), Hits: 2},
+		{Policy: "p", Key: "k3", At: now.Add(24 * time.Hour), Hits: 4},
+	}})
+
+	// The least recently used key made room for the newest
+	if got := syncer.Remote("p", "k1", time.Minute, now); got != 0 {
+		t.Errorf("k1: got %d remote hits want 0", got)
+	}
+	if got := syncer.Remote("p", "k3", 48*time.Hour, now); got != 1 {
+		t.Errorf("k3: got %d remote hits want 1", got)
+	}
+
+	// Keys are forgotten once their hits leave the retention
+	syncer.prune(now.Add(2 * time.Minute))
+	syncer.mu.Lock()
+	size := syncer.remote.Len()
+	syncer.mu.Unlock()
+	if size != 0 {
+		t.Errorf("got %d keys after the retention want 0", size)
+	}
+}
+
+func TestClusterOverride(t *testing.T) {
+	clock := ratelimittest.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cluster := startCluster(t, 2, 10, time.Minute, clock, Config{})
+	ratelimit.SetOverride("127.0.0.1", 3, 0)
+	t.Cleanup(func() { ratelimit.RemoveOverride("127.0.0.1") })
+
+	// The override is the global limit, the peers' hits count against it
+	admitted := 0
+	for i := 0; i < 2; i++ {
+		if cluster[0].get(t) == http.StatusOK {
+			admitted++
+		}
+	}
+	flush(t, cluster)
+	for i := 0; i < 3; i++ {
+		if cluster[1].get(t) == http.StatusOK {
+			admitted++
+		}
+	}
+	if admitted != 3 {
+		t.Errorf("admitted %d requests across replicas, want 3", admitted)
+	}
+}
@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+)
+
+// Algorithm identifies the rate limiting algorithm used by a policy.
+type Algorithm string
+
+const (
+	AlgorithmFixedWindow   Algorithm = "fixed_window"
+	AlgorithmSlidingWindow Algorithm = "sliding_window"
+	AlgorithmTokenBucket   Algorithm = "token_bucket"
+)
+
+// Policy describes a rate limit enforced by a Limiter.
+// For window algorithms Limit is the number of requests allowed per Window;
+// for the token bucket Limit is the refill rate per second and Burst the bucket size.
+// MaxWait lets a request wait up to that long for capacity instead of being rejected.
+// Adaptive, when set, lets Limit follow the health of the protected routes.
+// Rejections are written by Responder with RejectStatus, defaulting to
+// content negotiation and 429 Too Many Requests. KeyFunc identifies clients,
+// defaulting to the client IP as seen by the adapter in use.
+type Policy struct {
+	Name      string
+	Algorithm Algorithm
+	Limit     int
+	Window    time.Duration
+	Burst     int
+	MaxWait   time.Duration
+	Adaptive  *AdaptiveConfig
+	// Peers counts the hits admitted by other replicas.
+	Peers Peers
+	// Forwarder sends the check of each key to the replica owning it.
+	Forwarder Forwarder
+	// FailureMode decides requests while a shared backend is failing.
+	FailureMode  FailureMode
+	Responder    Responder
+	RejectStatus int
+	KeyFunc      KeyFunc
+	// ChargeOn lists the statuses of the requests counted, see WithChargeOn.
+	ChargeOn []int
+
+	message *template.Template
+	clock   Clock
+	state   *State
+	leases  *Leases
+}
+
+// Option customises a policy created by one of the limiter constructors.
+type Option func(*Policy)
+
+// WithName sets the name the policy is listed under in the admin API.
+func WithName(name string) Option {
+	return func(p *Policy) {
+		p.Name = name
+	}
+}
+
+// WithMaxWait delays requests for up to d until capacity is available
+// rather than rejecting them straight away. Requests that would need to wait
+// longer are rejected immediately.
+func WithMaxWait(d time.Duration) Option {
+	return func(p *Policy) {
+		p.MaxWait = d
+	}
+}
+
+// WithKeyFunc sets how clients are identified, e.g. by API key instead of IP.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(p *Policy) {
+		p.KeyFunc = fn
+	}
+}
+
+// WithChargeOn counts only the requests answered with one of statuses, e.g.
+// 400 and 401 to limit failed logins rather than traffic. Requests run
+// before being counted: Allow admits them while the requests charged so far
+// are under the limit, and adapters call Limiter.Charge with the status of
+// each one once answered.
+func WithChargeOn(statuses ...int) Option {
+	return func(p *Policy) {
+		p.ChargeOn = statuses
+	}
+}
+
+// ChargesOutcome reports whether the policy counts requests by their
+// outcome rather than when they are admitted.
+func (p Policy) ChargesOutcome() bool {
+	return len(p.ChargeOn) > 0
+}
+
+// Charges reports whether a request answered with status counts against
+// the limit of a policy charging by outcome. Charged requests count even
+// past the limit, so concurrent failures are all counted.
+func (p Policy) Charges(status int) bool {
+	for _, s := range p.ChargeOn {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// newPolicy applies opts to p. Policies without a name get one derived from
+// their parameters.
+func newPolicy(p Policy, opts []Option) Policy {
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if p.Name == "" {
+		switch p.Algorithm {
+		case AlgorithmTokenBucket:
+			p.Name = fmt.Sprintf("%s %d/s burst %d", p.Algorithm, p.Limit, p.Burst)
+		default:
+			p.Name = fmt.Sprintf("%s %d/%s", p.Algorithm, p.Limit, p.Window)
+		}
+	}
+	if p.FailureMode == "" {
+		p.FailureMode = FailOpen
+	}
+	if p.clock == nil {
+		p.clock = SystemClock
+	}
+	if p.state == nil {
+		p.state = defaultState
+	}
+	return p
+}
+
+// MarshalJSON renders durations in a human readable form.
+func (p Policy) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Name        string      `json:"name"`
+		Algorithm   Algorithm   `json:"algorithm"`
+		Limit       int         `json:"limit"`
+		Window      string      `json:"window,omitempty"`
+		Burst       int         `json:"burst,omitempty"`
+		MaxWait     string      `json:"max_wait,omitempty"`
+		Adaptive    bool        `json:"adaptive,omitempty"`
+		FailureMode FailureMode `json:"failure_mode,omitempty"`
+		ChargeOn    []int       `json:"charge_on,omitempty"`
+	}{
+		Name:      p.Name,
+		Algorithm: p.Algorithm,
+		Limit:     p.Limit,
+		Burst:     p.Burst,
+		Adaptive:  p.Adaptive != nil,
+		ChargeOn:  p.ChargeOn,
+	}
+	if p.Window > 0 {
+		out.Window = p.Window.String()
+	}
+	if p.MaxWait > 0 {
+		out.MaxWait = p.MaxWait.String()
+	}
+	if p.leases != nil {
+		out.FailureMode = p.FailureMode
+	}
+	return json.Marshal(out)
+}
+
+var limiters = make(map[string]*Limiter)
+
+// registerLimiter records a limiter so its policy can be listed by the admin
+// API and looked up by name.
+func registerLimiter(l *Limiter) {
+	statsMu.Lock()
+	limiters[l.policy.Name] = l
+	statsMu.Unlock()
+}
+
+// Lookup returns the limiter last created for the policy named name.
+func Lookup(name string) (*Limiter, bool) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	l, ok := limiters[name]
+	return l, ok
+}
+
+// Policies returns all registered policies sorted by name.
+func Policies() []Policy {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	list := make([]Policy, 0, len(limiters))
+	for _, l := range limiters {
+		list = append(list, l.policy)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultRejectError   = "Too many requests, please try again later."
+	defaultRejectMessage = "You have exceeded the rate limit. Please wait before making more requests."
+)
+
+// Rejection describes a request rejected by a rate limit policy.
+type Rejection struct {
+	Policy     string
+	Status     int
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	// Message is the policy's message rendered for this rejection.
+	Message string
+}
+
+// RetryAfterSeconds returns the retry delay rounded up to whole seconds,
+// as sent in the Retry-After header.
+func (r Rejection) RetryAfterSeconds() int {
+	return int(math.Ceil(r.RetryAfter.Seconds()))
+}
+
+// Responder writes the response for a rejected request.
+type Responder interface {
+	Respond(w http.ResponseWriter, req *http.Request, r Rejection)
+}
+
+// ResponderFunc adapts a function to the Responder interface.
+type ResponderFunc func(w http.ResponseWriter, req *http.Request, r Rejection)
+
+func (f ResponderFunc) Respond(w http.ResponseWriter, req *http.Request, r Rejection) {
+	f(w, req, r)
+}
+
+var (
+	// JSONResponder writes the classic {"error": ..., "message": ...} body.
+	JSONResponder Responder = ResponderFunc(respondJSON)
+	// ProblemJSONResponder writes an RFC 9457 application/problem+json body
+	// with the limit, remaining and retry_after extension members.
+	ProblemJSONResponder Responder = ResponderFunc(respondProblemJSON)
+	// PlainTextResponder writes the message as text/plain.
+	PlainTextResponder Responder = ResponderFunc(respondPlainText)
+	// NegotiatedResponder picks one of the built-in formats from the
+	// request's Accept header, defaulting to JSON.
+	NegotiatedResponder Responder = ResponderFunc(respondNegotiated)
+)
+
+func respondJSON(w http.ResponseWriter, req *http.Request, r Rejection) {
+	writeJSON(w, "application/json; charset=utf-8", r.Status, map[string]any{
+		"error":   defaultRejectError,
+		"message": r.Message,
+	})
+}
+
+func respondProblemJSON(w http.ResponseWriter, req *http.Request, r Rejection) {
+	problem := map[string]any{
+		"type":      "about:blank",
+		"title":     http.StatusText(r.Status),
+		"status":    r.Status,
+		"detail":    r.Message,
+		"instance":  req.URL.Path,
+		"limit":     r.Limit,
+		"remaining": r.Remaining,
+	}
+	if r.RetryAfter > 0 {
+		problem["retry_after"] = r.RetryAfterSeconds()
+	}
+	writeJSON(w, "application/problem+json", r.Status, problem)
+}
+
+func respondPlainText(w http.ResponseWriter, req *http.Request, r Rejection) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(r.Status)
+	w.Write([]byte(r.Message + "\n"))
+}
+
+func respondNegotiated(w http.ResponseWriter, req *http.Request, r Rejection) {
+	switch negotiate(req.Header.Get("Accept")) {
+	case "application/problem+json":
+		respondProblemJSON(w, req, r)
+	case "text/plain":
+		respondPlainText(w, req, r)
+	default:
+		respondJSON(w, req, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// negotiate returns the supported media type the client prefers according
+// to its Accept header, or "" when it has no preference.
+func negotiate(accept string) string {
+	supported := []string{"application/json", "application/problem+json", "text/plain"}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, s := range supported {
+			if s == mediaType && q > bestQ {
+				best, bestQ = s, q
+			}
+		}
+	}
+	return best
+}
+
+// WithResponder sets how the policy's rejections are written, overriding
+// content negotiation on the Accept header.
+func WithResponder(r Responder) Option {
+	return func(p *Policy) {
+		p.Responder = r
+	}
+}
+
+// WithRejectStatus replaces the 429 status code used for rejections.
+func WithRejectStatus(status int) Option {
+	return func(p *Policy) {
+		p.RejectStatus = status
+	}
+}
+
+// WithMessage sets the rejection message. It is a text/template that can use
+// {{.Limit}}, {{.Remaining}}, {{.RetryAfter}} (in seconds) and {{.Policy}}.
+// It panics if the template does not parse.
+func WithMessage(message string) Option {
+	tmpl := template.Must(template.New("message").Parse(message))
+	return func(p *Policy) {
+		p.message = tmpl
+	}
+}
+
+// newRejection builds the rejection for a denied request under policy,
+// rendering the policy's message template.
+func newRejection(policy Policy, d Decision) Rejection {
+	r := Rejection{
+		Policy:     policy.Name,
+		Status:     policy.RejectStatus,
+		Limit:      d.Limit,
+		Remaining:  d.Remaining,
+		RetryAfter: d.RetryAfter,
+		Message:    defaultRejectMessage,
+	}
+	if r.Status == 0 {
+		r.Status = http.StatusTooManyRequests
+	}
+	if policy.message != nil {
+		var buf bytes.Buffer
+		err := policy.message.Execute(&buf, map[string]any{
+			"Policy":     r.Policy,
+			"Limit":      r.Limit,
+			"Remaining":  r.Remaining,
+			"RetryAfter": r.RetryAfterSeconds(),
+		})
+		if err == nil {
+			r.Message = buf.String()
+		}
+	}
+	return r
+}
+
+// rejectRequest writes the rejection using the policy's responder, telling
+// the client how many seconds to wait before retrying.
+func rejectRequest(w http.ResponseWriter, req *http.Request, policy Policy, r Rejection) {
+	if r.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(r.RetryAfterSeconds()))
+	}
+
+	responder := policy.Responder
+	if responder == nil {
+		responder = NegotiatedResponder
+	}
+	responder.Respond(w, req, r)
+}
@@ -0,0 +1,133 @@
+// Package rls implements a standalone rate limit decision service, letting
+// services in any language reuse the server's limiters over HTTP or through
+// Envoy's RateLimitService gRPC API.
+package rls
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Config maps the descriptors of each domain to rate limits, following the
+// layout of Envoy's ratelimit service configuration.
+type Config struct {
+	Domains []DomainConfig `json:"domains"`
+	// PolicyDomain, when set, is a domain whose descriptors
+	// [{"key": "policy", "value": <name>}, {"key": "key", "value": <client>}]
+	// are decided by the policy of that name, see ratelimit.Lookup.
+	PolicyDomain string `json:"policy_domain,omitempty"`
+	// Options are applied to every limiter created for a rate_limit, such as
+	// the peers, forwarder and leases shared with the other policies.
+	Options []ratelimit.Option `json:"-"`
+}
+
+// DomainConfig holds the descriptor tree of one domain.
+type DomainConfig struct {
+	Domain      string             `json:"domain"`
+	Descriptors []DescriptorConfig `json:"descriptors"`
+}
+
+// DescriptorConfig matches a descriptor entry by key and, optionally, value.
+// Entries matched by key only get a separate counter for every value.
+// Policy, instead of RateLimit, decides matched descriptors with the policy
+// of that name, counting against the value of their last entry as the
+// routes limited by the policy count their clients.
+type DescriptorConfig struct {
+	Key         string             `json:"key"`
+	Value       string             `json:"value,omitempty"`
+	RateLimit   *LimitConfig       `json:"rate_limit,omitempty"`
+	Policy      string             `json:"policy,omitempty"`
+	Descriptors []DescriptorConfig `json:"descriptors,omitempty"`
+}
+
+// LimitConfig is the rate limit applied to a matched descriptor.
+type LimitConfig struct {
+	// Algorithm defaults to fixed_window like Envoy's service.
+	Algorithm       ratelimit.Algorithm `json:"algorithm,omitempty"`
+	RequestsPerUnit int                 `json:"requests_per_unit"`
+	Unit            Unit                `json:"unit"`
+	// Burst is the bucket size for the token_bucket algorithm.
+	Burst int `json:"burst,omitempty"`
+}
+
+// Unit is the time unit of a rate limit.
+type Unit string
+
+const (
+	UnitSecond Unit = "second"
+	UnitMinute Unit = "minute"
+	UnitHour   Unit = "hour"
+	UnitDay    Unit = "day"
+)
+
+var unitDurations = map[Unit]time.Duration{
+	UnitSecond: time.Second,
+	UnitMinute: time.Minute,
+	UnitHour:   time.Hour,
+	UnitDay:    24 * time.Hour,
+}
+
+// LoadConfig reads a JSON configuration file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error reading rate limit config: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing rate limit config: %v", err)
+	}
+	return cfg, nil
+}
+
+// newLimiter creates the limiter for a descriptor limit.
+func (c LimitConfig) newLimiter(name string, opts []ratelimit.Option) (*ratelimit.Limiter, error) {
+	window, ok := unitDurations[c.Unit]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown unit %q", name, c.Unit)
+	}
+	if c.RequestsPerUnit < 0 {
+		return nil, fmt.Errorf("%s: requests_per_unit must not be negative", name)
+	}
+
+	opts = append(append([]ratelimit.Option(nil), opts...), ratelimit.WithName(name))
+	switch c.Algorithm {
+	case "", ratelimit.AlgorithmFixedWindow:
+		return ratelimit.NewFixedWindow(c.RequestsPerUnit, window, opts...), nil
+	case ratelimit.AlgorithmSlidingWindow:
+		return ratelimit.NewSlidingWindow(c.RequestsPerUnit, window, opts...), nil
+	case ratelimit.AlgorithmTokenBucket:
+		if c.Unit != UnitSecond {
+			return nil, fmt.Errorf("%s: token_bucket limits must use the second unit", name)
+		}
+		burst := c.Burst
+		if burst <= 0 {
+			burst = c.RequestsPerUnit
+		}
+		return ratelimit.NewTokenBucket(c.RequestsPerUnit, burst, opts...), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown algorithm %q", name, c.Algorithm)
+	}
+}
+
+// policyLimit describes the limit of a policy in the shortest unit at least
+// as long as its window, token buckets refilling per second.
+func policyLimit(p ratelimit.Policy, limit int) *CurrentLimit {
+	current := &CurrentLimit{Name: p.Name, RequestsPerUnit: limit, Unit: UnitSecond}
+	if p.Algorithm == ratelimit.AlgorithmTokenBucket || p.Window <= 0 {
+		return current
+	}
+	for _, unit := range []Unit{UnitSecond, UnitMinute, UnitHour, UnitDay} {
+		current.Unit = unit
+		if unitDurations[unit] >= p.Window {
+			break
+		}
+	}
+	current.RequestsPerUnit = int(int64(limit) * int64(unitDurations[current.Unit]) / int64(p.Window))
+	return current
+}
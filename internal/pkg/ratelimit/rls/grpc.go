@@ -0,0 +1,107 @@
+package rls
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var envoyUnits = map[Unit]ratelimitv3.RateLimitResponse_RateLimit_Unit{
+	UnitSecond: ratelimitv3.RateLimitResponse_RateLimit_SECOND,
+	UnitMinute: ratelimitv3.RateLimitResponse_RateLimit_MINUTE,
+	UnitHour:   ratelimitv3.RateLimitResponse_RateLimit_HOUR,
+	UnitDay:    ratelimitv3.RateLimitResponse_RateLimit_DAY,
+}
+
+// envoyServer serves Envoy's RateLimitService API from a Service.
+type envoyServer struct {
+	ratelimitv3.UnimplementedRateLimitServiceServer
+	service *Service
+}
+
+// RegisterEnvoy registers s as Envoy's envoy.service.ratelimit.v3.RateLimitService
+// on a gRPC server, so Envoy's global rate limit filter can use it directly.
+func RegisterEnvoy(registrar grpc.ServiceRegistrar, s *Service) {
+	ratelimitv3.RegisterRateLimitServiceServer(registrar, &envoyServer{service: s})
+}
+
+// TokenAuth rejects unary RPCs without the "authorization: Bearer <token>"
+// metadata entry with codes.Unauthenticated. It panics on an empty token,
+// which would let every caller in.
+func TokenAuth(token string) grpc.UnaryServerInterceptor {
+	if token == "" {
+		panic("rls: token must not be empty")
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var provided string
+		if values := md.Get("authorization"); len(values) > 0 {
+			provided = strings.TrimPrefix(values[0], "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid service token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (e *envoyServer) ShouldRateLimit(ctx context.Context, in *ratelimitv3.RateLimitRequest) (*ratelimitv3.RateLimitResponse, error) {
+	req := Request{
+		Domain:      in.GetDomain(),
+		Descriptors: make([]Descriptor, 0, len(in.GetDescriptors())),
+		HitsAddend:  int(in.GetHitsAddend()),
+	}
+	for _, d := range in.GetDescriptors() {
+		descriptor := Descriptor{Entries: make([]Entry, 0, len(d.GetEntries()))}
+		for _, entry := range d.GetEntries() {
+			descriptor.Entries = append(descriptor.Entries, Entry{Key: entry.GetKey(), Value: entry.GetValue()})
+		}
+		req.Descriptors = append(req.Descriptors, descriptor)
+	}
+
+	resp, err := e.service.Check(ctx, req)
+	if errors.Is(err, ErrInvalidRequest) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	out := &ratelimitv3.RateLimitResponse{
+		OverallCode: envoyCode(resp.OverallCode),
+		Statuses:    make([]*ratelimitv3.RateLimitResponse_DescriptorStatus, 0, len(resp.Statuses)),
+	}
+	for _, s := range resp.Statuses {
+		ds := &ratelimitv3.RateLimitResponse_DescriptorStatus{
+			Code:           envoyCode(s.Code),
+			LimitRemaining: uint32(max(s.LimitRemaining, 0)),
+		}
+		if s.CurrentLimit != nil {
+			ds.CurrentLimit = &ratelimitv3.RateLimitResponse_RateLimit{
+				Name:            s.CurrentLimit.Name,
+				RequestsPerUnit: uint32(max(s.CurrentLimit.RequestsPerUnit, 0)),
+				Unit:            envoyUnits[s.CurrentLimit.Unit],
+			}
+		}
+		if s.DurationUntilReset > 0 {
+			ds.DurationUntilReset = durationpb.New(s.DurationUntilReset)
+		}
+		out.Statuses = append(out.Statuses, ds)
+	}
+	return out, nil
+}
+
+func envoyCode(c Code) ratelimitv3.RateLimitResponse_Code {
+	if c == CodeOverLimit {
+		return ratelimitv3.RateLimitResponse_OVER_LIMIT
+	}
+	return ratelimitv3.RateLimitResponse_OK
+}
@@ -0,0 +1,297 @@
+package rls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	commonv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+func newTestService(t *testing.T, domain string) *Service {
+	t.Helper()
+
+	s, err := New(Config{Domains: []DomainConfig{{
+		Domain: domain,
+		Descriptors: []DescriptorConfig{
+			{
+				Key:       "api_key",
+				RateLimit: &LimitConfig{RequestsPerUnit: 3, Unit: UnitMinute},
+				Descriptors: []DescriptorConfig{
+					{Key: "path", Value: "/upload", RateLimit: &LimitConfig{RequestsPerUnit: 1, Unit: UnitMinute}},
+				},
+			},
+			{Key: "api_key", Value: "vip", RateLimit: &LimitConfig{Algorithm: "token_bucket", RequestsPerUnit: 10, Unit: UnitSecond}},
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func descriptor(pairs ...string) Descriptor {
+	var d Descriptor
+	for i := 0; i+1 < len(pairs); i += 2 {
+		d.Entries = append(d.Entries, Entry{Key: pairs[i], Value: pairs[i+1]})
+	}
+	return d
+}
+
+func TestCheck(t *testing.T) {
+	s := newTestService(t, "check-test")
+	ctx := context.Background()
+
+	check := func(d Descriptor, hits int) Response {
+		t.Helper()
+		resp, err := s.Check(ctx, Request{Domain: "check-test", Descriptors: []Descriptor{d}, HitsAddend: hits})
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		return resp
+	}
+
+	// Key-only matches count every value separately
+	for i := 0; i < 3; i++ {
+		if resp := check(descriptor("api_key", "a"), 1); resp.OverallCode != CodeOK {
+			t.Fatalf("request %d for a: got %s want OK", i+1, resp.OverallCode)
+		}
+	}
+	resp := check(descriptor("api_key", "a"), 1)
+	if resp.OverallCode != CodeOverLimit {
+		t.Fatalf("fourth request for a: got %s want OVER_LIMIT", resp.OverallCode)
+	}
+	if st := resp.Statuses[0]; st.LimitRemaining != 0 || st.DurationUntilReset <= 0 || st.CurrentLimit.RequestsPerUnit != 3 {
+		t.Errorf("unexpected over limit status %+v", st)
+	}
+	if resp := check(descriptor("api_key", "b"), 1); resp.OverallCode != CodeOK {
+		t.Errorf("request for b: got %s want OK", resp.OverallCode)
+	}
+
+	// Hits are counted together
+	if resp := check(descriptor("api_key", "c"), 3); resp.OverallCode != CodeOK || resp.Statuses[0].LimitRemaining != 0 {
+		t.Errorf("three hits for c: got %+v", resp)
+	}
+
+	// Nested descriptors have their own limit
+	if resp := check(descriptor("api_key", "d", "path", "/upload"), 1); resp.OverallCode != CodeOK {
+		t.Errorf("first upload: got %s want OK", resp.OverallCode)
+	}
+	if resp := check(descriptor("api_key", "d", "path", "/upload"), 1); resp.OverallCode != CodeOverLimit {
+		t.Errorf("second upload: got %s want OVER_LIMIT", resp.OverallCode)
+	}
+
+	// Exact value matches win over key-only matches
+	if resp := check(descriptor("api_key", "vip"), 5); resp.Statuses[0].CurrentLimit.Unit != UnitSecond {
+		t.Errorf("vip key: got %+v want the per second limit", resp.Statuses[0].CurrentLimit)
+	}
+
+	// Unknown descriptors are not limited
+	resp = check(descriptor("user", "x"), 100)
+	if resp.OverallCode != CodeOK || resp.Statuses[0].CurrentLimit != nil {
+		t.Errorf("unknown descriptor: got %+v", resp)
+	}
+}
+
+func TestCheckOverallCode(t *testing.T) {
+	s := newTestService(t, "overall-test")
+	req := Request{
+		Domain: "overall-test",
+		Descriptors: []Descriptor{
+			descriptor("api_key", "a"),
+			descriptor("api_key", "a", "path", "/upload"),
+		},
+	}
+
+	resp, err := s.Check(context.Background(), req)
+	if err != nil || resp.OverallCode != CodeOK {
+		t.Fatalf("first check: got %+v, %v", resp, err)
+	}
+	resp, _ = s.Check(context.Background(), req)
+	if resp.OverallCode != CodeOverLimit {
+		t.Fatalf("second check: got %s want OVER_LIMIT", resp.OverallCode)
+	}
+	if resp.Statuses[0].Code != CodeOK || resp.Statuses[1].Code != CodeOverLimit {
+		t.Errorf("unexpected statuses %+v", resp.Statuses)
+	}
+
+	if _, err := s.Check(context.Background(), Request{Domain: "overall-test"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("request without descriptors: got %v want ErrInvalidRequest", err)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit LimitConfig
+	}{
+		{"unknown unit", LimitConfig{RequestsPerUnit: 1, Unit: "week"}},
+		{"unknown algorithm", LimitConfig{Algorithm: "leaky_bucket", RequestsPerUnit: 1, Unit: UnitSecond}},
+		{"token bucket per minute", LimitConfig{Algorithm: "token_bucket", RequestsPerUnit: 1, Unit: UnitMinute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := tt.limit
+			cfg := Config{Domains: []DomainConfig{{
+				Domain:      "invalid",
+				Descriptors: []DescriptorConfig{{Key: "k", RateLimit: &limit}},
+			}}}
+			if _, err := New(cfg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+
+	exclusive := Config{Domains: []DomainConfig{{
+		Domain:      "invalid",
+		Descriptors: []DescriptorConfig{{Key: "k", Policy: "default", RateLimit: &LimitConfig{RequestsPerUnit: 1, Unit: UnitSecond}}},
+	}}}
+	if _, err := New(exclusive); err == nil {
+		t.Errorf("rate_limit and policy: expected an error")
+	}
+	if _, err := New(Config{PolicyDomain: "policies", Domains: []DomainConfig{{Domain: "policies"}}}); err == nil {
+		t.Errorf("domain named like the policy domain: expected an error")
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	l := ratelimit.NewFixedWindow(3, time.Minute, ratelimit.WithName("rls-policy-test"))
+	s, err := New(Config{
+		PolicyDomain: "policies",
+		Domains: []DomainConfig{{
+			Domain:      "policy-test",
+			Descriptors: []DescriptorConfig{{Key: "user", Policy: "rls-policy-test"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	check := func(domain string, d Descriptor) DescriptorStatus {
+		t.Helper()
+		resp, err := s.Check(ctx, Request{Domain: domain, Descriptors: []Descriptor{d}})
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		return resp.Statuses[0]
+	}
+
+	// The service shares the counters of the routes limited by the policy
+	if d := l.Allow(ctx, "/test", "1.2.3.4"); !d.Allowed {
+		t.Fatalf("route request denied")
+	}
+	st := check("policies", descriptor("policy", "rls-policy-test", "key", "1.2.3.4"))
+	if st.Code != CodeOK || st.LimitRemaining != 1 {
+		t.Fatalf("policy domain: got %+v want OK with 1 remaining", st)
+	}
+	if want := (CurrentLimit{Name: "rls-policy-test", RequestsPerUnit: 3, Unit: UnitMinute}); *st.CurrentLimit != want {
+		t.Errorf("current limit: got %+v want %+v", *st.CurrentLimit, want)
+	}
+	if st := check("policy-test", descriptor("user", "1.2.3.4")); st.Code != CodeOK {
+		t.Fatalf("policy descriptor: got %s want OK", st.Code)
+	}
+	if d := l.Allow(ctx, "/test", "1.2.3.4"); d.Allowed {
+		t.Errorf("route request allowed over the limit")
+	}
+	if st := check("policies", descriptor("policy", "rls-policy-test", "key", "5.6.7.8")); st.Code != CodeOK {
+		t.Errorf("other client: got %s want OK", st.Code)
+	}
+
+	if st := check("policies", descriptor("policy", "no-such-policy", "key", "1.2.3.4")); st.Code != CodeOK || st.CurrentLimit != nil {
+		t.Errorf("unknown policy: got %+v want OK without a limit", st)
+	}
+}
+
+func TestPolicyLimit(t *testing.T) {
+	tests := []struct {
+		policy ratelimit.Policy
+		want   CurrentLimit
+	}{
+		{ratelimit.Policy{Algorithm: ratelimit.AlgorithmTokenBucket, Limit: 5}, CurrentLimit{RequestsPerUnit: 5, Unit: UnitSecond}},
+		{ratelimit.Policy{Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 60, Window: time.Minute}, CurrentLimit{RequestsPerUnit: 60, Unit: UnitMinute}},
+		{ratelimit.Policy{Algorithm: ratelimit.AlgorithmSlidingWindow, Limit: 10, Window: 30 * time.Second}, CurrentLimit{RequestsPerUnit: 20, Unit: UnitMinute}},
+		{ratelimit.Policy{Algorithm: ratelimit.AlgorithmFixedWindow, Limit: 7, Window: 7 * 24 * time.Hour}, CurrentLimit{RequestsPerUnit: 1, Unit: UnitDay}},
+	}
+
+	for _, tt := range tests {
+		if got := policyLimit(tt.policy, tt.policy.Limit); *got != tt.want {
+			t.Errorf("%s %d per %v: got %+v want %+v", tt.policy.Algorithm, tt.policy.Limit, tt.policy.Window, *got, tt.want)
+		}
+	}
+}
+
+func TestEnvoyShouldRateLimit(t *testing.T) {
+	s := newTestService(t, "envoy-test")
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(TokenAuth("secret")))
+	RegisterEnvoy(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := ratelimitv3.NewRateLimitServiceClient(conn)
+
+	req := &ratelimitv3.RateLimitRequest{
+		Domain: "envoy-test",
+		Descriptors: []*commonv3.RateLimitDescriptor{{
+			Entries: []*commonv3.RateLimitDescriptor_Entry{
+				{Key: "api_key", Value: "a"},
+				{Key: "path", Value: "/upload"},
+			},
+		}},
+	}
+
+	// Calls without the service token are rejected before they count
+	for _, md := range []metadata.MD{nil, metadata.Pairs("authorization", "Bearer wrong")} {
+		ctx := metadata.NewOutgoingContext(context.Background(), md)
+		if _, err := client.ShouldRateLimit(ctx, req); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("metadata %v: got %v want Unauthenticated", md, err)
+		}
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	resp, err := client.ShouldRateLimit(ctx, req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit: %v", err)
+	}
+	if resp.GetOverallCode() != ratelimitv3.RateLimitResponse_OK {
+		t.Fatalf("first request: got %s want OK", resp.GetOverallCode())
+	}
+	limit := resp.GetStatuses()[0].GetCurrentLimit()
+	if limit.GetRequestsPerUnit() != 1 || limit.GetUnit() != ratelimitv3.RateLimitResponse_RateLimit_MINUTE {
+		t.Errorf("unexpected current limit %v", limit)
+	}
+
+	resp, err = client.ShouldRateLimit(ctx, req)
+	if err != nil {
+		t.Fatalf("ShouldRateLimit: %v", err)
+	}
+	if resp.GetOverallCode() != ratelimitv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("second request: got %s want OVER_LIMIT", resp.GetOverallCode())
+	}
+	if resp.GetStatuses()[0].GetDurationUntilReset().AsDuration() <= 0 {
+		t.Errorf("expected a duration until reset")
+	}
+}
@@ -0,0 +1,261 @@
+package rls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// Code is the outcome of a check.
+type Code string
+
+const (
+	CodeOK        Code = "OK"
+	CodeOverLimit Code = "OVER_LIMIT"
+)
+
+// ErrInvalidRequest is returned for requests without a domain or descriptors.
+var ErrInvalidRequest = errors.New("invalid rate limit request")
+
+// Entry is one key/value pair of a descriptor.
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Descriptor is an ordered list of entries identifying what is limited,
+// e.g. [{"key": "api_key", "value": "abc"}, {"key": "path", "value": "/upload"}].
+type Descriptor struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Request asks whether hits requests matching each descriptor are allowed.
+type Request struct {
+	Domain      string       `json:"domain"`
+	Descriptors []Descriptor `json:"descriptors"`
+	// HitsAddend is the number of hits to count, 1 when zero.
+	HitsAddend int `json:"hits_addend,omitempty"`
+}
+
+// CurrentLimit describes the limit that applied to a descriptor.
+type CurrentLimit struct {
+	Name            string `json:"name"`
+	RequestsPerUnit int    `json:"requests_per_unit"`
+	Unit            Unit   `json:"unit"`
+}
+
+// DescriptorStatus is the decision for one descriptor. Descriptors without a
+// configured limit are always OK and have no CurrentLimit.
+type DescriptorStatus struct {
+	Code               Code          `json:"code"`
+	CurrentLimit       *CurrentLimit `json:"current_limit,omitempty"`
+	LimitRemaining     int           `json:"limit_remaining"`
+	DurationUntilReset time.Duration `json:"-"`
+	RetryAfter         time.Duration `json:"-"`
+}
+
+// MarshalJSON renders durations in a human readable form.
+func (s DescriptorStatus) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Code               Code          `json:"code"`
+		CurrentLimit       *CurrentLimit `json:"current_limit,omitempty"`
+		LimitRemaining     int           `json:"limit_remaining"`
+		DurationUntilReset string        `json:"duration_until_reset,omitempty"`
+		RetryAfter         string        `json:"retry_after,omitempty"`
+	}{
+		Code:           s.Code,
+		CurrentLimit:   s.CurrentLimit,
+		LimitRemaining: s.LimitRemaining,
+	}
+	if s.DurationUntilReset > 0 {
+		out.DurationUntilReset = s.DurationUntilReset.String()
+	}
+	if s.RetryAfter > 0 {
+		out.RetryAfter = s.RetryAfter.String()
+	}
+	return json.Marshal(out)
+}
+
+// Response holds the decision for each descriptor of a request, in order.
+// OverallCode is OVER_LIMIT when any descriptor is over its limit.
+type Response struct {
+	OverallCode Code               `json:"overall_code"`
+	Statuses    []DescriptorStatus `json:"statuses"`
+}
+
+// node is a descriptor configuration compiled into a lookup tree.
+type node struct {
+	limit    *LimitConfig
+	limiter  *ratelimit.Limiter
+	policy   string
+	name     string
+	children map[string]*node
+}
+
+// child finds the configuration matching entry, preferring an exact
+// key and value match over a key-only match.
+func (n *node) child(entry Entry) *node {
+	if c, ok := n.children[entry.Key+"="+entry.Value]; ok {
+		return c
+	}
+	return n.children[entry.Key]
+}
+
+// Service decides requests against the configured limits.
+type Service struct {
+	domains      map[string]*node
+	policyDomain string
+}
+
+// New compiles cfg and creates a limiter for every configured rate limit.
+func New(cfg Config) (*Service, error) {
+	s := &Service{domains: make(map[string]*node), policyDomain: cfg.PolicyDomain}
+
+	for _, domain := range cfg.Domains {
+		if domain.Domain == "" {
+			return nil, fmt.Errorf("rate limit config: domain name is required")
+		}
+		if _, exists := s.domains[domain.Domain]; exists || domain.Domain == cfg.PolicyDomain {
+			return nil, fmt.Errorf("rate limit config: duplicate domain %q", domain.Domain)
+		}
+		root := &node{name: "rls " + domain.Domain, children: make(map[string]*node)}
+		if err := compile(root, domain.Descriptors, cfg.Options); err != nil {
+			return nil, fmt.Errorf("rate limit config: %v", err)
+		}
+		s.domains[domain.Domain] = root
+	}
+	return s, nil
+}
+
+func compile(parent *node, descriptors []DescriptorConfig, opts []ratelimit.Option) error {
+	for _, d := range descriptors {
+		if d.Key == "" {
+			return fmt.Errorf("%s: descriptor key is required", parent.name)
+		}
+		match := d.Key
+		if d.Value != "" {
+			match += "=" + d.Value
+		}
+		if _, exists := parent.children[match]; exists {
+			return fmt.Errorf("%s: duplicate descriptor %q", parent.name, match)
+		}
+
+		n := &node{name: parent.name + " " + match, policy: d.Policy, children: make(map[string]*node)}
+		if d.RateLimit != nil {
+			if d.Policy != "" {
+				return fmt.Errorf("%s: rate_limit and policy are exclusive", n.name)
+			}
+			limiter, err := d.RateLimit.newLimiter(n.name, opts)
+			if err != nil {
+				return err
+			}
+			n.limit = d.RateLimit
+			n.limiter = limiter
+		}
+		if err := compile(n, d.Descriptors, opts); err != nil {
+			return err
+		}
+		parent.children[match] = n
+	}
+	return nil
+}
+
+// Check decides every descriptor of req, counting the hits against each
+// matching limit. Unknown domains, descriptors and policies are not limited.
+func (s *Service) Check(ctx context.Context, req Request) (Response, error) {
+	if req.Domain == "" || len(req.Descriptors) == 0 {
+		return Response{}, fmt.Errorf("%w: domain and descriptors are required", ErrInvalidRequest)
+	}
+	hits := req.HitsAddend
+	if hits <= 0 {
+		hits = 1
+	}
+
+	resp := Response{
+		OverallCode: CodeOK,
+		Statuses:    make([]DescriptorStatus, 0, len(req.Descriptors)),
+	}
+	for _, descriptor := range req.Descriptors {
+		status := s.check(ctx, req.Domain, descriptor, hits)
+		if status.Code == CodeOverLimit {
+			resp.OverallCode = CodeOverLimit
+		}
+		resp.Statuses = append(resp.Statuses, status)
+	}
+	return resp, nil
+}
+
+func (s *Service) check(ctx context.Context, domain string, descriptor Descriptor, hits int) DescriptorStatus {
+	entries := descriptor.Entries
+	if domain == s.policyDomain {
+		if len(entries) != 2 || entries[0].Key != "policy" || entries[1].Key != "key" {
+			return DescriptorStatus{Code: CodeOK}
+		}
+		return checkPolicy(ctx, domain, entries[0].Value, entries[1].Value, hits)
+	}
+
+	n := s.domains[domain]
+	if n == nil || len(entries) == 0 {
+		return DescriptorStatus{Code: CodeOK}
+	}
+
+	for _, entry := range entries {
+		if n = n.child(entry); n == nil {
+			return DescriptorStatus{Code: CodeOK}
+		}
+	}
+	if n.policy != "" {
+		return checkPolicy(ctx, domain, n.policy, entries[len(entries)-1].Value, hits)
+	}
+	if n.limiter == nil {
+		return DescriptorStatus{Code: CodeOK}
+	}
+
+	d := n.limiter.AllowN(ctx, "rls:"+domain, counterKey(domain, descriptor), hits)
+	return newStatus(d, &CurrentLimit{
+		Name:            n.name,
+		RequestsPerUnit: n.limit.RequestsPerUnit,
+		Unit:            n.limit.Unit,
+	})
+}
+
+// checkPolicy decides hits requests of the client identified by key with the
+// named policy, sharing its counters with the routes the policy limits.
+func checkPolicy(ctx context.Context, domain, policy, key string, hits int) DescriptorStatus {
+	l, ok := ratelimit.Lookup(policy)
+	if !ok || key == "" {
+		return DescriptorStatus{Code: CodeOK}
+	}
+	d := l.AllowN(ctx, "rls:"+domain, key, hits)
+	return newStatus(d, policyLimit(l.Policy(), d.Limit))
+}
+
+func newStatus(d ratelimit.Decision, limit *CurrentLimit) DescriptorStatus {
+	status := DescriptorStatus{
+		Code:               CodeOK,
+		CurrentLimit:       limit,
+		LimitRemaining:     d.Remaining,
+		DurationUntilReset: d.Reset,
+		RetryAfter:         d.RetryAfter,
+	}
+	if !d.Allowed {
+		status.Code = CodeOverLimit
+	}
+	return status
+}
+
+// counterKey identifies the counter of a descriptor, so every distinct value
+// of a key-only match is limited separately.
+func counterKey(domain string, descriptor Descriptor) string {
+	parts := make([]string, 0, len(descriptor.Entries)+1)
+	parts = append(parts, domain)
+	for _, e := range descriptor.Entries {
+		parts = append(parts, e.Key+"="+e.Value)
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type SlidingWindow struct {
+	requests []time.Time
+}
+
+func cleanOldRequests(requests []time.Time, cutoff time.Time) []time.Time {
+	validRequests := make([]time.Time, 0)
+	for _, req := range requests {
+		if req.After(cutoff) {
+			validRequests = append(validRequests, req)
+		}
+	}
+	return validRequests
+}
+
+func CheckSlidingWindowLimit(ip string, limit int, window time.Duration) bool {
+	now := time.Now()
+	return defaultState.checkSlidingWindow(now, "", ip, overrideLimit(ip, limit, now), window, 1, CheckAllow).allowed
+}
+
+// checkSlidingWindow records hits requests at now in the client's sliding
+// window under policy, as mode says. limit already holds the client's override.
+func (st *State) checkSlidingWindow(now time.Time, policy, ip string, limit int, window time.Duration, hits int, mode CheckMode) result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	cutoff := now.Add(-window)
+
+	if hits > limit && mode != CheckCharge {
+		return result{remaining: max(limit, 0), reset: window, retryAfter: window}
+	}
+
+	key := stateKey(policy, ip)
+	client, exists := st.slidingWindows.get(key, now)
+	if !exists && mode == CheckPeek {
+		return result{allowed: true, remaining: limit, reset: window}
+	}
+	if !exists {
+		client = &SlidingWindow{}
+		if tracked, allowed := st.slidingWindows.add(key, client, now, now); !tracked {
+			return untrackedResult(allowed, limit-hits, window)
+		}
+	}
+
+	client.requests = cleanOldRequests(client.requests, cutoff)
+
+	if len(client.requests)+hits > limit && mode != CheckCharge {
+		// The request that must expire before the hits fit in the window
+		oldest := client.requests[len(client.requests)+hits-limit-1]
+		newest := client.requests[len(client.requests)-1]
+		return result{
+			remaining:  max(limit-len(client.requests), 0),
+			reset:      newest.Add(window).Sub(now),
+			retryAfter: oldest.Add(window).Sub(now),
+		}
+	}
+
+	if mode == CheckPeek {
+		return result{allowed: true, remaining: limit - len(client.requests), reset: window}
+	}
+	for i := 0; i < hits; i++ {
+		client.requests = append(client.requests, now)
+	}
+	// The state expires once the newest request leaves the window
+	st.slidingWindows.expire(key, now.Add(window))
+	return result{allowed: true, remaining: max(limit-len(client.requests), 0), reset: window}
+}
+
+// NewSlidingWindow creates a limiter implementing a sliding window rate limiting algorithm.
+func NewSlidingWindow(limit int, window time.Duration, opts ...Option) *Limiter {
+	policy := newPolicy(Policy{Algorithm: AlgorithmSlidingWindow, Limit: limit, Window: window}, opts)
+
+	return newLimiter(policy, func(ctx context.Context, key string, limit, hits int, mode CheckMode) result {
+		if mode == CheckCharge {
+			return policy.state.checkSlidingWindow(policy.clock.Now(), policy.Name, key, limit, window, hits, mode)
+		}
+		return waitForCapacity(ctx, policy.clock, policy.MaxWait, func(now time.Time) result {
+			return policy.state.checkSlidingWindow(now, policy.Name, key, limit, window, hits, mode)
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// WriteSnapshot. ReadSnapshot refuses snapshots of any other version.
+// Version 2 keys the state of clients by policy.
+const SnapshotVersion = 2
+
+// Snapshot is the serialized state of every store, letting clients keep
+// their quota across restarts. Entries are listed from the least to the
+// most recently used so restoring them preserves the LRU order, and keyed by
+// the name of their policy and the client key.
+type Snapshot struct {
+	Version        int                  `json:"version"`
+	TakenAt        time.Time            `json:"taken_at"`
+	FixedWindows   []FixedWindowState   `json:"fixed_windows"`
+	SlidingWindows []SlidingWindowState `json:"sliding_windows"`
+	TokenBuckets   []TokenBucketState   `json:"token_buckets"`
+}
+
+// FixedWindowState is a client's fixed window in a snapshot.
+type FixedWindowState struct {
+	Key       string    `json:"key"`
+	Count     int       `json:"count"`
+	Reset     time.Time `json:"reset"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SlidingWindowState is a client's sliding window in a snapshot.
+type SlidingWindowState struct {
+	Key       string      `json:"key"`
+	Requests  []time.Time `json:"requests"`
+	LastSeen  time.Time   `json:"last_seen"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// TokenBucketState is a client's token bucket in a snapshot, with the tokens
+// it held when the snapshot was taken.
+type TokenBucketState struct {
+	Key       string    `json:"key"`
+	Rate      float64   `json:"rate"`
+	Burst     int       `json:"burst"`
+	Tokens    float64   `json:"tokens"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TakeSnapshot captures the state of every store at now.
+func TakeSnapshot(now time.Time) Snapshot {
+	mu.Lock()
+	defer mu.Unlock()
+
+	snap := Snapshot{Version: SnapshotVersion, TakenAt: now}
+	defaultState.fixedWindows.each(func(e *storeEntry[*FixedWindow]) {
+		snap.FixedWindows = append(snap.FixedWindows, FixedWindowState{
+			Key: e.key, Count: e.value.count, Reset: e.value.reset,
+			LastSeen: e.lastSeen, ExpiresAt: e.expiresAt,
+		})
+	})
+	defaultState.slidingWindows.each(func(e *storeEntry[*SlidingWindow]) {
+		snap.SlidingWindows = append(snap.SlidingWindows, SlidingWindowState{
+			Key: e.key, Requests: e.value.requests,
+			LastSeen: e.lastSeen, ExpiresAt: e.expiresAt,
+		})
+	})
+	defaultState.tokenBuckets.each(func(e *storeEntry[*TokenBucket]) {
+		limiter := e.value.limiter
+		snap.TokenBuckets = append(snap.TokenBuckets, TokenBucketState{
+			Key: e.key, Rate: float64(limiter.Limit()), Burst: limiter.Burst(), Tokens: limiter.TokensAt(now),
+			LastSeen: e.lastSeen, ExpiresAt: e.expiresAt,
+		})
+	})
+	return snap
+}
+
+// RestoreSnapshot loads the entries of snap that have not expired at now into
+// the stores and returns how many it restored. Keys already tracked keep
+// their current state.
+func RestoreSnapshot(snap Snapshot, now time.Time) (int, error) {
+	if snap.Version != SnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d, want %d", snap.Version, SnapshotVersion)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	restored := 0
+	for _, st := range snap.FixedWindows {
+		if !st.ExpiresAt.After(now) || !st.Reset.After(now) {
+			continue
+		}
+		if restoreEntry(defaultState.fixedWindows, st.Key, &FixedWindow{count: st.Count, reset: st.Reset}, st.LastSeen, st.ExpiresAt) {
+			restored++
+		}
+	}
+	for _, st := range snap.SlidingWindows {
+		if !st.ExpiresAt.After(now) {
+			continue
+		}
+		if restoreEntry(defaultState.slidingWindows, st.Key, &SlidingWindow{requests: st.Requests}, st.LastSeen, st.ExpiresAt) {
+			restored++
+		}
+	}
+	for _, st := range snap.TokenBuckets {
+		if !st.ExpiresAt.After(now) {
+			continue
+		}
+		// The bucket kept refilling while the server was down
+		limiter := restoreBucket(rate.Limit(st.Rate), st.Burst, st.Tokens, snap.TakenAt)
+		if restoreEntry(defaultState.tokenBuckets, st.Key, &TokenBucket{limiter: limiter}, st.LastSeen, st.ExpiresAt) {
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// restoreEntry adds a restored entry unless key is already tracked and
+// reports whether it did. The caller must hold mu.
+func restoreEntry[V any](s *store[V], key string, value V, lastSeen, expiresAt time.Time) bool {
+	if _, exists := s.entries[key]; exists {
+		return false
+	}
+	tracked, _ := s.add(key, value, lastSeen, expiresAt)
+	return tracked
+}
+
+// restoreBucket returns a bucket that held tokens at takenAt.
+func restoreBucket(limit rate.Limit, burst int, tokens float64, takenAt time.Time) *rate.Limiter {
+	limiter := rate.NewLimiter(limit, burst)
+	missing := float64(burst) - tokens
+	switch {
+	case burst <= 0 || missing <= 0 || limit <= 0 || limit == rate.Inf:
+		// Full or never limited. Buckets that never refill spend their
+		// burst itself, so the snapshot already holds what is left.
+	default:
+		// Empty the bucket just long enough before takenAt to refill to tokens
+		emptied := takenAt.Add(-time.Duration(tokens / float64(limit) * float64(time.Second)))
+		limiter.ReserveN(emptied, burst)
+	}
+	return limiter
+}
+
+// WriteSnapshot writes the state of every store at now to w as JSON.
+func WriteSnapshot(w io.Writer, now time.Time) error {
+	if err := json.NewEncoder(w).Encode(TakeSnapshot(now)); err != nil {
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	return nil
+}
+
+// ReadSnapshot restores the state written by WriteSnapshot, dropping entries
+// expired at now, and returns how many entries it restored.
+func ReadSnapshot(r io.Reader, now time.Time) (int, error) {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("error reading snapshot: %v", err)
+	}
+	return RestoreSnapshot(snap, now)
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	defaultState.checkFixedWindow(now, "", "snap-fixed", 3, time.Minute, 2, CheckAllow)
+	defaultState.checkSlidingWindow(now, "", "snap-sliding", 3, time.Minute, 3, CheckAllow)
+	bucket := defaultState.rateLimitAt(now, "", "snap-bucket", 1, 10)
+	bucket.ReserveN(now, 10)
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, now); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	// A restart wipes the stores
+	configureStores(t, StoreConfig{})
+	later := now.Add(5 * time.Second)
+	restored, err := ReadSnapshot(&buf, later)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if restored != 3 {
+		t.Errorf("restored %d entries, want 3", restored)
+	}
+
+	if r := defaultState.checkFixedWindow(later, "", "snap-fixed", 3, time.Minute, 1, CheckAllow); !r.allowed || r.remaining != 0 {
+		t.Errorf("fixed window: got allowed %v, remaining %d; want true, 0", r.allowed, r.remaining)
+	}
+	if r := defaultState.checkSlidingWindow(later, "", "snap-sliding", 3, time.Minute, 1, CheckAllow); r.allowed || r.retryAfter != 55*time.Second {
+		t.Errorf("sliding window: got allowed %v, retry after %v; want false, 55s", r.allowed, r.retryAfter)
+	}
+	// The empty bucket refilled during the 5s downtime
+	if tokens := defaultState.rateLimitAt(later, "", "snap-bucket", 1, 10).TokensAt(later); tokens != 5 {
+		t.Errorf("token bucket: got %v tokens want 5", tokens)
+	}
+}
+
+func TestSnapshotDropsExpired(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	defaultState.checkFixedWindow(now, "", "snap-short", 1, time.Second, 1, CheckAllow)
+	defaultState.checkFixedWindow(now, "", "snap-long", 1, time.Hour, 1, CheckAllow)
+	defaultState.checkSlidingWindow(now, "", "snap-sliding", 1, time.Second, 1, CheckAllow)
+	defaultState.rateLimitAt(now, "", "snap-bucket", 10, 10).ReserveN(now, 1)
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, now); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	configureStores(t, StoreConfig{})
+	restored, err := ReadSnapshot(&buf, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	mu.Lock()
+	_, long := defaultState.fixedWindows.entries[stateKey("", "snap-long")]
+	mu.Unlock()
+	if restored != 1 || !long {
+		t.Errorf("restored %d entries, snap-long %v; want 1, true", restored, long)
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	configureStores(t, StoreConfig{})
+
+	_, err := ReadSnapshot(strings.NewReader(`{"version": 1}`), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "unsupported snapshot version 1") {
+		t.Errorf("got error %v, want unsupported version", err)
+	}
+}
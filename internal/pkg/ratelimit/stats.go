@@ -0,0 +1,224 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+const (
+	// statsSlot is the resolution of the per-route time series.
+	statsSlot = 10 * time.Second
+	// statsSlots is how many slots are kept, i.e. ten minutes of history.
+	statsSlots = 60
+	// maxTrackedStatsKeys bounds the per-key counters kept for the dashboard.
+	maxTrackedStatsKeys = 4096
+)
+
+// OtherRoute collects the decisions of the routes beyond maxTrackedRoutes.
+const OtherRoute = "other"
+
+// maxTrackedRoutes bounds the routes given their own statistics, adaptive
+// limits and metric labels.
+var maxTrackedRoutes = 1024
+
+var (
+	routesMu      sync.Mutex
+	trackedRoutes = make(map[string]struct{})
+)
+
+// trackRoute returns route, or OtherRoute once maxTrackedRoutes other routes
+// are tracked, so the state kept per route stays bounded whatever the routes
+// the adapters report.
+func trackRoute(route string) string {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+
+	if _, ok := trackedRoutes[route]; ok {
+		return route
+	}
+	if len(trackedRoutes) >= maxTrackedRoutes {
+		return OtherRoute
+	}
+	trackedRoutes[route] = struct{}{}
+	return route
+}
+
+var decisionsTotal = metrics.NewCounterVec(
+	"ratelimit_decisions_total",
+	"Rate limit decisions by route, policy and outcome.",
+	"route", "policy", "decision",
+)
+
+var (
+	statsMu    sync.Mutex
+	routeStats = make(map[string]*routeCounter)
+	keyStats   = make(map[string]*KeyStats)
+)
+
+// Sample is the number of decisions made for a route during one time slot.
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Allowed int       `json:"allowed"`
+	Denied  int       `json:"denied"`
+}
+
+// RouteStats summarises the decisions made for a route and policy.
+type RouteStats struct {
+	Route   string   `json:"route"`
+	Policy  string   `json:"policy"`
+	Allowed uint64   `json:"allowed"`
+	Denied  uint64   `json:"denied"`
+	Series  []Sample `json:"series"`
+}
+
+// KeyStats summarises the decisions made for a single client key.
+type KeyStats struct {
+	Key        string    `json:"key"`
+	Allowed    uint64    `json:"allowed"`
+	Denied     uint64    `json:"denied"`
+	LastDenied time.Time `json:"last_denied,omitempty"`
+	lastSeen   time.Time
+}
+
+// StatsSnapshot is a point-in-time copy of the decision statistics.
+type StatsSnapshot struct {
+	GeneratedAt  time.Time    `json:"generated_at"`
+	SlotSeconds  int          `json:"slot_seconds"`
+	Routes       []RouteStats `json:"routes"`
+	TopThrottled []KeyStats   `json:"top_throttled"`
+}
+
+type routeCounter struct {
+	policy  string
+	allowed uint64
+	denied  uint64
+	slots   [statsSlots]Sample
+}
+
+// slot returns the sample for the slot containing now, recycling stale slots.
+func (r *routeCounter) slot(now time.Time) *Sample {
+	start := now.Truncate(statsSlot)
+	s := &r.slots[(start.Unix()/int64(statsSlot/time.Second))%statsSlots]
+	if !s.Time.Equal(start) {
+		*s = Sample{Time: start}
+	}
+	return s
+}
+
+// recordDecision updates the dashboard statistics for a rate limit decision
+// made at now.
+func recordDecision(route, policy, key string, allowed bool, now time.Time) {
+	if allowed {
+		decisionsTotal.Inc(route, policy, "allowed")
+	} else {
+		decisionsTotal.Inc(route, policy, "denied")
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	rc, exists := routeStats[route]
+	if !exists {
+		rc = &routeCounter{}
+		routeStats[route] = rc
+	}
+	rc.policy = policy
+	s := rc.slot(now)
+
+	ks, exists := keyStats[key]
+	if !exists {
+		if len(keyStats) >= maxTrackedStatsKeys {
+			pruneKeyStats(now)
+		}
+		ks = &KeyStats{Key: key}
+		keyStats[key] = ks
+	}
+	ks.lastSeen = now
+
+	if allowed {
+		rc.allowed++
+		s.Allowed++
+		ks.Allowed++
+		return
+	}
+	rc.denied++
+	s.Denied++
+	ks.Denied++
+	ks.LastDenied = now
+}
+
+// pruneKeyStats drops keys outside the series horizon and, if that is not
+// enough, the least throttled half. The caller must hold statsMu.
+func pruneKeyStats(now time.Time) {
+	horizon := now.Add(-statsSlot * statsSlots)
+	for key, ks := range keyStats {
+		if ks.lastSeen.Before(horizon) {
+			delete(keyStats, key)
+		}
+	}
+	if len(keyStats) < maxTrackedStatsKeys {
+		return
+	}
+
+	ranked := make([]*KeyStats, 0, len(keyStats))
+	for _, ks := range keyStats {
+		ranked = append(ranked, ks)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].Denied < ranked[j].Denied })
+	for _, ks := range ranked[:len(ranked)/2] {
+		delete(keyStats, ks.Key)
+	}
+}
+
+// Stats returns the per-route decision history and the topN most throttled keys.
+func Stats(topN int) StatsSnapshot {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	now := SystemClock.Now()
+	oldest := now.Truncate(statsSlot).Add(-statsSlot * (statsSlots - 1))
+
+	snapshot := StatsSnapshot{
+		GeneratedAt:  now,
+		SlotSeconds:  int(statsSlot / time.Second),
+		Routes:       make([]RouteStats, 0, len(routeStats)),
+		TopThrottled: make([]KeyStats, 0, topN),
+	}
+
+	for route, rc := range routeStats {
+		rs := RouteStats{
+			Route:   route,
+			Policy:  rc.policy,
+			Allowed: rc.allowed,
+			Denied:  rc.denied,
+			Series:  make([]Sample, 0, statsSlots),
+		}
+		for i := 0; i < statsSlots; i++ {
+			start := oldest.Add(time.Duration(i) * statsSlot)
+			s := rc.slots[(start.Unix()/int64(statsSlot/time.Second))%statsSlots]
+			if !s.Time.Equal(start) {
+				s = Sample{Time: start}
+			}
+			rs.Series = append(rs.Series, s)
+		}
+		snapshot.Routes = append(snapshot.Routes, rs)
+	}
+	sort.Slice(snapshot.Routes, func(i, j int) bool { return snapshot.Routes[i].Route < snapshot.Routes[j].Route })
+
+	for _, ks := range keyStats {
+		if ks.Denied > 0 {
+			snapshot.TopThrottled = append(snapshot.TopThrottled, *ks)
+		}
+	}
+	sort.Slice(snapshot.TopThrottled, func(i, j int) bool {
+		return snapshot.TopThrottled[i].Denied > snapshot.TopThrottled[j].Denied
+	})
+	if len(snapshot.TopThrottled) > topN {
+		snapshot.TopThrottled = snapshot.TopThrottled[:topN]
+	}
+
+	return snapshot
+}
@@ -0,0 +1,307 @@
+package ratelimit
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"time"
+
+	"api-rate-limiting/internal/pkg/metrics"
+)
+
+// Overflow selects what a full store does with a new key while it is under
+// extreme pressure, i.e. when even its least recently used key is active.
+type Overflow string
+
+const (
+	// OverflowEvict always evicts the least recently used key, resetting
+	// its state. This is the default.
+	OverflowEvict Overflow = "evict"
+	// OverflowFailOpen keeps the tracked keys and allows the new key's
+	// requests without tracking them.
+	OverflowFailOpen Overflow = "fail-open"
+	// OverflowFailClosed keeps the tracked keys and rejects the new key's
+	// requests until a tracked key goes idle.
+	OverflowFailClosed Overflow = "fail-closed"
+)
+
+// StoreConfig bounds the memory used by the rate limit state stores.
+type StoreConfig struct {
+	// MaxKeys is the number of keys each algorithm's store tracks before it
+	// evicts the least recently used one (default 100000).
+	MaxKeys int
+	// Overflow is the behavior under extreme pressure (default evict).
+	Overflow Overflow
+	// ActiveWindow is how recently the least recently used key must have
+	// been seen for the store to be under extreme pressure (default 10s).
+	ActiveWindow time.Duration
+}
+
+const defaultMaxKeys = 100000
+
+var (
+	storeKeys = metrics.NewGaugeVec(
+		"ratelimit_store_keys",
+		"Keys tracked by each rate limit state store.",
+		"store",
+	)
+	storeEvictions = metrics.NewCounterVec(
+		"ratelimit_store_evictions_total",
+		"Keys evicted from rate limit state stores by reason (expired or lru).",
+		"store", "reason",
+	)
+	storeOverflows = metrics.NewCounterVec(
+		"ratelimit_store_overflows_total",
+		"New keys a full store refused to track, by the decision taken (allowed or rejected).",
+		"store", "decision",
+	)
+)
+
+// janitorInterval is how often expired state is swept.
+const janitorInterval = time.Second
+
+// store holds per-key state in least recently used order, evicting the
+// least recently used key once it holds maxKeys. Every entry expires at a
+// time set by its algorithm, once its state no longer affects decisions.
+// Must be used with mu held.
+type store[V any] struct {
+	name    string
+	cfg     StoreConfig
+	entries map[string]*list.Element
+	// order lists entries from the most to the least recently used
+	order *list.List
+	// expiry orders entries by expiry time for the janitor
+	expiry expiryHeap[V]
+}
+
+type storeEntry[V any] struct {
+	key       string
+	value     V
+	lastSeen  time.Time
+	expiresAt time.Time
+	// index is the entry's position in the expiry heap
+	index int
+}
+
+// expiryHeap is a min-heap of entries by expiry time.
+type expiryHeap[V any] []*storeEntry[V]
+
+func (h expiryHeap[V]) Len() int           { return len(h) }
+func (h expiryHeap[V]) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap[V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[V]) Push(x any) {
+	e := x.(*storeEntry[V])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap[V]) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newStore[V any](name string) *store[V] {
+	s := &store[V]{
+		name:    name,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	s.configure(StoreConfig{})
+	return s
+}
+
+func (s *store[V]) configure(cfg StoreConfig) {
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultMaxKeys
+	}
+	if cfg.Overflow == "" {
+		cfg.Overflow = OverflowEvict
+	}
+	if cfg.ActiveWindow <= 0 {
+		cfg.ActiveWindow = 10 * time.Second
+	}
+	s.cfg = cfg
+
+	for s.order.Len() > cfg.MaxKeys {
+		s.remove(s.order.Back(), "lru")
+	}
+}
+
+// get returns the state of key, marking it as used at now.
+func (s *store[V]) get(key string, now time.Time) (V, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	entry := e.Value.(*storeEntry[V])
+	entry.lastSeen = now
+	s.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// expire sets when the state of key expires.
+func (s *store[V]) expire(key string, expiresAt time.Time) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*storeEntry[V])
+	entry.expiresAt = expiresAt
+	heap.Fix(&s.expiry, entry.index)
+}
+
+// add starts tracking key until expiresAt, making room by evicting the least recently used
+// key when the store is full. When the store is under extreme pressure and
+// its overflow policy is not to evict, the key is not tracked and add
+// returns the decision for its request instead.
+func (s *store[V]) add(key string, value V, now, expiresAt time.Time) (tracked bool, allowed bool) {
+	if s.order.Len() >= s.cfg.MaxKeys {
+		oldest := s.order.Back()
+		active := now.Sub(oldest.Value.(*storeEntry[V]).lastSeen) < s.cfg.ActiveWindow
+
+		switch {
+		case active && s.cfg.Overflow == OverflowFailOpen:
+			storeOverflows.Inc(s.name, "allowed")
+			return false, true
+		case active && s.cfg.Overflow == OverflowFailClosed:
+			storeOverflows.Inc(s.name, "rejected")
+			return false, false
+		}
+		s.remove(oldest, "lru")
+	}
+
+	entry := &storeEntry[V]{key: key, value: value, lastSeen: now, expiresAt: expiresAt}
+	s.entries[key] = s.order.PushFront(entry)
+	heap.Push(&s.expiry, entry)
+	storeKeys.Set(float64(s.order.Len()), s.name)
+	return true, true
+}
+
+// delete stops tracking key and reports whether it was tracked.
+func (s *store[V]) delete(key string) bool {
+	e, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	s.remove(e, "")
+	return true
+}
+
+// sweep removes the entries expired at now, visiting only those.
+func (s *store[V]) sweep(now time.Time) {
+	for len(s.expiry) > 0 && !s.expiry[0].expiresAt.After(now) {
+		s.remove(s.entries[s.expiry[0].key], "expired")
+	}
+}
+
+// remove drops an entry, counting it as an eviction when reason is set.
+func (s *store[V]) remove(e *list.Element, reason string) {
+	entry := e.Value.(*storeEntry[V])
+	delete(s.entries, entry.key)
+	s.order.Remove(e)
+	heap.Remove(&s.expiry, entry.index)
+	if reason != "" {
+		storeEvictions.Inc(s.name, reason)
+	}
+	storeKeys.Set(float64(s.order.Len()), s.name)
+}
+
+// each calls fn for every entry from the least to the most recently used.
+func (s *store[V]) each(fn func(entry *storeEntry[V])) {
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		fn(e.Value.(*storeEntry[V]))
+	}
+}
+
+func (s *store[V]) len() int {
+	return s.order.Len()
+}
+
+// untrackedResult is the decision for a request whose key a full store
+// refused to track.
+func untrackedResult(allowed bool, remaining int, window time.Duration) result {
+	if allowed {
+		return result{allowed: true, remaining: max(remaining, 0), reset: window}
+	}
+	return result{reset: window, retryAfter: time.Second}
+}
+
+// stateKey is the key of the state of a client under a policy. Policies
+// keep their state apart, while overrides and resets address the client.
+func stateKey(policy, key string) string {
+	return policy + "|" + key
+}
+
+// State holds the per-client state of every algorithm. Limiters share the
+// process-wide state unless given their own with WithState, e.g. to run
+// several independent replicas in one process.
+type State struct {
+	fixedWindows   *store[*FixedWindow]
+	slidingWindows *store[*SlidingWindow]
+	tokenBuckets   *store[*TokenBucket]
+}
+
+// NewState returns empty state with the default store bounds.
+func NewState() *State {
+	return &State{
+		fixedWindows:   newStore[*FixedWindow]("fixed_window"),
+		slidingWindows: newStore[*SlidingWindow]("sliding_window"),
+		tokenBuckets:   newStore[*TokenBucket]("token_bucket"),
+	}
+}
+
+// WithState makes the limiter keep its client state in st instead of the
+// process-wide state.
+func WithState(st *State) Option {
+	return func(p *Policy) {
+		p.state = st
+	}
+}
+
+// Configure applies cfg to the store of every algorithm, evicting keys right
+// away if the new cap is lower than the tracked keys.
+func (st *State) Configure(cfg StoreConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	st.fixedWindows.configure(cfg)
+	st.slidingWindows.configure(cfg)
+	st.tokenBuckets.configure(cfg)
+}
+
+// RunJanitor removes expired state from every store until ctx is done,
+// reading the time from clock.
+func (st *State) RunJanitor(ctx context.Context, clock Clock) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-clock.After(janitorInterval):
+			mu.Lock()
+			st.fixedWindows.sweep(now)
+			st.slidingWindows.sweep(now)
+			st.tokenBuckets.sweep(now)
+			mu.Unlock()
+		}
+	}
+}
+
+// RunJanitor runs the janitor of the process-wide state.
+func RunJanitor(ctx context.Context, clock Clock) {
+	defaultState.RunJanitor(ctx, clock)
+}
+
+// ConfigureStores applies cfg to the process-wide state.
+func ConfigureStores(cfg StoreConfig) {
+	defaultState.Configure(cfg)
+}
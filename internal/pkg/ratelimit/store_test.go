@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// configureStores starts a test with empty stores configured with cfg.
+func configureStores(t *testing.T, cfg StoreConfig) {
+	t.Helper()
+
+	mu.Lock()
+	defaultState.fixedWindows = newStore[*FixedWindow]("fixed_window")
+	defaultState.slidingWindows = newStore[*SlidingWindow]("sliding_window")
+	defaultState.tokenBuckets = newStore[*TokenBucket]("token_bucket")
+	mu.Unlock()
+
+	ConfigureStores(cfg)
+	t.Cleanup(func() { ConfigureStores(StoreConfig{}) })
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	configureStores(t, StoreConfig{MaxKeys: 3})
+	evictions := storeEvictions.Value("fixed_window", "lru")
+
+	for i := 0; i < 3; i++ {
+		CheckFixedWindowLimit(fmt.Sprintf("lru-%d", i), 1, time.Minute)
+	}
+	// Using lru-0 again makes lru-1 the least recently used key
+	if CheckFixedWindowLimit("lru-0", 1, time.Minute) {
+		t.Fatalf("lru-0 should be over its limit")
+	}
+	CheckFixedWindowLimit("lru-3", 1, time.Minute)
+
+	mu.Lock()
+	_, kept := defaultState.fixedWindows.entries[stateKey("", "lru-0")]
+	_, evicted := defaultState.fixedWindows.entries[stateKey("", "lru-1")]
+	size := defaultState.fixedWindows.len()
+	mu.Unlock()
+
+	if !kept || evicted || size != 3 {
+		t.Errorf("got lru-0 tracked %v, lru-1 tracked %v, %d keys; want true, false, 3", kept, evicted, size)
+	}
+	if got := storeEvictions.Value("fixed_window", "lru") - evictions; got != 1 {
+		t.Errorf("lru evictions: got %v want 1", got)
+	}
+	// The evicted key starts over with a fresh window
+	if !CheckFixedWindowLimit("lru-1", 1, time.Minute) {
+		t.Errorf("evicted key lru-1 should be allowed again")
+	}
+}
+
+func TestStoreOverflow(t *testing.T) {
+	tests := []struct {
+		overflow Overflow
+		allowed  bool
+	}{
+		{OverflowFailOpen, true},
+		{OverflowFailClosed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.overflow), func(t *testing.T) {
+			configureStores(t, StoreConfig{MaxKeys: 2, Overflow: tt.overflow, ActiveWindow: time.Minute})
+
+			checks := map[string]func(key string) bool{
+				"fixed_window": func(key string) bool { return CheckFixedWindowLimit(key, 1, time.Minute) },
+				"sliding_window": func(key string) bool {
+					return CheckSlidingWindowLimit(key, 1, time.Minute)
+				},
+				"token_bucket": func(key string) bool { return RateLimit(key, 1, 1).Allow() },
+			}
+			for name, check := range checks {
+				prefix := fmt.Sprintf("%s-%s-", tt.overflow, name)
+				check(prefix + "a")
+				check(prefix + "b")
+
+				if got := check(prefix + "c"); got != tt.allowed {
+					t.Errorf("%s: new key under pressure allowed %v, want %v", name, got, tt.allowed)
+				}
+				// The tracked keys keep their state
+				if check(prefix + "a") {
+					t.Errorf("%s: tracked key should still be over its limit", name)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreSweepsExpired(t *testing.T) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := newStore[int]("sweep-test")
+	now := time.Now()
+	s.add("expired", 1, now, now.Add(-time.Second))
+	s.add("later", 2, now, now.Add(time.Hour))
+	s.add("soon", 3, now, now.Add(time.Minute))
+	s.expire("later", now.Add(30*time.Second))
+
+	s.sweep(now.Add(45 * time.Second))
+	if s.len() != 1 {
+		t.Fatalf("got %d keys want 1", s.len())
+	}
+	if _, ok := s.entries["soon"]; !ok {
+		t.Errorf("soon should still be tracked")
+	}
+}
+
+func TestStateExpiryFollowsPolicy(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	now := time.Now()
+
+	// An hourly window survives a client pausing for longer than a minute
+	CheckFixedWindowLimit("expiry-fixed", 1, time.Hour)
+	CheckSlidingWindowLimit("expiry-sliding", 1, time.Hour)
+	limiter := NewTokenBucket(1, 120, WithName("expiry-test"))
+	limiter.AllowN(context.Background(), "expiry-test", "expiry-bucket", 60)
+
+	mu.Lock()
+	defaultState.fixedWindows.sweep(now.Add(2 * time.Minute))
+	defaultState.slidingWindows.sweep(now.Add(2 * time.Minute))
+	defaultState.tokenBuckets.sweep(now.Add(50 * time.Second))
+	_, fixed := defaultState.fixedWindows.entries[stateKey("", "expiry-fixed")]
+	_, sliding := defaultState.slidingWindows.entries[stateKey("", "expiry-sliding")]
+	_, bucket := defaultState.tokenBuckets.entries[stateKey("expiry-test", "expiry-bucket")]
+	mu.Unlock()
+
+	if !fixed || !sliding || !bucket {
+		t.Fatalf("state swept early: fixed %v, sliding %v, bucket %v", fixed, sliding, bucket)
+	}
+
+	// And is dropped once it no longer affects decisions: the bucket is
+	// full again 60s after losing 60 tokens at 1/s
+	mu.Lock()
+	defaultState.fixedWindows.sweep(now.Add(time.Hour + time.Second))
+	defaultState.slidingWindows.sweep(now.Add(time.Hour + time.Second))
+	defaultState.tokenBuckets.sweep(now.Add(61 * time.Second))
+	sizes := []int{defaultState.fixedWindows.len(), defaultState.slidingWindows.len(), defaultState.tokenBuckets.len()}
+	mu.Unlock()
+
+	for i, n := range sizes {
+		if n != 0 {
+			t.Errorf("store %d: got %d keys after expiry, want 0", i, n)
+		}
+	}
+}
+
+func TestStateKeptApartByPolicy(t *testing.T) {
+	configureStores(t, StoreConfig{})
+	ctx := context.Background()
+
+	// Policies of the same algorithm limiting the same client count apart
+	busy := NewSlidingWindow(60, time.Minute, WithName("apart-busy"))
+	strict := NewSlidingWindow(5, 30*time.Second, WithName("apart-strict"))
+	for i := 0; i < 5; i++ {
+		busy.Allow(ctx, "apart", "apart-client")
+	}
+	if d := strict.Allow(ctx, "apart", "apart-client"); !d.Allowed || d.Remaining != 4 {
+		t.Errorf("sliding: got allowed %v, remaining %d; want true, 4", d.Allowed, d.Remaining)
+	}
+
+	// and a short window does not reset a long one
+	hourly := NewFixedWindow(100, time.Hour, WithName("apart-hourly"))
+	NewFixedWindow(1, time.Second, WithName("apart-second")).Allow(ctx, "apart", "apart-client")
+	if d := hourly.Allow(ctx, "apart", "apart-client"); d.Reset != time.Hour {
+		t.Errorf("fixed: got reset %v want 1h", d.Reset)
+	}
+
+	// Resets address the client under every policy
+	if !ResetKey("apart-client") {
+		t.Fatalf("ResetKey found no state")
+	}
+	mu.Lock()
+	sizes := []int{defaultState.fixedWindows.len(), defaultState.slidingWindows.len()}
+	mu.Unlock()
+	if sizes[0] != 0 || sizes[1] != 0 {
+		t.Errorf("got %v keys after the reset, want none", sizes)
+	}
+}
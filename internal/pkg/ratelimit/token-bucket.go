@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type TokenBucket struct {
+	limiter *rate.Limiter
+}
+
+func RateLimit(ip string, rateLimit, burst int) *rate.Limiter {
+	return defaultState.rateLimitAt(time.Now(), "", ip, rateLimit, burst)
+}
+
+// rateLimitAt returns the client's bucket under policy as of now, creating
+// it if needed.
+func (st *State) rateLimitAt(now time.Time, policy, ip string, rateLimit, burst int) *rate.Limiter {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Overrides replace the bucket size for this client
+	burst = effectiveLimit(ip, burst, now)
+	key := stateKey(policy, ip)
+
+	if bucket, exists := st.tokenBuckets.get(key, now); exists {
+		if bucket.limiter.Burst() != burst {
+			bucket.limiter.SetBurstAt(now, burst)
+		}
+		// Adaptive policies change the refill rate over time
+		if bucket.limiter.Limit() != rate.Limit(rateLimit) {
+			bucket.limiter.SetLimitAt(now, rate.Limit(rateLimit))
+		}
+		// Keep the bucket until it could be full again, even if drained now
+		st.tokenBuckets.expire(key, bucketExpiry(bucket.limiter, now, 0))
+		return bucket.limiter
+	}
+
+	// Create new bucket if it doesn't exist
+	newBucket := &TokenBucket{limiter: rate.NewLimiter(rate.Limit(rateLimit), burst)}
+	if tracked, allowed := st.tokenBuckets.add(key, newBucket, now, bucketExpiry(newBucket.limiter, now, 0)); !tracked && !allowed {
+		// A bucket that never holds a token rejects the request
+		return rate.NewLimiter(0, 0)
+	}
+	return newBucket.limiter
+}
+
+// bucketExpiry returns when a bucket holding tokens at now is full again and
+// can be forgotten. Buckets that never refill are kept for a minute.
+func bucketExpiry(limiter *rate.Limiter, now time.Time, tokens float64) time.Time {
+	switch {
+	case limiter.Limit() == rate.Inf:
+		return now
+	case limiter.Limit() <= 0:
+		return now.Add(time.Minute)
+	}
+	missing := max(float64(limiter.Burst())-tokens, 0)
+	return now.Add(time.Duration(missing / float64(limiter.Limit()) * float64(time.Second)))
+}
+
+// expireTokenBucket sets the expiry of the bucket of key under policy from
+// its current tokens, once a request took them.
+func (st *State) expireTokenBucket(now time.Time, policy, key string, limiter *rate.Limiter) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key = stateKey(policy, key)
+	if bucket, exists := st.tokenBuckets.get(key, now); exists && bucket.limiter == limiter {
+		st.tokenBuckets.expire(key, bucketExpiry(limiter, now, limiter.TokensAt(now)))
+	}
+}
+
+// reserveTokens takes hits tokens from the limiter as mode says, waiting up
+// to maxWait for them to become available. Charges take them at once, leaving
+// the bucket in debt when it holds too few.
+func reserveTokens(ctx context.Context, clock Clock, limiter *rate.Limiter, hits int, mode CheckMode, maxWait time.Duration) result {
+	now := clock.Now()
+	burst := limiter.Burst()
+	refill := func(tokens float64) time.Duration {
+		// Time until the bucket is full again
+		if limiter.Limit() <= 0 || limiter.Limit() == rate.Inf {
+			return 0
+		}
+		return time.Duration((float64(burst) - tokens) / float64(limiter.Limit()) * float64(time.Second))
+	}
+
+	if mode == CheckPeek {
+		tokens := limiter.TokensAt(now)
+		switch {
+		case tokens >= 1:
+			return result{allowed: true, remaining: int(tokens), reset: refill(tokens)}
+		case limiter.Burst() < 1 || limiter.Limit() <= 0:
+			// The bucket never gets a token, e.g. the key is blocked by an override
+			return result{reset: refill(tokens), retryAfter: time.Second}
+		}
+		return result{reset: refill(tokens), retryAfter: time.Duration((1 - tokens) / float64(limiter.Limit()) * float64(time.Second))}
+	}
+
+	reservation := limiter.ReserveN(now, hits)
+	if mode == CheckCharge {
+		tokens := limiter.TokensAt(now)
+		return result{allowed: true, remaining: max(int(tokens), 0), reset: refill(tokens)}
+	}
+	if !reservation.OK() {
+		// The bucket can never hold that many tokens, e.g. the key is blocked by an override
+		tokens := limiter.TokensAt(now)
+		return result{remaining: max(int(tokens), 0), reset: refill(tokens), retryAfter: time.Second}
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay > maxWait {
+		reservation.CancelAt(now)
+		tokens := limiter.TokensAt(now)
+		return result{remaining: max(int(tokens), 0), reset: refill(tokens), retryAfter: delay}
+	}
+
+	if delay > 0 && !sleepContext(ctx, clock, delay) {
+		cancelled := clock.Now()
+		reservation.CancelAt(cancelled)
+		return result{reset: refill(0), retryAfter: delay - cancelled.Sub(now)}
+	}
+
+	tokens := limiter.TokensAt(now.Add(delay))
+	return result{allowed: true, remaining: max(int(tokens), 0), reset: refill(tokens)}
+}
+
+// NewTokenBucket creates a limiter implementing a token bucket rate limiting algorithm.
+func NewTokenBucket(rateLimit, burst int, opts ...Option) *Limiter {
+	policy := newPolicy(Policy{Algorithm: AlgorithmTokenBucket, Limit: rateLimit, Burst: burst}, opts)
+
+	return newLimiter(policy, func(ctx context.Context, key string, limit, hits int, mode CheckMode) result {
+		limiter := policy.state.rateLimitAt(policy.clock.Now(), policy.Name, key, limit, burst)
+		r := reserveTokens(ctx, policy.clock, limiter, hits, mode, policy.MaxWait)
+		policy.state.expireTokenBucket(policy.clock.Now(), policy.Name, key, limiter)
+		return r
+	})
+}
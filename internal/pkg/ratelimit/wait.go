@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// waitForCapacity runs check until it admits the request, sleeping for the
+// reported retry delay in between as long as the total wait stays within
+// maxWait. It gives up early when ctx is cancelled. A zero maxWait checks once.
+func waitForCapacity(ctx context.Context, clock Clock, maxWait time.Duration, check func(now time.Time) result) result {
+	deadline := clock.Now().Add(maxWait)
+
+	for {
+		now := clock.Now()
+		res := check(now)
+		if res.allowed || maxWait <= 0 || now.Add(res.retryAfter).After(deadline) {
+			return res
+		}
+		if !sleepContext(ctx, clock, res.retryAfter) {
+			return res
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+func TestAdaptiveLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := &Server{}
+	r := gin.New()
+	s.registerAdminRoutes(r)
+
+	failing := true
+	r.GET("/backend", middleware.FixedWindowMiddleware(10, time.Minute,
+		ratelimit.WithName("adaptive-test"),
+		ratelimit.WithAdaptive(ratelimit.AdaptiveConfig{
+			Floor:      2,
+			Interval:   time.Nanosecond,
+			MinSamples: 1,
+		}),
+	), func(c *gin.Context) {
+		if failing {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	effectiveLimit := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, adminRequest("GET", "/admin/adaptive", nil))
+		var resp struct {
+			Limits []ratelimit.AdaptiveStatus `json:"limits"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		for _, l := range resp.Limits {
+			if l.Policy == "adaptive-test" {
+				return l.EffectiveLimit
+			}
+		}
+		t.Fatalf("adaptive-test policy not reported: %+v", resp.Limits)
+		return 0
+	}
+
+	request := func(i int) {
+		req := httptest.NewRequest("GET", "/backend", nil)
+		req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", i)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Each failing interval halves the limit until it reaches the floor
+	for i, want := range []int{5, 2, 2} {
+		request(i)
+		if got := effectiveLimit(); got != want {
+			t.Fatalf("after failing request %d: effective limit %d, want %d", i+1, got, want)
+		}
+	}
+
+	// Healthy intervals raise it additively
+	failing = false
+	for i, want := range []int{3, 4} {
+		request(10 + i)
+		if got := effectiveLimit(); got != want {
+			t.Fatalf("after healthy request %d: effective limit %d, want %d", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// OverrideRequest represents the request body for overriding a key's limit
+type OverrideRequest struct {
+	Limit *int   `json:"limit" binding:"required"`
+	TTL   string `json:"ttl"`
+}
+
+// adminAuth protects the admin API with the ADMIN_TOKEN bearer token.
+// When ADMIN_TOKEN is unset the admin API is disabled.
+func adminAuth() gin.HandlerFunc {
+	return bearerAuth(os.Getenv("ADMIN_TOKEN"), "the admin API is disabled", "invalid admin token")
+}
+
+// bearerAuth rejects requests without the given bearer token. An empty
+// token disables the routes, answering 404 with the disabled message.
+func bearerAuth(token, disabled, message string) gin.HandlerFunc {
+	if token == "" {
+		return func(c *gin.Context) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": disabled})
+		}
+	}
+	return func(c *gin.Context) {
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+			return
+		}
+		c.Next()
+	}
+}
+
+// registerAdminRoutes mounts the admin API under /admin
+func (s *Server) registerAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", adminAuth())
+
+	admin.GET("/policies", s.listPoliciesHandler)
+	admin.GET("/stats", s.statsHandler)
+	admin.GET("/overrides", s.listOverridesHandler)
+	admin.GET("/admission", s.admissionHandler)
+	admin.GET("/adaptive", s.adaptiveLimitsHandler)
+	admin.GET("/cluster", s.clusterHandler)
+	admin.PUT("/cluster/members", s.setClusterMembersHandler)
+	admin.GET("/plans", s.listPlansHandler)
+	admin.PUT("/plans/:name", s.savePlanHandler)
+	admin.GET("/customers/:id", s.getCustomerHandler)
+	admin.PUT("/customers/:id", s.saveCustomerHandler)
+	admin.GET("/customers/:id/api-keys", s.listKeysHandler)
+	admin.POST("/customers/:id/api-keys", s.createKeyHandler)
+	admin.POST("/api-keys/:id/rotate", s.rotateKeyHandler)
+	admin.DELETE("/api-keys/:id", s.revokeKeyHandler)
+	admin.GET("/usage/export", s.exportUsageHandler)
+	admin.DELETE("/keys/:key", s.resetKeyHandler)
+	admin.PUT("/keys/:key/override", s.setOverrideHandler)
+	admin.DELETE("/keys/:key/override", s.removeOverrideHandler)
+}
+
+func (s *Server) listPoliciesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"policies": ratelimit.Policies()})
+}
+
+func (s *Server) statsHandler(c *gin.Context) {
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil || top < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "top must be a non-negative integer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ratelimit.Stats(top))
+}
+
+func (s *Server) adaptiveLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"limits": ratelimit.AdaptiveLimits()})
+}
+
+func (s *Server) listOverridesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"overrides": ratelimit.Overrides()})
+}
+
+func (s *Server) resetKeyHandler(c *gin.Context) {
+	key := c.Param("key")
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":   key,
+		"reset": ratelimit.ResetKey(key),
+	})
+}
+
+func (s *Server) setOverrideHandler(c *gin.Context) {
+	var request OverrideRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if *request.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must not be negative"})
+		return
+	}
+
+	var ttl time.Duration
+	if request.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration such as 10m"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, ratelimit.SetOverride(c.Param("key"), *request.Limit, ttl))
+}
+
+func (s *Server) removeOverrideHandler(c *gin.Context) {
+	key := c.Param("key")
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":     key,
+		"removed": ratelimit.RemoveOverride(key),
+	})
+}
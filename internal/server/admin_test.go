@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// adminRequest returns a request to the admin API carrying the token the
+// tests set as ADMIN_TOKEN.
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func newAdminRouter(s *Server) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	s.registerAdminRoutes(r)
+	r.GET("/limited", middleware.FixedWindowMiddleware(1, time.Minute), s.TestHandler("Fixed Window"))
+	return r
+}
+
+func TestAdminResetAndOverride(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := &Server{}
+	r := newAdminRouter(s)
+
+	get := func() int {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = "198.51.100.10:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("first request: got %d want %d", code, http.StatusOK)
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("second request: got %d want %d", code, http.StatusTooManyRequests)
+	}
+
+	// Resetting the key gives the client a fresh window
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("DELETE", "/admin/keys/198.51.100.10", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: got %d want %d", w.Code, http.StatusOK)
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("request after reset: got %d want %d", code, http.StatusOK)
+	}
+
+	// Overrides need a positive ttl, or none to keep them
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("PUT", "/admin/keys/198.51.100.10/override", bytes.NewBufferString(`{"limit": 3, "ttl": "0s"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("zero ttl: got %d want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Overriding the limit lets more requests through in the same window
+	body, _ := json.Marshal(map[string]interface{}{"limit": 3, "ttl": "1m"})
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("PUT", "/admin/keys/198.51.100.10/override", bytes.NewBuffer(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("override: got %d want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("request after override: got %d want %d", code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("DELETE", "/admin/keys/198.51.100.10/override", nil))
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("request after clearing override: got %d want %d", code, http.StatusTooManyRequests)
+	}
+
+	// The throttled key shows up in the stats
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("GET", "/admin/stats", nil))
+	var stats ratelimit.StatsSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to unmarshal stats: %v", err)
+	}
+	found := false
+	for _, k := range stats.TopThrottled {
+		if k.Key == "198.51.100.10" && k.Denied >= 2 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected 198.51.100.10 in top throttled keys, got %+v", stats.TopThrottled)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s := &Server{}
+	r := newAdminRouter(s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/policies", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: got %d want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("GET", "/admin/policies", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("with token: got %d want %d", w.Code, http.StatusOK)
+	}
+
+	// Without a token configured the admin API is off rather than open
+	t.Setenv("ADMIN_TOKEN", "")
+	r = newAdminRouter(s)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, adminRequest("GET", "/admin/policies", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without ADMIN_TOKEN: got %d want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	s := &Server{}
+	r := gin.New()
+	s.registerDashboardRoutes(r)
+
+	for _, path := range []string{"/", "/assets/app.js", "/assets/style.css"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d want %d", path, w.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/middleware"
+)
+
+// loadAdmissionConfig reads the admission controller settings from the
+// environment. Plan priorities apply when plans are enabled.
+//
+//	ADMISSION_MAX_IN_FLIGHT          concurrent requests the instance can handle (default 256)
+//	ADMISSION_LATENCY_THRESHOLD      average latency that starts shedding (default 2s)
+//	ADMISSION_CUSTOMER_PRIORITIES    customer classes, e.g. "acme=high,globex=normal"
+//	ADMISSION_PLAN_PRIORITIES        plan classes, e.g. "pro=high,free=normal"
+//	ADMISSION_ROUTE_PRIORITIES       route classes, e.g. "/v1/usage=critical"
+func (s *Server) loadAdmissionConfig() middleware.AdmissionConfig {
+	cfg := middleware.AdmissionConfig{
+		MaxInFlight:       envInt("ADMISSION_MAX_IN_FLIGHT", 256),
+		LatencyThreshold:  envDuration("ADMISSION_LATENCY_THRESHOLD", 2*time.Second),
+		Plans:             s.plans,
+		CustomerPriority:  middleware.PriorityNormal,
+		AnonymousPriority: middleware.PriorityLow,
+	}
+
+	if v := os.Getenv("ADMISSION_CUSTOMER_PRIORITIES"); v != "" {
+		cfg.CustomerPriorities = parsePriorities("ADMISSION_CUSTOMER_PRIORITIES", v)
+	}
+	if v := os.Getenv("ADMISSION_PLAN_PRIORITIES"); v != "" {
+		cfg.PlanPriorities = parsePriorities("ADMISSION_PLAN_PRIORITIES", v)
+	}
+	if v := os.Getenv("ADMISSION_ROUTE_PRIORITIES"); v != "" {
+		cfg.RoutePriorities = parsePriorities("ADMISSION_ROUTE_PRIORITIES", v)
+	}
+
+	return cfg
+}
+
+// parsePriorities parses a comma separated list of name=class pairs.
+func parsePriorities(env, value string) map[string]middleware.Priority {
+	priorities := make(map[string]middleware.Priority)
+	for _, pair := range strings.Split(value, ",") {
+		name, class, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Printf("ignoring invalid %s entry %q", env, pair)
+			continue
+		}
+		p, err := middleware.ParsePriority(class)
+		if err != nil {
+			log.Printf("ignoring invalid %s entry %q: %v", env, pair, err)
+			continue
+		}
+		priorities[strings.TrimSpace(name)] = p
+	}
+	return priorities
+}
+
+func (s *Server) admissionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.admission.Status())
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/middleware"
+)
+
+func TestAdmissionShedsByPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	admission := middleware.NewAdmissionController(middleware.AdmissionConfig{
+		MaxInFlight:        4,
+		CustomerPriorities: map[string]middleware.Priority{"paid": middleware.PriorityHigh},
+		RoutePriorities:    map[string]middleware.Priority{"/health": middleware.PriorityCritical},
+		CustomerPriority:   middleware.PriorityNormal,
+		AnonymousPriority:  middleware.PriorityLow,
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	r := gin.New()
+	// Stand in for the middleware identifying customers
+	r.Use(func(c *gin.Context) {
+		if customer := c.GetHeader("X-Customer"); customer != "" {
+			c.Set(middleware.CustomerKey, customer)
+		}
+	})
+	r.Use(admission.Middleware())
+	r.GET("/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path, customer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if customer != "" {
+			req.Header.Set("X-Customer", customer)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Fill the instance with three long running high priority requests
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("/slow", "paid")
+		}()
+		<-started
+	}
+
+	testCases := []struct {
+		name           string
+		path           string
+		customer       string
+		expectedStatus int
+	}{
+		{name: "anonymous is shed", path: "/fast", expectedStatus: http.StatusServiceUnavailable},
+		{name: "free tier is shed", path: "/fast", customer: "free", expectedStatus: http.StatusServiceUnavailable},
+		{name: "paid customer is admitted", path: "/fast", customer: "paid", expectedStatus: http.StatusOK},
+		{name: "health check is admitted", path: "/health", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := get(tc.path, tc.customer)
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if tc.expectedStatus == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Errorf("Expected Retry-After header on shed request")
+			}
+		})
+	}
+
+	close(release)
+	wg.Wait()
+
+	if w := get("/fast", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected anonymous request to be admitted after load drops, got %d", w.Code)
+	}
+	if status := admission.Status(); status.Shed["low"] != 1 || status.Shed["normal"] != 1 {
+		t.Errorf("Unexpected shed counters: %+v", status.Shed)
+	}
+}
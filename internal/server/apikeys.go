@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/apikey"
+)
+
+// Scopes the routes require of API keys.
+const (
+	scopeInstagramDownload = "instagram:download"
+	scopeUsageRead         = "usage:read"
+)
+
+// loadAPIKeys builds the API key authentication from the environment. Keys
+// belong to customers, so they are only checked when plans are enabled.
+//
+//	APIKEY_CACHE_TTL   how long a key is trusted before checking it again, which bounds how long other replicas accept a revoked key (default 30s)
+func (s *Server) loadAPIKeys(db database.Service) (*apikey.Authenticator, *database.APIKeys) {
+	if s.plans == nil {
+		return nil, nil
+	}
+
+	store := db.APIKeys()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.EnsureSchema(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	cfg := apikey.Config{TTL: envDuration("APIKEY_CACHE_TTL", 0)}
+
+	return apikey.NewAuthenticator(store, cfg), store
+}
+
+// CreateKeyRequest represents the request body for issuing an API key
+type CreateKeyRequest struct {
+	Scopes    []string `json:"scopes" binding:"required"`
+	ExpiresIn string   `json:"expires_in"`
+}
+
+// RotateKeyRequest represents the request body for rotating an API key. The
+// old key keeps working for Grace, so clients can switch over.
+type RotateKeyRequest struct {
+	Grace     string `json:"grace"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// validScopes reports whether scopes can be granted.
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if f := strings.Fields(scope); len(f) != 1 || f[0] != scope {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// expiry returns when a key issued now expires, zero when expiresIn is empty.
+func expiry(now time.Time, expiresIn string) (time.Time, error) {
+	if expiresIn == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.ParseDuration(expiresIn)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("expires_in must be a positive duration")
+	}
+	return now.Add(d), nil
+}
+
+func (s *Server) listKeysHandler(c *gin.Context) {
+	if s.keyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API keys are disabled"})
+		return
+	}
+
+	list, err := s.keyStore.Keys(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": list})
+}
+
+// createKeyHandler issues a key to a customer. The secret is only ever
+// returned here.
+func (s *Server) createKeyHandler(c *gin.Context) {
+	if s.keyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API keys are disabled"})
+		return
+	}
+
+	var request CreateKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if err := validScopes(request.Scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	expiresAt, err := expiry(time.Now(), request.ExpiresIn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	customer := c.Param("id")
+	if _, err := s.planStore.Customer(ctx, customer); errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown customer"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, secret := apikey.Generate(customer, request.Scopes, expiresAt)
+	key, err = s.keyStore.Create(ctx, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"key": key, "secret": secret})
+}
+
+// rotateKeyHandler replaces a key by a new one with the same scopes,
+// returning the new secret.
+func (s *Server) rotateKeyHandler(c *gin.Context) {
+	if s.keyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API keys are disabled"})
+		return
+	}
+
+	var request RotateKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	now := time.Now()
+	var grace time.Duration
+	if request.Grace != "" {
+		d, err := time.ParseDuration(request.Grace)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "grace must be a non-negative duration"})
+			return
+		}
+		grace = d
+	}
+	expiresAt, err := expiry(now, request.ExpiresIn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	old, err := s.keyStore.Key(ctx, c.Param("id"))
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown API key"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	next, secret := apikey.Generate(old.Customer, old.Scopes, expiresAt)
+	next, err = s.keyStore.Rotate(ctx, old.ID, next, now.Add(grace))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.keys.Invalidate(old.ID)
+	c.JSON(http.StatusCreated, gin.H{"key": next, "secret": secret, "old_key_revoked_at": now.Add(grace)})
+}
+
+// revokeKeyHandler revokes a key at once, applying it to the next request
+// on this replica.
+func (s *Server) revokeKeyHandler(c *gin.Context) {
+	if s.keyStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API keys are disabled"})
+		return
+	}
+
+	key, err := s.keyStore.Revoke(c.Request.Context(), c.Param("id"), time.Now())
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown API key"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.keys.Invalidate(key.ID)
+	c.JSON(http.StatusOK, key)
+}
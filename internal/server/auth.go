@@ -0,0 +1,96 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/jwtauth"
+	"api-rate-limiting/internal/pkg/middleware"
+)
+
+// loadJWT builds the verification of bearer tokens from the environment,
+// with the claim naming the caller's plan and the issuers trusted with it.
+// It returns nil, leaving bearer tokens unchecked, when no key is set.
+//
+//	JWT_JWKS_FILE         local JWKS file with the keys tokens are signed with
+//	JWT_PUBLIC_KEY_FILE   PEM public key for RS256 or ES256 tokens
+//	JWT_HS256_SECRET      shared secret for HS256 tokens, at least 32 bytes
+//	JWT_ISSUER            iss claim tokens must carry (default any)
+//	JWT_AUDIENCE          value the aud claim of tokens must hold (default any)
+//	JWT_LEEWAY            clock skew tolerated on exp and nbf (default 30s)
+//	JWT_TIER_CLAIM        claim naming the plan of the caller (default tier)
+//	JWT_TIER_ISSUERS      iss claims of the tokens whose tier is trusted,
+//	                      separated by commas (default none, stored plans apply)
+func loadJWT() (*jwtauth.Verifier, middleware.JWTConfig) {
+	keys := &jwtauth.KeySet{}
+	if path := os.Getenv("JWT_JWKS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("error reading JWT_JWKS_FILE: %v", err)
+		}
+		if keys, err = jwtauth.ParseJWKS(data); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if path := os.Getenv("JWT_PUBLIC_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("error reading JWT_PUBLIC_KEY_FILE: %v", err)
+		}
+		if err := keys.AddPEM("", data); err != nil {
+			log.Fatalf("invalid JWT_PUBLIC_KEY_FILE: %v", err)
+		}
+	}
+	if secret := os.Getenv("JWT_HS256_SECRET"); secret != "" {
+		if err := keys.AddHMAC("", []byte(secret)); err != nil {
+			log.Fatalf("invalid JWT_HS256_SECRET: %v", err)
+		}
+	}
+	if keys.Len() == 0 {
+		return nil, middleware.JWTConfig{}
+	}
+
+	cfg := jwtauth.Config{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Leeway:   envDuration("JWT_LEEWAY", 30*time.Second),
+	}
+
+	jwtCfg := middleware.JWTConfig{TierClaim: "tier"}
+	if v := os.Getenv("JWT_TIER_CLAIM"); v != "" {
+		jwtCfg.TierClaim = v
+	}
+	for _, issuer := range strings.Split(os.Getenv("JWT_TIER_ISSUERS"), ",") {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			jwtCfg.TierIssuers = append(jwtCfg.TierIssuers, issuer)
+		}
+	}
+	return jwtauth.NewVerifier(keys, cfg), jwtCfg
+}
+
+// loadAuthRequired reports whether anonymous callers are rejected.
+//
+//	AUTH_REQUIRED   reject requests without an API key or bearer token (default false)
+func loadAuthRequired() bool {
+	return envBool("AUTH_REQUIRED", false)
+}
+
+// identify returns the middleware identifying callers by API key or bearer
+// token, to run ahead of the limiters of the API routes.
+func (s *Server) identify() []gin.HandlerFunc {
+	var handlers []gin.HandlerFunc
+	if s.keys != nil {
+		handlers = append(handlers, middleware.Authenticate(s.keys))
+	}
+	if s.jwt != nil {
+		handlers = append(handlers, middleware.JWT(s.jwt, s.jwtConfig))
+	}
+	if s.authRequired {
+		handlers = append(handlers, middleware.RequireCustomer())
+	}
+	return handlers
+}
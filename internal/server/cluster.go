@@ -0,0 +1,96 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit/cluster"
+)
+
+// loadCluster builds the cluster mode, where every key is limited on the
+// replica owning it, from the environment. It returns nil when no member is
+// set.
+//
+//	CLUSTER_MEMBERS           comma-separated internal base URLs of every replica, this one included
+//	CLUSTER_SELF              internal base URL of this replica, as listed in CLUSTER_MEMBERS
+//	CLUSTER_TOKEN             shared token authenticating forwarded checks (required)
+//	CLUSTER_TIMEOUT           how long to wait for an owner before limiting locally (default 200ms)
+//	CLUSTER_CACHE_TTL         how long an owner's denial is reused at most (default 1s)
+//	CLUSTER_FAILURE_BACKOFF   how long an unreachable owner is skipped (default 1s)
+func loadCluster() *cluster.Cluster {
+	members := splitMembers(os.Getenv("CLUSTER_MEMBERS"))
+	if len(members) == 0 {
+		return nil
+	}
+
+	cfg := cluster.Config{
+		Self:    strings.TrimSuffix(os.Getenv("CLUSTER_SELF"), "/"),
+		Members: members,
+		Token:   os.Getenv("CLUSTER_TOKEN"),
+	}
+	if !slices.Contains(members, cfg.Self) {
+		log.Fatalf("CLUSTER_SELF %q is not one of CLUSTER_MEMBERS", cfg.Self)
+	}
+	if cfg.Token == "" {
+		log.Fatalf("CLUSTER_TOKEN is required with CLUSTER_MEMBERS")
+	}
+
+	cfg.Timeout = envDuration("CLUSTER_TIMEOUT", 0)
+	cfg.CacheTTL = envDuration("CLUSTER_CACHE_TTL", 0)
+	cfg.FailureBackoff = envDuration("CLUSTER_FAILURE_BACKOFF", 0)
+
+	return cluster.New(cfg)
+}
+
+// splitMembers parses a comma-separated list of base URLs.
+func splitMembers(list string) []string {
+	var members []string
+	for _, m := range strings.Split(list, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, strings.TrimSuffix(m, "/"))
+		}
+	}
+	return members
+}
+
+// ClusterMembersRequest represents the request body for changing the ring members
+type ClusterMembersRequest struct {
+	Members []string `json:"members" binding:"required"`
+}
+
+func (s *Server) clusterHandler(c *gin.Context) {
+	if s.cluster == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cluster mode is disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"self": s.cluster.Self(), "members": s.cluster.Members()})
+}
+
+// setClusterMembersHandler replaces the ring members, moving only the keys
+// of the members that joined or left.
+func (s *Server) setClusterMembersHandler(c *gin.Context) {
+	if s.cluster == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cluster mode is disabled"})
+		return
+	}
+
+	var req ClusterMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	members := splitMembers(strings.Join(req.Members, ","))
+	if !slices.Contains(members, s.cluster.Self()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "members must include this replica"})
+		return
+	}
+
+	s.cluster.SetMembers(members)
+	c.JSON(http.StatusOK, gin.H{"self": s.cluster.Self(), "members": s.cluster.Members()})
+}
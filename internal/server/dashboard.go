@@ -0,0 +1,35 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed web
+var webFS embed.FS
+
+// registerDashboardRoutes serves the embedded single-page dashboard at /
+// and its static assets under /assets.
+func (s *Server) registerDashboardRoutes(r *gin.Engine) {
+	assets, err := fs.Sub(webFS, "web/assets")
+	if err != nil {
+		panic(err)
+	}
+
+	r.StaticFS("/assets", http.FS(assets))
+	r.GET("/", s.DashboardHandler)
+}
+
+// DashboardHandler serves the rate limiting dashboard
+func (s *Server) DashboardHandler(c *gin.Context) {
+	index, err := webFS.ReadFile("web/index.html")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "dashboard is not available"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
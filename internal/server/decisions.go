@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+
+	"api-rate-limiting/internal/pkg/ratelimit/rls"
+)
+
+// loadDecisionService builds the rate limit decision service from the
+// descriptor configuration file named by RATELIMIT_CONFIG. Its limiters share
+// the options of the route limiters, and descriptors of the policies domain
+// (or the file's policy_domain) are decided by the server's own policies.
+func (s *Server) loadDecisionService() *rls.Service {
+	var cfg rls.Config
+
+	if path := os.Getenv("RATELIMIT_CONFIG"); path != "" {
+		loaded, err := rls.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cfg = loaded
+	}
+	if cfg.PolicyDomain == "" {
+		cfg.PolicyDomain = "policies"
+	}
+	cfg.Options = s.limitOptions()
+
+	service, err := rls.New(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return service
+}
+
+// startDecisionGRPC serves the decision service through Envoy's
+// RateLimitService API on RATELIMIT_GRPC_PORT, when set, protected by the
+// RATELIMIT_SERVICE_TOKEN bearer token like the HTTP API. The returned
+// function stops the gRPC server.
+func (s *Server) startDecisionGRPC() (func(), error) {
+	port := os.Getenv("RATELIMIT_GRPC_PORT")
+	if port == "" {
+		return func() {}, nil
+	}
+	token := os.Getenv("RATELIMIT_SERVICE_TOKEN")
+	if token == "" {
+		return nil, errors.New("RATELIMIT_SERVICE_TOKEN is required with RATELIMIT_GRPC_PORT")
+	}
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for rate limit gRPC: %v", err)
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(rls.TokenAuth(token)))
+	rls.RegisterEnvoy(srv, s.decisions)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("rate limit gRPC server error: %v", err)
+		}
+	}()
+
+	return srv.GracefulStop, nil
+}
+
+// registerDecisionRoutes mounts the decision API, protected by the
+// RATELIMIT_SERVICE_TOKEN bearer token. When RATELIMIT_SERVICE_TOKEN is
+// unset the decision API is disabled.
+func (s *Server) registerDecisionRoutes(r *gin.Engine) {
+	v1 := r.Group("/v1/ratelimit", bearerAuth(os.Getenv("RATELIMIT_SERVICE_TOKEN"), "the decision API is disabled", "invalid service token"))
+
+	v1.POST("/check", s.checkHandler)
+}
+
+// checkHandler decides a request's descriptors and counts the hits against
+// the matching limits. Over limit decisions are still returned with 200, the
+// caller decides how to reject its own request.
+func (s *Server) checkHandler(c *gin.Context) {
+	var req rls.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	resp, err := s.decisions.Check(c.Request.Context(), req)
+	if errors.Is(err, rls.ErrInvalidRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
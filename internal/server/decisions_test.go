@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/rls"
+)
+
+func TestDecisionCheckHandler(t *testing.T) {
+	t.Setenv("RATELIMIT_SERVICE_TOKEN", "secret")
+	ratelimit.NewFixedWindow(1, time.Minute, ratelimit.WithName("handler-test-policy"))
+	decisions, err := rls.New(rls.Config{
+		PolicyDomain: "policies",
+		Domains: []rls.DomainConfig{{
+			Domain: "handler-test",
+			Descriptors: []rls.DescriptorConfig{
+				{Key: "user", RateLimit: &rls.LimitConfig{RequestsPerUnit: 1, Unit: rls.UnitMinute}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create decision service: %v", err)
+	}
+	s := &Server{decisions: decisions}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	s.registerDecisionRoutes(r)
+
+	check := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/ratelimit/check", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		r.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	body := `{"domain": "handler-test", "descriptors": [{"entries": [{"key": "user", "value": "alice"}]}]}`
+	if code, resp := check(body); code != http.StatusOK || resp["overall_code"] != "OK" {
+		t.Fatalf("first check: got %d %v", code, resp)
+	}
+	code, resp := check(body)
+	if code != http.StatusOK || resp["overall_code"] != "OVER_LIMIT" {
+		t.Fatalf("second check: got %d %v", code, resp)
+	}
+	status := resp["statuses"].([]interface{})[0].(map[string]interface{})
+	if status["duration_until_reset"] == nil {
+		t.Errorf("expected duration_until_reset in %v", status)
+	}
+
+	if code, _ := check(`{"domain": "handler-test"}`); code != http.StatusBadRequest {
+		t.Errorf("request without descriptors: got %d want %d", code, http.StatusBadRequest)
+	}
+
+	body = `{"domain": "policies", "descriptors": [{"entries": [{"key": "policy", "value": "handler-test-policy"}, {"key": "key", "value": "1.2.3.4"}]}]}`
+	if code, resp := check(body); code != http.StatusOK || resp["overall_code"] != "OK" {
+		t.Fatalf("first policy check: got %d %v", code, resp)
+	}
+	if code, resp := check(body); code != http.StatusOK || resp["overall_code"] != "OVER_LIMIT" {
+		t.Fatalf("second policy check: got %d %v", code, resp)
+	}
+}
+
+func TestDecisionCheckAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"domain": "auth-test", "descriptors": [{"entries": [{"key": "user", "value": "alice"}]}]}`
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"token unset", "", "", http.StatusNotFound},
+		{"token unset with a header", "", "Bearer ", http.StatusNotFound},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RATELIMIT_SERVICE_TOKEN", tt.token)
+			decisions, err := rls.New(rls.Config{})
+			if err != nil {
+				t.Fatalf("Failed to create decision service: %v", err)
+			}
+			s := &Server{decisions: decisions}
+			r := gin.New()
+			s.registerDecisionRoutes(r)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/ratelimit/check", bytes.NewBufferString(body))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got %d want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
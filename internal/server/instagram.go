@@ -1,17 +1,56 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/breaker"
+	"api-rate-limiting/internal/pkg/ratelimit/outbound"
 )
 
+// instagramClient is shared by all Instagram fetches so that bursts of
+// downloads are smoothed to a rate Instagram tolerates, instead of getting
+// the server's IP banned.
+var instagramClient = &http.Client{
+	Timeout: 20 * time.Second,
+	Transport: outbound.NewTransport(nil, outbound.Config{
+		Default: outbound.HostLimit{RequestsPerSecond: 2, Burst: 5},
+	}),
+}
+
+// instagramBreaker stops calling Instagram while it is throttling us or
+// failing, so downloads fail fast instead of waiting on the upstream.
+var instagramBreaker = breaker.New(loadInstagramBreakerConfig())
+
+// loadInstagramBreakerConfig reads the Instagram circuit breaker settings from the environment.
+//
+//	INSTAGRAM_BREAKER_FAILURES        consecutive failures that open the breaker (default 5)
+//	INSTAGRAM_BREAKER_FAILURE_RATIO   share of failed calls per minute that opens it, once 20 calls were made (default 0.5)
+//	INSTAGRAM_BREAKER_OPEN_TIMEOUT    how long the breaker stays open before probing again (default 30s)
+func loadInstagramBreakerConfig() breaker.Config {
+	cfg := breaker.Config{
+		Name:                "instagram",
+		ConsecutiveFailures: envInt("INSTAGRAM_BREAKER_FAILURES", 5),
+		FailureRatio:        envFloat("INSTAGRAM_BREAKER_FAILURE_RATIO", 0.5),
+		MinRequests:         20,
+		OpenTimeout:         envDuration("INSTAGRAM_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+	}
+
+	return cfg
+}
+
 // InstagramDownloadRequest represents the request body for the Instagram download endpoint
 type InstagramDownloadRequest struct {
 	URL string `json:"url" binding:"required"`
@@ -43,7 +82,15 @@ func (s *Server) InstagramDownloadHandler(c *gin.Context) {
 	}
 
 	// Extract media URL
-	downloadURL, mediaType, err := extractInstagramMediaURL(request.URL)
+	downloadURL, mediaType, err := extractInstagramMediaURL(c.Request.Context(), request.URL)
+	if errors.Is(err, breaker.ErrOpen) {
+		retryAfter := max(int(math.Ceil(instagramBreaker.RetryAfter().Seconds())), 1)
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusServiceUnavailable, InstagramDownloadResponse{
+			Error: "Instagram is unavailable, try again later",
+		})
+		return
+	}
 	if err != nil {
 		statusCode := http.StatusInternalServerError
 		if strings.Contains(err.Error(), "private") {
@@ -73,10 +120,9 @@ func isValidInstagramURL(inputURL string) bool {
 }
 
 // extractInstagramMediaURL extracts the direct media URL from an Instagram post URL
-func extractInstagramMediaURL(instagramURL string) (string, string, error) {
-	// Create an HTTP client with appropriate headers to mimic a browser
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", instagramURL, nil)
+func extractInstagramMediaURL(ctx context.Context, instagramURL string) (string, string, error) {
+	// Create a request with appropriate headers to mimic a browser
+	req, err := http.NewRequestWithContext(ctx, "GET", instagramURL, nil)
 	if err != nil {
 		return "", "", fmt.Errorf("error creating request: %v", err)
 	}
@@ -86,12 +132,24 @@ func extractInstagramMediaURL(instagramURL string) (string, string, error) {
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
 
+	// Fail fast while Instagram is throttling us or down
+	if err := instagramBreaker.Allow(); err != nil {
+		return "", "", err
+	}
+
 	// Send the request
-	resp, err := client.Do(req)
+	resp, err := instagramClient.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			// Callers going away say nothing about Instagram's health
+			instagramBreaker.Cancel()
+		} else {
+			instagramBreaker.Done(false)
+		}
 		return "", "", fmt.Errorf("error fetching Instagram page: %v", err)
 	}
 	defer resp.Body.Close()
+	instagramBreaker.Done(resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError)
 
 	// Check response status
 	if resp.StatusCode == http.StatusNotFound {
@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/breaker"
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/ratelimit"
 )
 
 func TestInstagramDownloadHandler(t *testing.T) {
@@ -111,6 +116,63 @@ func TestInstagramDownloadHandler(t *testing.T) {
 	}
 }
 
+func TestInstagramCircuitOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Trip a fresh breaker so no request reaches Instagram
+	saved := instagramBreaker
+	instagramBreaker = breaker.New(breaker.Config{Name: "instagram-test", ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	t.Cleanup(func() { instagramBreaker = saved })
+	instagramBreaker.Allow()
+	instagramBreaker.Done(false)
+
+	s := &Server{}
+	r := gin.New()
+	r.POST("/instagram/download", s.InstagramDownloadHandler)
+
+	requestBody, _ := json.Marshal(InstagramDownloadRequest{URL: "https://www.instagram.com/p/ABC123/"})
+	req, _ := http.NewRequest("POST", "/instagram/download", bytes.NewBuffer(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", got)
+	}
+}
+
+func TestInstagramInvalidURLsLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{}
+	r := gin.New()
+	invalid := ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithName(t.Name()), ratelimit.WithChargeOn(http.StatusBadRequest))
+	r.POST("/instagram/download", middleware.Limit(invalid), s.InstagramDownloadHandler)
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/instagram/download", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.50:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Only the requests answered with 400 count, and once they reach the
+	// limit even well-formed requests are rejected
+	for i, want := range []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests} {
+		if got := post(`{"url": "https://example.com/p/ABC123/"}`); got != want {
+			t.Errorf("request %d: got %d want %d", i, got, want)
+		}
+	}
+	if got := post(`{"url": "https://www.instagram.com/p/ABC123/"}`); got != http.StatusTooManyRequests {
+		t.Errorf("valid URL: got %d want %d", got, http.StatusTooManyRequests)
+	}
+}
+
 // This is a manual test function that can be run to test with real Instagram URLs
 // It's commented out because it requires network access and shouldn't be run in automated tests
 /*
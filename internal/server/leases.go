@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// loadLeases builds the shared rate limit backend from the environment. It
+// returns nil, keeping fixed window counts in memory, unless a backend is set.
+//
+//	RATELIMIT_BACKEND          shared store fixed windows are counted in: postgres (default none)
+//	RATELIMIT_LEASE_FRACTION   fraction of the limit leased from the backend at once (default 0.1)
+//	RATELIMIT_LEASE_TTL        how long leased tokens are used before unused ones are returned (default 1s)
+//	RATELIMIT_BACKEND_TIMEOUT  how long a backend call may take before it counts as failed (default 100ms)
+//	RATELIMIT_BACKEND_BACKOFF  how long the backend is left alone after a failure (default 1s)
+func loadLeases(db database.Service) (*ratelimit.Leases, *database.Counters) {
+	var counters *database.Counters
+	switch v := os.Getenv("RATELIMIT_BACKEND"); v {
+	case "":
+		return nil, nil
+	case "postgres":
+		counters = db.Counters()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := counters.EnsureSchema(ctx); err != nil {
+			log.Fatalf("%v", err)
+		}
+	default:
+		log.Printf("ignoring invalid RATELIMIT_BACKEND %q", v)
+		return nil, nil
+	}
+
+	cfg := ratelimit.LeaseConfig{
+		OnBackendChange: func(err error) {
+			if err != nil {
+				log.Printf("rate limit backend failing, applying each policy's failure mode: %v", err)
+			} else {
+				log.Printf("rate limit backend recovered")
+			}
+		},
+	}
+	cfg.Fraction = envFloat("RATELIMIT_LEASE_FRACTION", 0)
+	cfg.TTL = envDuration("RATELIMIT_LEASE_TTL", 0)
+	cfg.Timeout = envDuration("RATELIMIT_BACKEND_TIMEOUT", 0)
+	cfg.FailureBackoff = envDuration("RATELIMIT_BACKEND_BACKOFF", 0)
+
+	return ratelimit.NewLeases(counters, cfg), counters
+}
+
+// loadFailureMode reads what routes without a failure mode of their own
+// decide while the shared backend is failing.
+//
+//	RATELIMIT_BACKEND_FAILURE   fail-open, fail-closed or local (default fail-open)
+func loadFailureMode() ratelimit.FailureMode {
+	switch v := ratelimit.FailureMode(os.Getenv("RATELIMIT_BACKEND_FAILURE")); v {
+	case "":
+		return ratelimit.FailOpen
+	case ratelimit.FailOpen, ratelimit.FailClosed, ratelimit.FailLocal:
+		return v
+	default:
+		log.Printf("ignoring invalid RATELIMIT_BACKEND_FAILURE %q", v)
+		return ratelimit.FailOpen
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/metering"
+)
+
+// loadMeter builds the usage metering of customers from the environment.
+// Usage is metered per customer, so only when plans are enabled.
+//
+//	METERING_INTERVAL   how often hourly rollups are flushed to the database (default 10s)
+func (s *Server) loadMeter(db database.Service) (*metering.Meter, *database.Metering) {
+	if s.plans == nil {
+		return nil, nil
+	}
+
+	store := db.Metering()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.EnsureSchema(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	cfg := metering.Config{Interval: envDuration("METERING_INTERVAL", 0)}
+
+	return metering.New(store, cfg), store
+}
+
+// FlushUsage writes the usage metered since the last flush to the database.
+// It is called once the server stopped handling requests.
+func (s *Server) FlushUsage() {
+	if s.meter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.meter.Flush(ctx); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// exportUsageHandler streams the hourly usage of a date range as CSV or
+// JSON Lines. Hours that are not settled yet are left out, so the same
+// range always exports the same file.
+func (s *Server) exportUsageHandler(c *gin.Context) {
+	if s.meterStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "metering is disabled"})
+		return
+	}
+
+	from, err := metering.ParseTime(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from: " + err.Error()})
+		return
+	}
+	to, err := metering.ParseTime(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to: " + err.Error()})
+		return
+	}
+	format, err := metering.ParseFormat(c.DefaultQuery("format", "csv"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.%s"`,
+		c.Query("from"), c.Query("to"), format))
+	c.Status(http.StatusOK)
+	if _, _, err := metering.Export(c.Request.Context(), s.meterStore, from, to, time.Now(), format, c.Writer); err != nil {
+		// The status is sent already, the truncated file is all we can do
+		log.Printf("%v", err)
+	}
+}
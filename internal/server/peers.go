@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-rate-limiting/internal/pkg/jwtauth"
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/cluster"
+	"api-rate-limiting/internal/pkg/ratelimit/peersync"
+)
+
+// loadPeerSync builds the counter synchronization between replicas from the
+// environment. It returns nil, keeping limits per replica, when no peer is set.
+//
+//	PEERSYNC_PEERS        comma-separated internal base URLs of the other replicas
+//	PEERSYNC_ID           this replica's ID in the deltas it sends (default the hostname)
+//	PEERSYNC_TOKEN        shared token authenticating deltas between replicas (required)
+//	PEERSYNC_INTERVAL     how often deltas are sent, bounding staleness (default 1s)
+//	PEERSYNC_RESOLUTION   width of the buckets hits are counted in, bounding accuracy (default 1s)
+//	PEERSYNC_RETENTION    how long remote hits are kept, at least the longest window (default 1h)
+//	PEERSYNC_MAX_KEYS     keys whose hits are kept before LRU eviction (default 100000)
+func loadPeerSync() *peersync.Syncer {
+	var cfg peersync.Config
+
+	for _, peer := range strings.Split(os.Getenv("PEERSYNC_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			cfg.Peers = append(cfg.Peers, strings.TrimSuffix(peer, "/"))
+		}
+	}
+	if len(cfg.Peers) == 0 {
+		return nil
+	}
+
+	cfg.ID = os.Getenv("PEERSYNC_ID")
+	if cfg.ID == "" {
+		cfg.ID, _ = os.Hostname()
+	}
+	cfg.Token = os.Getenv("PEERSYNC_TOKEN")
+	if cfg.Token == "" {
+		log.Fatalf("PEERSYNC_TOKEN is required with PEERSYNC_PEERS")
+	}
+
+	cfg.Interval = envDuration("PEERSYNC_INTERVAL", 0)
+	cfg.Resolution = envDuration("PEERSYNC_RESOLUTION", 0)
+	cfg.Retention = envDuration("PEERSYNC_RETENTION", 0)
+	cfg.MaxKeys = envInt("PEERSYNC_MAX_KEYS", 0)
+
+	return peersync.New(cfg)
+}
+
+// startInternal serves the endpoints replicas call on each other on
+// INTERNAL_PORT, apart from the public API, when they are enabled. The
+// returned function stops the listener.
+//
+//	INTERNAL_PORT   port of the endpoints between replicas, required with peers or a cluster
+func (s *Server) startInternal() (func(), error) {
+	if s.peers == nil && s.cluster == nil {
+		return func() {}, nil
+	}
+	mux := http.NewServeMux()
+	if s.peers != nil {
+		mux.Handle(peersync.Path, s.peers.Handler())
+	}
+	if s.cluster != nil {
+		mux.Handle(cluster.Path, s.cluster.Handler())
+	}
+
+	port := os.Getenv("INTERNAL_PORT")
+	if port == "" {
+		return nil, errors.New("INTERNAL_PORT is required to serve the endpoints between replicas")
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for replicas: %v", err)
+	}
+
+	srv := &http.Server{
+		Handler:      mux,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Printf("internal server error: %v", err)
+		}
+	}()
+
+	return func() { srv.Close() }, nil
+}
+
+// envDuration reads the duration in the environment variable name, or def
+// when it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s %q: %v", name, v, err)
+		return def
+	}
+	return d
+}
+
+// envInt reads the integer in the environment variable name, or def when it
+// is unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s %q: %v", name, v, err)
+		return def
+	}
+	return n
+}
+
+// envFloat reads the number in the environment variable name, or def when it
+// is unset or invalid.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("ignoring invalid %s %q: %v", name, v, err)
+		return def
+	}
+	return f
+}
+
+// envBool reads the boolean in the environment variable name, or def when it
+// is unset or invalid.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s %q: %v", name, v, err)
+		return def
+	}
+	return b
+}
+
+// limitOptions adds the options every route limiter shares to opts, such as
+// counting the hits admitted by other replicas, forwarding to key owners,
+// leasing tokens from the shared backend or keying token holders on their
+// subject.
+func (s *Server) limitOptions(opts ...ratelimit.Option) []ratelimit.Option {
+	if s.peers != nil {
+		opts = append(opts, ratelimit.WithPeers(s.peers))
+	}
+	if s.cluster != nil {
+		opts = append(opts, ratelimit.WithForwarder(s.cluster))
+	}
+	if s.jwt != nil {
+		// Routes declaring their own key function override the default
+		opts = append([]ratelimit.Option{ratelimit.WithKeyFunc(jwtauth.ClaimKey("sub"))}, opts...)
+	}
+	if s.leases != nil {
+		// Routes declaring their own failure mode override the default
+		opts = append([]ratelimit.Option{ratelimit.WithFailureMode(s.failureMode)}, opts...)
+		opts = append(opts, ratelimit.WithLeases(s.leases))
+	}
+	return opts
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+)
+
+// loadPlans builds the subscription plans from the environment. It returns
+// nil, applying the same limits to every caller, unless plans are enabled.
+//
+//	PLANS_ENABLED     resolve the plan of customers, who authenticate with API keys (default false)
+//	PLANS_CACHE_TTL   how long a customer's plan is reused before asking the database again (default 1m)
+func (s *Server) loadPlans(db database.Service) (*plans.Resolver, *database.Plans) {
+	if !envBool("PLANS_ENABLED", false) {
+		return nil, nil
+	}
+
+	store := db.Plans()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.EnsureSchema(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	cfg := plans.Config{TTL: envDuration("PLANS_CACHE_TTL", 0), Options: s.limitOptions()}
+
+	return plans.NewResolver(store, cfg), store
+}
+
+// limit enforces the limiter p matches to each request, or the limit the
+// caller's plan sets on the route when plans are enabled.
+func (s *Server) limit(p *middleware.RoutePolicies) gin.HandlerFunc {
+	if s.plans != nil {
+		return middleware.RoutePlanLimit(s.plans, p)
+	}
+	return middleware.RouteLimit(p)
+}
+
+// PlanRequest represents the request body for creating or changing a plan
+type PlanRequest struct {
+	Limits map[string]plans.Limit `json:"limits" binding:"required"`
+	Quotas map[string]quota.Quota `json:"quotas"`
+}
+
+// CustomerRequest represents the request body for assigning a customer to a plan
+type CustomerRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+func (s *Server) listPlansHandler(c *gin.Context) {
+	if s.planStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plans are disabled"})
+		return
+	}
+
+	list, err := s.planStore.ListPlans(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": list})
+}
+
+func (s *Server) savePlanHandler(c *gin.Context) {
+	if s.planStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plans are disabled"})
+		return
+	}
+
+	var request PlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	plan := plans.Plan{Name: c.Param("name"), Limits: request.Limits, Quotas: request.Quotas}
+	if err := plan.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.planStore.SavePlan(c.Request.Context(), plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.plans.InvalidatePlan(plan.Name)
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Server) getCustomerHandler(c *gin.Context) {
+	if s.planStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plans are disabled"})
+		return
+	}
+
+	customer, err := s.planStore.Customer(c.Request.Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown customer"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+// saveCustomerHandler creates a customer or moves them to another plan,
+// applying the change to their next request on this replica.
+func (s *Server) saveCustomerHandler(c *gin.Context) {
+	if s.planStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plans are disabled"})
+		return
+	}
+
+	var request CustomerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := s.planStore.Plan(ctx, request.Plan); errors.Is(err, plans.ErrUnknownPlan) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown plan " + request.Plan})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	customer, err := s.planStore.SaveCustomer(ctx, database.Customer{ID: c.Param("id"), Plan: request.Plan})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.plans.Invalidate(customer.ID)
+	c.JSON(http.StatusOK, customer)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// loadTrustedProxies makes the limiters and gin believe the X-Forwarded-For
+// header of the proxies listed in TRUSTED_PROXIES, IP addresses or CIDR
+// ranges separated by commas. By default no proxy is trusted and clients are
+// identified by their remote address.
+func loadTrustedProxies(r *gin.Engine) {
+	var proxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		proxies = strings.Split(v, ",")
+	}
+
+	if err := ratelimit.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	for i := range proxies {
+		proxies[i] = strings.TrimSpace(proxies[i])
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+}
@@ -2,13 +2,16 @@ package server
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
+	"api-rate-limiting/internal/pkg/metrics"
 	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/ratelimit"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
@@ -20,35 +23,105 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Store the cancel function for later use during server shutdown
 	s.cancel = cancel
 
-	go middleware.ResetTokenBuckets(ctx)
-	go middleware.ResetFixedWindows(ctx)
-	go middleware.ResetSlidingWindows(ctx)
+	// Bound the memory used to track clients
+	ratelimit.ConfigureStores(loadStoreConfig())
+
+	// Identify clients behind the same proxies in every adapter
+	loadTrustedProxies(r)
+
+	// Sweep client state once it expires under its policy
+	go ratelimit.RunJanitor(ctx, ratelimit.SystemClock)
+
+	// Share counts with the other replicas when running several
+	if s.peers != nil {
+		go s.peers.Run(ctx, ratelimit.SystemClock)
+	}
+
+	// Return unused leased tokens and drop the counters of past windows
+	if s.leases != nil {
+		go s.leases.RunJanitor(ctx, ratelimit.SystemClock)
+		go s.counters.RunJanitor(ctx, time.Minute)
+	}
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"}, // Add your frontend URL
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
+		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
 		AllowCredentials: true, // Enable cookies/auth
 	}))
 
-	r.GET("/", s.HelloWorldHandler)
+	// Meter the requests of customers for billing
+	if s.meter != nil {
+		r.Use(middleware.Meter(s.meter))
+		go s.meter.Run(ctx, func(err error) { log.Printf("%v", err) })
+	}
+
+	s.registerDashboardRoutes(r)
+	s.registerAdminRoutes(r)
+	s.registerDecisionRoutes(r)
+
+	r.GET("/hello", s.HelloWorldHandler)
 
 	r.GET("/health", s.healthHandler)
 
-	// Instagram download endpoint
-	r.POST("/instagram/download", s.InstagramDownloadHandler)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// API routes identify callers by API key or bearer token, shedding low
+	// priority callers first when the whole instance is overloaded, before
+	// their policies limit them
+	api := r.Group("", append(s.identify(), s.admission.Middleware(), s.limit(s.routePolicies()))...)
 
-	// Fixed Window: 3 request/10 seconds
-	r.GET("/fixed", middleware.FixedWindowMiddleware(3, time.Second), s.TestHandler("Fixed Window"))
+	api.GET("/v1/usage", middleware.RequireScope(scopeUsageRead), s.usageHandler)
 
-	// Sliding Window: 5 request/30 seconds
-	r.GET("/sliding", middleware.SlidingWindowMiddleware(5, 30*time.Second), s.TestHandler("Sliding Window"))
+	// Invalid download requests: 10 per 10 minutes, counting only the ones
+	// answered with 400 Bad Request, e.g. for URLs that are not Instagram's
+	invalidDownloads := ratelimit.NewFixedWindow(10, 10*time.Minute, s.limitOptions(
+		ratelimit.WithName("instagram-invalid"),
+		ratelimit.WithChargeOn(http.StatusBadRequest),
+	)...)
 
-	// Token Bucket: 1 token/second with a burst of 3 tokens
-	r.GET("/token-bucket", middleware.TokenBucketMiddleware(1, 3), s.TestHandler("Token Bucket"))
+	api.POST("/instagram/download", middleware.RequireScope(scopeInstagramDownload), middleware.Limit(invalidDownloads), s.quota(), s.InstagramDownloadHandler)
+
+	api.GET("/fixed", s.quota(), s.TestHandler("Fixed Window"))
+
+	api.GET("/sliding", s.quota(), s.TestHandler("Sliding Window"))
+
+	api.GET("/token-bucket", s.quota(), s.TestHandler("Token Bucket"))
+
+	api.GET("/token-bucket/wait", s.quota(), s.TestHandler("Token Bucket (wait)"))
 	return r
 }
 
+// routePolicies matches the API routes to their limits by method and route
+// template. Routes without a policy of their own get the default one.
+func (s *Server) routePolicies() *middleware.RoutePolicies {
+	// Default: 60 requests/minute
+	return middleware.NewRoutePolicies(ratelimit.NewSlidingWindow(60, time.Minute, s.limitOptions(
+		ratelimit.WithName("default"),
+	)...)).
+		// Instagram endpoints: 30 requests/minute, lowered automatically
+		// when Instagram becomes slow or starts failing
+		Add("POST /instagram/*", ratelimit.NewSlidingWindow(30, time.Minute, s.limitOptions(
+			ratelimit.WithName("instagram"),
+			ratelimit.WithAdaptive(ratelimit.AdaptiveConfig{
+				Floor:         5,
+				LatencyTarget: 5 * time.Second,
+				Interval:      30 * time.Second,
+			}),
+		)...)).
+		// Fixed Window: 3 request/10 seconds
+		Add("GET /fixed", ratelimit.NewFixedWindow(3, time.Second, s.limitOptions()...)).
+		// Sliding Window: 5 request/30 seconds
+		Add("GET /sliding", ratelimit.NewSlidingWindow(5, 30*time.Second, s.limitOptions()...)).
+		// Token Bucket: 1 token/second with a burst of 3 tokens
+		Add("GET /token-bucket", ratelimit.NewTokenBucket(1, 3, s.limitOptions()...)).
+		// Token Bucket for batch clients: waits up to 2 seconds for a token instead of rejecting
+		Add("GET /token-bucket/wait", ratelimit.NewTokenBucket(1, 3, s.limitOptions(
+			ratelimit.WithName("batch"),
+			ratelimit.WithMaxWait(2*time.Second),
+		)...))
+}
+
 func (s *Server) HelloWorldHandler(c *gin.Context) {
 	resp := make(map[string]string)
 	resp["message"] = "Hello World"
@@ -57,7 +130,16 @@ func (s *Server) HelloWorldHandler(c *gin.Context) {
 }
 
 func (s *Server) healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, s.db.Health())
+	health := s.db.Health()
+
+	// An open circuit degrades downloads but not the rest of the API
+	status := instagramBreaker.Status()
+	health["instagram_circuit"] = status.State.String()
+	if status.RetryAfter != "" {
+		health["instagram_circuit_retry_after"] = status.RetryAfter
+	}
+
+	c.JSON(http.StatusOK, health)
 }
 
 func (s *Server) TestHandler(algorithm string) gin.HandlerFunc {
@@ -5,6 +5,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/ratelimit"
 )
 
 func TestHelloWorldHandler(t *testing.T) {
@@ -30,3 +34,121 @@ func TestHelloWorldHandler(t *testing.T) {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 }
+
+func TestRateLimitWaitMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{}
+	r := gin.New()
+	r.GET("/wait", middleware.TokenBucketMiddleware(20, 1, ratelimit.WithMaxWait(time.Second)), s.TestHandler("Token Bucket"))
+	r.GET("/reject", middleware.TokenBucketMiddleware(1, 1, ratelimit.WithMaxWait(100*time.Millisecond)), s.TestHandler("Token Bucket"))
+
+	get := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The second request waits ~50ms for a token instead of failing
+	get("/wait", "192.0.2.1")
+	start := time.Now()
+	if w := get("/wait", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("waiting request: got %d want %d", w.Code, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected the request to be delayed, took %v", elapsed)
+	}
+
+	// A token is a second away, beyond the max wait, so it is rejected at once
+	get("/reject", "192.0.2.2")
+	start = time.Now()
+	w := get("/reject", "192.0.2.2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("over max wait: got %d want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected an immediate rejection, took %v", elapsed)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After: got %q want %q", got, "1")
+	}
+}
+
+func TestRejectionFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{}
+	r := gin.New()
+	r.GET("/negotiated", middleware.FixedWindowMiddleware(0, time.Minute,
+		ratelimit.WithMessage("Limit of {{.Limit}} reached, retry in {{.RetryAfter}}s"),
+	), s.TestHandler("Fixed Window"))
+	r.GET("/plain", middleware.FixedWindowMiddleware(0, time.Minute,
+		ratelimit.WithResponder(ratelimit.PlainTextResponder),
+		ratelimit.WithRejectStatus(http.StatusServiceUnavailable),
+	), s.TestHandler("Fixed Window"))
+
+	testCases := []struct {
+		name           string
+		path           string
+		accept         string
+		expectedStatus int
+		expectedType   string
+		expectedBody   string
+	}{
+		{
+			name:           "JSON by default",
+			path:           "/negotiated",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedType:   "application/json; charset=utf-8",
+			expectedBody:   `{"error":"Too many requests, please try again later.","message":"Limit of 0 reached, retry in 60s"}`,
+		},
+		{
+			name:           "problem+json from Accept",
+			path:           "/negotiated",
+			accept:         "text/html, application/problem+json;q=0.9, application/json;q=0.5",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedType:   "application/problem+json",
+			expectedBody:   `{"detail":"Limit of 0 reached, retry in 60s","instance":"/negotiated","limit":0,"remaining":0,"retry_after":60,"status":429,"title":"Too Many Requests","type":"about:blank"}`,
+		},
+		{
+			name:           "plain text from Accept",
+			path:           "/negotiated",
+			accept:         "text/plain",
+			expectedStatus: http.StatusTooManyRequests,
+			expectedType:   "text/plain; charset=utf-8",
+			expectedBody:   "Limit of 0 reached, retry in 60s\n",
+		},
+		{
+			name:           "policy responder and status override Accept",
+			path:           "/plain",
+			accept:         "application/problem+json",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedType:   "text/plain; charset=utf-8",
+			expectedBody:   "You have exceeded the rate limit. Please wait before making more requests.\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.expectedType {
+				t.Errorf("Expected Content-Type %q, got %q", tc.expectedType, got)
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("Expected body %s, got %s", tc.expectedBody, w.Body.String())
+			}
+			if got := w.Header().Get("Retry-After"); got != "60" {
+				t.Errorf("Expected Retry-After 60, got %q", got)
+			}
+		})
+	}
+}
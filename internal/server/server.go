@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -11,22 +12,66 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/apikey"
+	"api-rate-limiting/internal/pkg/jwtauth"
+	"api-rate-limiting/internal/pkg/metering"
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+	"api-rate-limiting/internal/pkg/ratelimit"
+	"api-rate-limiting/internal/pkg/ratelimit/cluster"
+	"api-rate-limiting/internal/pkg/ratelimit/peersync"
+	"api-rate-limiting/internal/pkg/ratelimit/rls"
 )
 
 type Server struct {
 	port int
 
-	db     database.Service
-	cancel context.CancelFunc
+	db           database.Service
+	admission    *middleware.AdmissionController
+	decisions    *rls.Service
+	peers        *peersync.Syncer
+	cluster      *cluster.Cluster
+	leases       *ratelimit.Leases
+	counters     *database.Counters
+	failureMode  ratelimit.FailureMode
+	plans        *plans.Resolver
+	planStore    *database.Plans
+	quotas       *quota.Quotas
+	keys         *apikey.Authenticator
+	keyStore     *database.APIKeys
+	jwt          *jwtauth.Verifier
+	jwtConfig    middleware.JWTConfig
+	authRequired bool
+	meter        *metering.Meter
+	meterStore   *database.Metering
+	snapshotPath string
+	cancel       context.CancelFunc
 }
 
-func NewServer() *http.Server {
+// NewServer builds the server from the environment, returning it with the
+// HTTP server serving its routes.
+func NewServer() (*Server, *http.Server) {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	db := database.New()
 	NewServer := &Server{
 		port: port,
 
-		db: database.New(),
+		db:           db,
+		peers:        loadPeerSync(),
+		cluster:      loadCluster(),
+		snapshotPath: loadSnapshotPath(),
 	}
+	NewServer.leases, NewServer.counters = loadLeases(db)
+	NewServer.failureMode = loadFailureMode()
+	NewServer.jwt, NewServer.jwtConfig = loadJWT()
+	NewServer.authRequired = loadAuthRequired()
+	NewServer.decisions = NewServer.loadDecisionService()
+	NewServer.plans, NewServer.planStore = NewServer.loadPlans(db)
+	NewServer.admission = middleware.NewAdmissionController(NewServer.loadAdmissionConfig())
+	NewServer.keys, NewServer.keyStore = NewServer.loadAPIKeys(db)
+	NewServer.quotas = NewServer.loadQuotas(db)
+	NewServer.meter, NewServer.meterStore = NewServer.loadMeter(db)
 
 	// Declare Server config
 	server := &http.Server{
@@ -37,7 +82,19 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	stopGRPC, err := NewServer.startDecisionGRPC()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server.RegisterOnShutdown(stopGRPC)
+
+	stopInternal, err := NewServer.startInternal()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server.RegisterOnShutdown(stopInternal)
+
+	return NewServer, server
 }
 
 // Shutdown gracefully stops the background goroutines
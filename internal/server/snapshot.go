@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// loadSnapshotPath returns the file the rate limit state is saved to on
+// shutdown and restored from on startup, read from RATELIMIT_SNAPSHOT_PATH.
+// Snapshots are disabled when it is empty.
+func loadSnapshotPath() string {
+	return os.Getenv("RATELIMIT_SNAPSHOT_PATH")
+}
+
+// RestoreState loads the rate limit state saved by SaveState, so clients
+// keep their quota across restarts. A missing snapshot is not an error.
+func (s *Server) RestoreState() {
+	if s.snapshotPath == "" {
+		return
+	}
+
+	restored, err := restoreSnapshot(s.snapshotPath, time.Now())
+	if err != nil {
+		log.Printf("not restoring rate limit state: %v", err)
+		return
+	}
+	log.Printf("restored %d rate limit entries from %s", restored, s.snapshotPath)
+}
+
+// SaveState writes the rate limit state to the snapshot file. It is called
+// once the server stopped handling requests.
+func (s *Server) SaveState() {
+	if s.snapshotPath == "" {
+		return
+	}
+
+	if err := saveSnapshot(s.snapshotPath, time.Now()); err != nil {
+		log.Printf("error saving rate limit state: %v", err)
+		return
+	}
+	log.Printf("saved rate limit state to %s", s.snapshotPath)
+}
+
+func restoreSnapshot(path string, now time.Time) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error opening snapshot: %v", err)
+	}
+	defer f.Close()
+
+	return ratelimit.ReadSnapshot(f, now)
+}
+
+// saveSnapshot writes the snapshot next to path and renames it into place,
+// so a crash while writing never leaves a truncated snapshot behind.
+func saveSnapshot(path string, now time.Time) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating snapshot: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := ratelimit.WriteSnapshot(f, now); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error saving snapshot: %v", err)
+	}
+	return nil
+}
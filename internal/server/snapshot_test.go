@@ -0,0 +1,45 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.snapshot")
+	key := "snapshot-file-client"
+	ratelimit.ResetKey(key)
+	t.Cleanup(func() { ratelimit.ResetKey(key) })
+
+	// A missing snapshot is a fresh start
+	if restored, err := restoreSnapshot(path, time.Now()); err != nil || restored != 0 {
+		t.Fatalf("missing snapshot: got %d, %v; want 0, nil", restored, err)
+	}
+
+	ratelimit.CheckFixedWindowLimit(key, 1, time.Hour)
+	if err := saveSnapshot(path, time.Now()); err != nil {
+		t.Fatalf("saveSnapshot: %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	ratelimit.ResetKey(key)
+	if _, err := restoreSnapshot(path, time.Now()); err != nil {
+		t.Fatalf("restoreSnapshot: %v", err)
+	}
+	if ratelimit.CheckFixedWindowLimit(key, 1, time.Hour) {
+		t.Errorf("restored client should still be over its limit")
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreSnapshot(path, time.Now()); err == nil {
+		t.Errorf("corrupt snapshot should fail to restore")
+	}
+}
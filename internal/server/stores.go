@@ -0,0 +1,30 @@
+package server
+
+import (
+	"log"
+	"os"
+
+	"api-rate-limiting/internal/pkg/ratelimit"
+)
+
+// loadStoreConfig reads the rate limit state store bounds from the environment.
+//
+//	RATELIMIT_MAX_KEYS        keys tracked per algorithm before LRU eviction (default 100000)
+//	RATELIMIT_OVERFLOW        evict, fail-open or fail-closed when even the LRU key is active (default evict)
+//	RATELIMIT_ACTIVE_WINDOW   how recently the LRU key must be seen to count as active (default 10s)
+func loadStoreConfig() ratelimit.StoreConfig {
+	cfg := ratelimit.StoreConfig{
+		MaxKeys:      envInt("RATELIMIT_MAX_KEYS", 0),
+		ActiveWindow: envDuration("RATELIMIT_ACTIVE_WINDOW", 0),
+	}
+	if v := os.Getenv("RATELIMIT_OVERFLOW"); v != "" {
+		switch overflow := ratelimit.Overflow(v); overflow {
+		case ratelimit.OverflowEvict, ratelimit.OverflowFailOpen, ratelimit.OverflowFailClosed:
+			cfg.Overflow = overflow
+		default:
+			log.Printf("ignoring invalid RATELIMIT_OVERFLOW %q", v)
+		}
+	}
+
+	return cfg
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/database"
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+)
+
+// loadQuotas builds the quotas of plans from the environment. Quotas are
+// counted per customer, so they are only enforced when plans are enabled.
+//
+//	QUOTA_TIMEZONE   IANA timezone daily and monthly quotas reset in (default UTC)
+func (s *Server) loadQuotas(db database.Service) *quota.Quotas {
+	if s.plans == nil {
+		return nil
+	}
+
+	usage := db.Usage()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := usage.EnsureSchema(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var cfg quota.Config
+	if v := os.Getenv("QUOTA_TIMEZONE"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			log.Printf("ignoring invalid QUOTA_TIMEZONE %q: %v", v, err)
+		} else {
+			cfg.Location = loc
+		}
+	}
+
+	return quota.New(usage, cfg)
+}
+
+// quota enforces the quota the caller's plan sets on a route. It must
+// follow s.limit in the route's handlers.
+func (s *Server) quota() gin.HandlerFunc {
+	if s.quotas == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return middleware.Quota(s.quotas)
+}
+
+// QuotaUsage is the consumption of one quota in its current period.
+type QuotaUsage struct {
+	Quota       string       `json:"quota"`
+	Period      quota.Period `json:"period"`
+	Limit       int          `json:"limit"`
+	Used        int          `json:"used"`
+	Remaining   int          `json:"remaining"`
+	PeriodStart time.Time    `json:"period_start"`
+	PeriodEnd   time.Time    `json:"period_end"`
+}
+
+// usageHistory is how many months of past usage /v1/usage returns.
+const usageHistory = 12
+
+// usageHandler shows customers, identified by their API key, the quotas of
+// their plan and their consumption per period.
+func (s *Server) usageHandler(c *gin.Context) {
+	if s.quotas == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "quotas are disabled"})
+		return
+	}
+
+	customer := c.GetString(middleware.CustomerKey)
+	if customer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+		return
+	}
+	sub, err := s.plans.Resolve(c.Request.Context(), customer)
+	switch {
+	case errors.Is(err, plans.ErrUnknownCustomer):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown customer"})
+		return
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "usage is unavailable, try again later"})
+		return
+	}
+
+	thisMonth, _ := s.quotas.Bounds(quota.Quota{Period: quota.Monthly})
+	history, err := s.quotas.Usage(c.Request.Context(), sub.Customer, thisMonth.AddDate(0, 1-usageHistory, 0))
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "usage is unavailable, try again later"})
+		return
+	}
+
+	current := []QuotaUsage{}
+	for route, q := range sub.Plan.Quotas {
+		start, end := s.quotas.Bounds(q)
+		u := QuotaUsage{Quota: route, Period: q.Period, Limit: q.Limit, PeriodStart: start, PeriodEnd: end}
+		for _, h := range history {
+			if h.Quota == route && h.PeriodStart.Equal(start) {
+				u.Used = h.Used
+			}
+		}
+		u.Remaining = max(q.Limit-u.Used, 0)
+		current = append(current, u)
+	}
+	sort.Slice(current, func(i, j int) bool { return current[i].Quota < current[j].Quota })
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer": sub.Customer,
+		"plan":     sub.Plan.Name,
+		"quotas":   current,
+		"history":  history,
+	})
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"api-rate-limiting/internal/pkg/apikey"
+	"api-rate-limiting/internal/pkg/middleware"
+	"api-rate-limiting/internal/pkg/plans"
+	"api-rate-limiting/internal/pkg/quota"
+)
+
+type staticPlans map[string]plans.Subscription
+
+func (s staticPlans) Subscription(_ context.Context, customer string) (plans.Subscription, error) {
+	sub, ok := s[customer]
+	if !ok {
+		return plans.Subscription{}, plans.ErrUnknownCustomer
+	}
+	return sub, nil
+}
+
+func (s staticPlans) Plan(_ context.Context, name string) (plans.Plan, error) {
+	for _, sub := range s {
+		if sub.Plan.Name == name {
+			return sub.Plan, nil
+		}
+	}
+	return plans.Plan{}, plans.ErrUnknownPlan
+}
+
+func TestUsageHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pro := plans.Plan{Name: "pro", Quotas: map[string]quota.Quota{
+		"/instagram/download": {Period: quota.Monthly, Limit: 10000},
+	}}
+	reader, readerSecret := apikey.Generate("acme", []string{scopeUsageRead}, time.Time{})
+	downloader, downloaderSecret := apikey.Generate("acme", []string{scopeInstagramDownload}, time.Time{})
+	s := &Server{
+		plans:  plans.NewResolver(staticPlans{"acme": {Customer: "acme", Plan: pro}}, plans.Config{}),
+		quotas: quota.New(quota.NewMemoryStore(), quota.Config{}),
+		keys:   apikey.NewAuthenticator(apikey.NewMemoryStore(reader, downloader), apikey.Config{}),
+	}
+	for i := 0; i < 3; i++ {
+		s.quotas.Consume(context.Background(), "acme", "/instagram/download", pro.Quotas["/instagram/download"], 1)
+	}
+
+	r := gin.New()
+	r.Use(s.identify()...)
+	r.GET("/v1/usage", middleware.RequireScope(scopeUsageRead), s.usageHandler)
+
+	for _, tt := range []struct {
+		name, secret string
+		status       int
+	}{
+		{"without a key", "", http.StatusUnauthorized},
+		{"with an invalid key", "bogus", http.StatusUnauthorized},
+		{"without the scope", downloaderSecret, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/v1/usage", nil)
+		if tt.secret != "" {
+			req.Header.Set("X-API-Key", tt.secret)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/v1/usage", nil)
+	req.Header.Set("X-API-Key", readerSecret)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d want 200", w.Code)
+	}
+
+	var body struct {
+		Plan    string        `json:"plan"`
+		Quotas  []QuotaUsage  `json:"quotas"`
+		History []quota.Usage `json:"history"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if body.Plan != "pro" || len(body.Quotas) != 1 || body.Quotas[0].Used != 3 || body.Quotas[0].Remaining != 9997 {
+		t.Errorf("got %+v, want 3 of 10000 used on pro", body)
+	}
+	if len(body.History) != 1 || body.History[0].Used != 3 {
+		t.Errorf("got history %+v, want this month", body.History)
+	}
+}
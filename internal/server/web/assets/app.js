@@ -0,0 +1,241 @@
+"use strict";
+
+const REFRESH_INTERVAL = 5000;
+const tokenInput = document.getElementById("token");
+
+tokenInput.value = sessionStorage.getItem("adminToken") || "";
+tokenInput.addEventListener("change", () => {
+  sessionStorage.setItem("adminToken", tokenInput.value);
+  refresh();
+});
+
+async function api(method, path, body) {
+  const headers = { "Accept": "application/json" };
+  if (tokenInput.value) {
+    headers["Authorization"] = "Bearer " + tokenInput.value;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+
+  const resp = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function cell(text) {
+  const td = document.createElement("td");
+  td.textContent = text === undefined || text === null ? "" : String(text);
+  return td;
+}
+
+function fillTable(id, rows, empty) {
+  const tbody = document.getElementById(id);
+  tbody.replaceChildren();
+  if (rows.length === 0) {
+    const tr = document.createElement("tr");
+    const td = cell(empty);
+    td.colSpan = 5;
+    td.className = "muted";
+    tr.appendChild(td);
+    tbody.appendChild(tr);
+    return;
+  }
+  rows.forEach((tr) => tbody.appendChild(tr));
+}
+
+function formatTime(value) {
+  if (!value || value.startsWith("0001-")) {
+    return "";
+  }
+  return new Date(value).toLocaleTimeString();
+}
+
+function renderHealth(health) {
+  const el = document.getElementById("health");
+  el.replaceChildren();
+
+  const status = document.createElement("div");
+  status.className = health.status === "up" ? "up" : "down";
+  status.textContent = (health.status || "unknown").toUpperCase();
+  el.appendChild(status);
+
+  const message = document.createElement("div");
+  message.className = "muted";
+  message.textContent = health.message || health.error || "";
+  el.appendChild(message);
+
+  if (health.open_connections !== undefined) {
+    const conns = document.createElement("div");
+    conns.className = "muted";
+    conns.textContent = `connections: ${health.open_connections} open, ${health.in_use} in use, ${health.idle} idle`;
+    el.appendChild(conns);
+  }
+}
+
+function renderPolicies(policies) {
+  fillTable("policies", policies.map((p) => {
+    const tr = document.createElement("tr");
+    [p.name, p.algorithm, p.limit, p.window, p.burst].forEach((v) => tr.appendChild(cell(v)));
+    return tr;
+  }), "No policies registered");
+}
+
+function sparkline(series) {
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  const width = 600;
+  const height = 60;
+  svg.setAttribute("viewBox", `0 0 ${width} ${height}`);
+  svg.setAttribute("preserveAspectRatio", "none");
+
+  const max = Math.max(1, ...series.map((s) => s.allowed + s.denied));
+  const barWidth = width / series.length;
+
+  series.forEach((s, i) => {
+    const allowHeight = (s.allowed / max) * height;
+    const denyHeight = (s.denied / max) * height;
+
+    const allow = document.createElementNS(ns, "rect");
+    allow.setAttribute("class", "bar-allow");
+    allow.setAttribute("x", i * barWidth);
+    allow.setAttribute("y", height - allowHeight);
+    allow.setAttribute("width", Math.max(barWidth - 1, 1));
+    allow.setAttribute("height", allowHeight);
+    svg.appendChild(allow);
+
+    const deny = document.createElementNS(ns, "rect");
+    deny.setAttribute("class", "bar-deny");
+    deny.setAttribute("x", i * barWidth);
+    deny.setAttribute("y", height - allowHeight - denyHeight);
+    deny.setAttribute("width", Math.max(barWidth - 1, 1));
+    deny.setAttribute("height", denyHeight);
+    svg.appendChild(deny);
+
+    const title = document.createElementNS(ns, "title");
+    title.textContent = `${formatTime(s.time)}: ${s.allowed} allowed, ${s.denied} denied`;
+    allow.appendChild(title);
+  });
+
+  return svg;
+}
+
+function renderRoutes(stats) {
+  const el = document.getElementById("routes");
+  el.replaceChildren();
+
+  if (stats.routes.length === 0) {
+    el.textContent = "No rate limited traffic yet";
+    el.className = "muted";
+    return;
+  }
+  el.className = "";
+
+  stats.routes.forEach((r) => {
+    const recent = r.series.slice(-6);
+    const allowed = recent.reduce((n, s) => n + s.allowed, 0);
+    const denied = recent.reduce((n, s) => n + s.denied, 0);
+    const seconds = recent.length * stats.slot_seconds;
+
+    const wrapper = document.createElement("div");
+    wrapper.className = "route";
+
+    const title = document.createElement("div");
+    title.className = "route-title";
+    const name = document.createElement("strong");
+    name.textContent = `${r.route} · ${r.policy}`;
+    const rates = document.createElement("span");
+    rates.className = "muted";
+    rates.textContent = `${(allowed / seconds).toFixed(2)} allowed/s · ${(denied / seconds).toFixed(2)} denied/s · total ${r.allowed}/${r.denied}`;
+    title.append(name, rates);
+
+    wrapper.append(title, sparkline(r.series));
+    el.appendChild(wrapper);
+  });
+}
+
+function renderThrottled(keys) {
+  fillTable("throttled", keys.map((k) => {
+    const tr = document.createElement("tr");
+    [k.key, k.denied, k.allowed, formatTime(k.last_denied)].forEach((v) => tr.appendChild(cell(v)));
+
+    const actions = document.createElement("td");
+    const reset = document.createElement("button");
+    reset.textContent = "Reset";
+    reset.addEventListener("click", () => submitKey("reset", k.key));
+    actions.appendChild(reset);
+    tr.appendChild(actions);
+    return tr;
+  }), "No throttled keys");
+}
+
+function renderOverrides(overrides) {
+  fillTable("overrides", overrides.map((o) => {
+    const tr = document.createElement("tr");
+    [o.key, o.limit, formatTime(o.expires_at) || "never"].forEach((v) => tr.appendChild(cell(v)));
+    return tr;
+  }), "No overrides");
+}
+
+async function submitKey(action, key, limit, ttl) {
+  const result = document.getElementById("form-result");
+  const path = "/admin/keys/" + encodeURIComponent(key);
+
+  try {
+    if (action === "reset") {
+      await api("DELETE", path);
+      result.textContent = `Reset ${key}`;
+    } else if (action === "override") {
+      await api("PUT", path + "/override", { limit: Number(limit), ttl: ttl || "" });
+      result.textContent = `Override for ${key} set to ${limit}`;
+    } else {
+      await api("DELETE", path + "/override");
+      result.textContent = `Override for ${key} cleared`;
+    }
+  } catch (err) {
+    result.textContent = `Failed: ${err.message}`;
+  }
+  refresh();
+}
+
+document.getElementById("key-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const form = event.target;
+  const action = event.submitter ? event.submitter.value : "reset";
+  if (action === "override" && form.limit.value === "") {
+    document.getElementById("form-result").textContent = "A limit is required to override a key";
+    return;
+  }
+  submitKey(action, form.key.value, form.limit.value, form.ttl.value);
+});
+
+async function refresh() {
+  const health = fetch("/health").then((r) => r.json()).catch((err) => ({ status: "down", error: err.message }));
+
+  try {
+    const [policies, stats, overrides] = await Promise.all([
+      api("GET", "/admin/policies"),
+      api("GET", "/admin/stats?top=10"),
+      api("GET", "/admin/overrides"),
+    ]);
+    renderPolicies(policies.policies);
+    renderRoutes(stats);
+    renderThrottled(stats.top_throttled);
+    renderOverrides(overrides.overrides);
+    document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    document.getElementById("updated").textContent = "Admin API: " + err.message;
+  }
+
+  renderHealth(await health);
+}
+
+refresh();
+setInterval(refresh, REFRESH_INTERVAL);